@@ -1,21 +1,145 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/impossiblecloud/pd-cert-assistant/internal/cfg"
 	"github.com/impossiblecloud/pd-cert-assistant/internal/utils"
 )
 
 const (
-	ApiIPsPath    = "/api/v1/ips"
-	ApiAllIPsPath = "/api/v1/allips"
+	ApiIPsPath       = "/api/v1/ips"
+	ApiAllIPsPath    = "/api/v1/allips"
+	ApiSignedIPsPath = "/api/v2/ips"
+	ApiStatusPath    = "/api/v2/status"
+	ApiNotifyPath    = "/api/v2/notify"
+	ApiPeersPath     = "/api/v2/peers"
+
+	ApiAdminReconcilePath = "/api/v2/admin/reconcile"
+	ApiAdminPausePath     = "/api/v2/admin/pause"
+	ApiAdminResumePath    = "/api/v2/admin/resume"
+	ApiAdminApprovePath   = "/api/v2/admin/approve"
+)
+
+// PauseRequest is the optional body of admin pause requests.
+type PauseRequest struct {
+	Reason string `json:"reason"`
+}
+
+// ApproveRequest is the optional body of admin approve requests, Hash must match the blocked change if set.
+type ApproveRequest struct {
+	Hash string `json:"hash"`
+}
+
+// AdvertiseURLHeader holds the advertise URL of the pd-assistant answering a membership exchange,
+// so a pd-assistant recognizes itself behind a seed URL.
+const AdvertiseURLHeader = "X-PD-Assistant-Advertise-URL"
+
+// Member is a pd-assistant in gossip membership with its last known heartbeat.
+// Incarnation is the start time of the pd-assistant in Unix milliseconds, heartbeats restart from 1 with a new one.
+type Member struct {
+	URL         string `json:"url"`
+	Incarnation uint64 `json:"incarnation,omitempty"`
+	Heartbeat   uint64 `json:"heartbeat"`
+}
+
+// NotifyRequest is sent to peers when the local IP set of a pd-assistant changed.
+type NotifyRequest struct {
+	// Source identifies the notifying pd-assistant
+	Source string `json:"source"`
+	// Hash is the v2 content hash of the new local IP set
+	Hash string `json:"hash"`
+}
+
+// ContentHash returns the v2 content hash of an IP set: a hex encoded SHA-256 of the sorted canonical IPs.
+// It doesn't depend on the order of IPs, so peers can compare IP sets without exchanging them.
+func ContentHash(ips []string) string {
+	normalized := utils.NormalizeIPs(ips)
+	slices.Sort(normalized)
+	sum := sha256.Sum256([]byte(strings.Join(normalized, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// Fetch error reasons reported for errors which aren't refusals by the PD Assistant
+const (
+	FetchErrorRequestFailed   = "request_failed"
+	FetchErrorBadStatus       = "bad_status"
+	FetchErrorInvalidResponse = "invalid_response"
+	FetchErrorEmpty           = "empty"
 )
 
+// ErrorResponse is the JSON body of error responses, Reason is set when the error is machine-readable.
+type ErrorResponse struct {
+	Error  string `json:"error"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// UnavailableError is returned when a PD Assistant refuses to serve IPs it doesn't trust yet.
+type UnavailableError struct {
+	Address    string
+	Reason     string
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("pd-assistant %s is unavailable (%s): %s, retry after %s", e.Address, e.Reason, e.Message, e.RetryAfter)
+}
+
+// FetchErrorReason returns the reason for a failed fetch, used as metric label.
+func FetchErrorReason(err error) string {
+	var unavailable *UnavailableError
+	if errors.As(err, &unavailable) {
+		return unavailable.Reason
+	}
+	var fetchErr *fetchError
+	if errors.As(err, &fetchErr) {
+		return fetchErr.reason
+	}
+	return FetchErrorRequestFailed
+}
+
+// fetchError wraps other fetch errors with their reason
+type fetchError struct {
+	reason string
+	err    error
+}
+
+func (e *fetchError) Error() string {
+	return e.err.Error()
+}
+
+// unavailableError parses a 503 response of a PD Assistant.
+func unavailableError(pdaAddress string, resp *http.Response) error {
+	unavailable := &UnavailableError{Address: pdaAddress}
+	var body ErrorResponse
+	if err := utils.ParseJSONResponse(resp.Body, &body); err != nil || body.Reason == "" {
+		return &fetchError{reason: FetchErrorBadStatus, err: fmt.Errorf("received non-OK HTTP status from %s: %s", pdaAddress, resp.Status)}
+	}
+	unavailable.Reason = body.Reason
+	unavailable.Message = body.Error
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		unavailable.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return unavailable
+}
+
 // For now we don't really have any API, just parsing JSON response with []string data in it.
-func getIPs(conf cfg.AppConfig, pdaAddress, path string) ([]string, error) {
+func getIPs(ctx context.Context, conf cfg.AppConfig, pdaAddress, path string) ([]string, error) {
 	fullAddress := pdaAddress + path
-	resp, err := utils.MakeHTTPRequest(fullAddress, "", "", "", conf.PDAssistantTLSInsecure, conf.HTTPRequestTimeout, conf.BearerToken)
+	peerTLS := conf.PeerAuthConfig.TLSConfig
+	resp, err := utils.MakeHTTPRequest(ctx, fullAddress, peerTLS.CertPath, peerTLS.KeyPath, peerTLS.CAPath, conf.PDAssistantTLSInsecure, conf.HTTPRequestTimeout, conf.Tokens.Current())
 	// Check if the request was successful
 	if err != nil {
 		return nil, fmt.Errorf("failed to make HTTPS request to %s: %s", pdaAddress, err.Error())
@@ -23,25 +147,92 @@ func getIPs(conf cfg.AppConfig, pdaAddress, path string) ([]string, error) {
 	defer resp.Body.Close()
 
 	// Check if the response status is OK
+	if resp.StatusCode == http.StatusServiceUnavailable {
+		return nil, unavailableError(pdaAddress, resp)
+	}
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("received non-OK HTTP status from %s: %s", pdaAddress, resp.Status)
+		return nil, &fetchError{reason: FetchErrorBadStatus, err: fmt.Errorf("received non-OK HTTP status from %s: %s", pdaAddress, resp.Status)}
 	}
 
 	// Parse the JSON response
+	if path == ApiSignedIPsPath {
+		var signed SignedIPsResponse
+		if err := utils.ParseJSONResponse(resp.Body, &signed); err != nil {
+			return nil, &fetchError{reason: FetchErrorInvalidResponse, err: fmt.Errorf("failed to parse JSON response from %s: %s", pdaAddress, err.Error())}
+		}
+		payload, err := VerifySignedIPs(pdaAddress, signed, conf.SigningConfig, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		return payload.IPs, nil
+	}
 	var ips []string
 	if err := utils.ParseJSONResponse(resp.Body, &ips); err != nil {
-		return nil, fmt.Errorf("failed to parse JSON response from %s: %s", pdaAddress, err.Error())
+		return nil, &fetchError{reason: FetchErrorInvalidResponse, err: fmt.Errorf("failed to parse JSON response from %s: %s", pdaAddress, err.Error())}
 	}
 
 	return ips, nil
 }
 
 // GetIPs fetches local IP addresses from the PD Assistant instances.
-func GetLocalIPs(conf cfg.AppConfig, pdaAddress string) ([]string, error) {
-	return getIPs(conf, pdaAddress, ApiIPsPath)
+// Signed IPs are fetched and verified when a keyring of peer public keys is configured.
+func GetLocalIPs(ctx context.Context, conf cfg.AppConfig, pdaAddress string) ([]string, error) {
+	if conf.SigningConfig.Keyring != nil {
+		return getIPs(ctx, conf, pdaAddress, ApiSignedIPsPath)
+	}
+	return getIPs(ctx, conf, pdaAddress, ApiIPsPath)
 }
 
 // GetIAllPs fetches all IP addresses from the PD Assistant instances.
-func GetAllIPs(conf cfg.AppConfig, pdaAddress string) ([]string, error) {
-	return getIPs(conf, pdaAddress, ApiAllIPsPath)
+// They aren't signed, they are only compared in the consensus check and never written to the certificate,
+// which only holds local IPs verified by the leader itself. A forged response can only change the outcome of the check,
+// the peer TLS and authentication protect it like the other unsigned endpoints.
+func GetAllIPs(ctx context.Context, conf cfg.AppConfig, pdaAddress string) ([]string, error) {
+	return getIPs(ctx, conf, pdaAddress, ApiAllIPsPath)
+}
+
+// Notify tells the PD Assistant instance that the local IP set of the source has changed.
+func Notify(ctx context.Context, conf cfg.AppConfig, pdaAddress string, notification NotifyRequest) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %v", err)
+	}
+	peerTLS := conf.PeerAuthConfig.TLSConfig
+	resp, err := utils.MakeHTTPRequestWithMethod(ctx, http.MethodPost, pdaAddress+ApiNotifyPath, bytes.NewReader(body),
+		peerTLS.CertPath, peerTLS.KeyPath, peerTLS.CAPath, conf.PDAssistantTLSInsecure, conf.HTTPRequestTimeout, conf.Tokens.Current())
+	if err != nil {
+		return fmt.Errorf("failed to make HTTPS request to %s: %s", pdaAddress, err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("received non-OK HTTP status from %s: %s", pdaAddress, resp.Status)
+	}
+	return nil
+}
+
+// ExchangeMembers sends the known members to the PD Assistant instance and returns the members it knows
+// along with its advertise URL, if it sent one.
+func ExchangeMembers(ctx context.Context, conf cfg.AppConfig, pdaAddress string, members []Member) ([]Member, string, error) {
+	body, err := json.Marshal(members)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode members: %v", err)
+	}
+	peerTLS := conf.PeerAuthConfig.TLSConfig
+	resp, err := utils.MakeHTTPRequestWithMethod(ctx, http.MethodPost, pdaAddress+ApiPeersPath, bytes.NewReader(body),
+		peerTLS.CertPath, peerTLS.KeyPath, peerTLS.CAPath, conf.PDAssistantTLSInsecure, conf.HTTPRequestTimeout, conf.Tokens.Current())
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to make HTTPS request to %s: %s", pdaAddress, err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("received non-OK HTTP status from %s: %s", pdaAddress, resp.Status)
+	}
+
+	var remote []Member
+	if err := utils.ParseJSONResponse(resp.Body, &remote); err != nil {
+		return nil, "", fmt.Errorf("failed to parse JSON response from %s: %s", pdaAddress, err.Error())
+	}
+	return remote, resp.Header.Get(AdvertiseURLHeader), nil
 }
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/cfg"
+)
+
+// Fetch error reasons of signed responses
+const (
+	FetchErrorInvalidSignature = "invalid_signature"
+	FetchErrorUnsigned         = "unsigned"
+	FetchErrorUnknownSigner    = "unknown_signer"
+	FetchErrorExpired          = "expired"
+	FetchErrorClusterMismatch  = "cluster_mismatch"
+	FetchErrorUnknownPeer      = "unknown_peer"
+)
+
+// signatureContext is prepended to signed payloads, so signatures of IP lists can't be taken for anything else
+const signatureContext = "pd-assistant/ips/v2\n"
+
+// SignedIPsPayload binds local IPs to the cluster serving them and the time they were served.
+type SignedIPsPayload struct {
+	ClusterID string   `json:"cluster_id"`
+	Timestamp int64    `json:"timestamp"`
+	IPs       []string `json:"ips"`
+}
+
+// SignedIPsResponse is the body of the v2 IPs endpoint: the JSON encoded payload and its Ed25519 signature.
+// The signature is empty when the PD Assistant has no signing key.
+type SignedIPsResponse struct {
+	Payload   []byte `json:"payload"`
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// SignIPs returns the signed response for local IPs, it's unsigned if the key is nil.
+func SignIPs(key ed25519.PrivateKey, clusterID string, ips []string, now time.Time) (SignedIPsResponse, error) {
+	payload, err := json.Marshal(SignedIPsPayload{ClusterID: clusterID, Timestamp: now.Unix(), IPs: ips})
+	if err != nil {
+		return SignedIPsResponse{}, fmt.Errorf("failed to encode IP addresses: %v", err)
+	}
+	signed := SignedIPsResponse{Payload: payload}
+	if key != nil {
+		signed.Signature = ed25519.Sign(key, append([]byte(signatureContext), payload...))
+	}
+	return signed, nil
+}
+
+// VerifySignedIPs verifies the signature, cluster and age of a signed response and returns its payload.
+// Invalid signatures and responses of another cluster than the one the peer is mapped to are always refused.
+// Unsigned responses, responses of clusters missing in the keyring, of peers without a cluster
+// and expired responses are only refused in strict mode, otherwise they are logged.
+func VerifySignedIPs(pdaAddress string, signed SignedIPsResponse, conf cfg.SigningConfig, now time.Time) (SignedIPsPayload, error) {
+	var payload SignedIPsPayload
+	if err := json.Unmarshal(signed.Payload, &payload); err != nil {
+		return payload, &fetchError{reason: FetchErrorInvalidResponse, err: fmt.Errorf("failed to parse signed payload from %s: %v", pdaAddress, err)}
+	}
+
+	var untrusted *fetchError
+	keys, known := conf.Keyring[payload.ClusterID]
+	switch {
+	case len(signed.Signature) == 0:
+		untrusted = &fetchError{reason: FetchErrorUnsigned, err: fmt.Errorf("response of cluster %q from %s is not signed", payload.ClusterID, pdaAddress)}
+	case !known:
+		untrusted = &fetchError{reason: FetchErrorUnknownSigner, err: fmt.Errorf("cluster %q of %s has no key in the keyring", payload.ClusterID, pdaAddress)}
+	default:
+		message := append([]byte(signatureContext), signed.Payload...)
+		valid := false
+		for _, key := range keys {
+			if ed25519.Verify(key, message, signed.Signature) {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return payload, &fetchError{reason: FetchErrorInvalidSignature, err: fmt.Errorf("invalid signature of cluster %q from %s", payload.ClusterID, pdaAddress)}
+		}
+	}
+	if untrusted == nil {
+		expected, mapped := conf.PeerClusters[pdaAddress]
+		switch {
+		case mapped && expected != payload.ClusterID:
+			return payload, &fetchError{reason: FetchErrorClusterMismatch, err: fmt.Errorf("response from %s is signed by cluster %q instead of %q", pdaAddress, payload.ClusterID, expected)}
+		case !mapped:
+			untrusted = &fetchError{reason: FetchErrorUnknownPeer, err: fmt.Errorf("%s has no cluster in the keyring, it may serve any cluster's response", pdaAddress)}
+		}
+	}
+	if untrusted == nil {
+		age := now.Sub(time.Unix(payload.Timestamp, 0))
+		if maxAge := time.Duration(conf.MaxAge) * time.Second; age > maxAge || age < -maxAge {
+			untrusted = &fetchError{reason: FetchErrorExpired, err: fmt.Errorf("signed response of cluster %q from %s is %s old", payload.ClusterID, pdaAddress, age.Round(time.Second))}
+		}
+	}
+
+	if untrusted != nil {
+		if conf.Strict {
+			return payload, untrusted
+		}
+		glog.Warningf("Accepting untrusted IPs: %v", untrusted)
+	}
+	return payload, nil
+}
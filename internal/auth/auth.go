@@ -0,0 +1,222 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"sigs.k8s.io/yaml"
+)
+
+// SecretKey is the key of the token file in a token Secret
+const SecretKey = "tokens.yaml"
+
+// Scopes of API credentials
+const (
+	// ScopePeerRead allows fetching IPs and membership
+	ScopePeerRead = "peer:read"
+	// ScopePeerWrite allows notifying about IP changes and gossiping membership
+	ScopePeerWrite = "peer:write"
+	// ScopeStatusRead allows reading the status and the dashboard
+	ScopeStatusRead = "status:read"
+	// ScopeAdminWrite allows admin operations, it's never granted by default
+	ScopeAdminWrite = "admin:write"
+)
+
+// DefaultScopes are granted to credentials without configured scopes, which are peers
+var DefaultScopes = []string{ScopePeerRead, ScopePeerWrite, ScopeStatusRead}
+
+// ValidateScopes checks all scopes are known.
+func ValidateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		if !slices.Contains([]string{ScopePeerRead, ScopePeerWrite, ScopeStatusRead, ScopeAdminWrite}, scope) {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	return nil
+}
+
+// Principal is an authenticated client with its granted scopes.
+type Principal struct {
+	Name   string
+	Scopes []string
+}
+
+// Allows checks if the principal was granted the scope.
+func (p Principal) Allows(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+// Token is a bearer token accepted from peers, Name identifies it in logs, it's the fingerprint if empty.
+// DefaultScopes are granted if Scopes are empty.
+type Token struct {
+	Value  string   `json:"value"`
+	Name   string   `json:"name,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// principal returns the principal authenticated by the token.
+func (t Token) principal() Principal {
+	p := Principal{Name: t.Name, Scopes: t.Scopes}
+	if p.Name == "" {
+		p.Name = Fingerprint(t.Value)
+	}
+	if len(p.Scopes) == 0 {
+		p.Scopes = DefaultScopes
+	}
+	return p
+}
+
+// ParseIdentityScopes parses scopes of mTLS identities in the "identity=scope|scope,identity=scope" format.
+func ParseIdentityScopes(line string) (map[string][]string, error) {
+	identityScopes := map[string][]string{}
+	for _, entry := range strings.Split(line, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		identity, scopes, ok := strings.Cut(entry, "=")
+		if !ok || identity == "" || scopes == "" {
+			return nil, fmt.Errorf("invalid identity scopes %q, expected identity=scope|scope", entry)
+		}
+		identityScopes[identity] = strings.Split(scopes, "|")
+		if err := ValidateScopes(identityScopes[identity]); err != nil {
+			return nil, fmt.Errorf("invalid scopes of identity %q: %v", identity, err)
+		}
+	}
+	return identityScopes, nil
+}
+
+// TokenFile is the format of token files and Secrets.
+// Current is sent to peers, Tokens are accepted from peers along with Current.
+// To rotate, add the new token to Tokens everywhere, then make it current everywhere, then remove the old one.
+type TokenFile struct {
+	Current string  `json:"current"`
+	Tokens  []Token `json:"tokens"`
+}
+
+// TokenSet holds the bearer tokens, it's shared by all copies of the config and replaced on reload.
+type TokenSet struct {
+	mu      sync.RWMutex
+	current string
+	tokens  []Token
+	// source is the raw token file, to detect changes
+	source []byte
+}
+
+// NewTokenSet returns a token set with a single token which is both sent and accepted.
+func NewTokenSet(token string) *TokenSet {
+	t := &TokenSet{}
+	if token != "" {
+		t.current = token
+		t.tokens = []Token{{Value: token}}
+	}
+	return t
+}
+
+// Fingerprint identifies a token in logs without revealing it.
+func Fingerprint(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "sha256:" + hex.EncodeToString(sum[:6])
+}
+
+// Equal compares two tokens in constant time.
+func Equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// Load replaces the tokens with the ones in a token file if it changed, it returns whether they changed.
+// The previous tokens are kept when the file is invalid.
+func (t *TokenSet) Load(data []byte) (bool, error) {
+	t.mu.RLock()
+	unchanged := t.source != nil && bytes.Equal(data, t.source)
+	t.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	var file TokenFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return false, fmt.Errorf("failed to parse token file: %v", err)
+	}
+	if file.Current == "" {
+		return false, fmt.Errorf("token file has no current token")
+	}
+	tokens := []Token{}
+	for i, token := range file.Tokens {
+		if token.Value == "" {
+			return false, fmt.Errorf("token %d in token file is empty", i)
+		}
+		if err := ValidateScopes(token.Scopes); err != nil {
+			return false, fmt.Errorf("token %d in token file: %v", i, err)
+		}
+		tokens = append(tokens, token)
+	}
+	// The current token is accepted with default scopes unless it's listed
+	if !slices.ContainsFunc(tokens, func(token Token) bool { return token.Value == file.Current }) {
+		tokens = append(tokens, Token{Value: file.Current})
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.current, t.tokens, t.source = file.Current, tokens, bytes.Clone(data)
+	return true, nil
+}
+
+// Current returns the token sent to peers, it's safe to call on a nil token set.
+func (t *TokenSet) Current() string {
+	if t == nil {
+		return ""
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.current
+}
+
+// Lookup returns the principal authenticated by a token, it's safe to call on a nil token set.
+// All tokens are compared in constant time, so the response time doesn't reveal which one matched.
+func (t *TokenSet) Lookup(token string) (Principal, bool) {
+	if t == nil || token == "" {
+		return Principal{}, false
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	match := -1
+	for i, accepted := range t.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(accepted.Value)) == 1 && match < 0 {
+			match = i
+		}
+	}
+	if match < 0 {
+		return Principal{}, false
+	}
+	return t.tokens[match].principal(), true
+}
+
+// Valid checks if a token is accepted, it's safe to call on a nil token set.
+func (t *TokenSet) Valid(token string) bool {
+	_, ok := t.Lookup(token)
+	return ok
+}
+
+// Fingerprints returns the fingerprints of the current token and all accepted tokens.
+func (t *TokenSet) Fingerprints() (string, []string) {
+	if t == nil {
+		return "", nil
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	accepted := make([]string, 0, len(t.tokens))
+	for _, token := range t.tokens {
+		accepted = append(accepted, Fingerprint(token.Value))
+	}
+	if t.current == "" {
+		return "", accepted
+	}
+	return Fingerprint(t.current), accepted
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenSet(t *testing.T) {
+	tokens := NewTokenSet("old")
+	assert.Equal(t, "old", tokens.Current())
+	assert.True(t, tokens.Valid("old"))
+	assert.False(t, tokens.Valid("new"))
+	assert.False(t, tokens.Valid(""))
+
+	// Accept the new token first, then make it current
+	changed, err := tokens.Load([]byte("current: old\ntokens:\n- value: new\n"))
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.True(t, tokens.Valid("old"))
+	assert.True(t, tokens.Valid("new"))
+
+	changed, err = tokens.Load([]byte("current: old\ntokens:\n- value: new\n"))
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	changed, err = tokens.Load([]byte("current: new\n"))
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "new", tokens.Current())
+	assert.False(t, tokens.Valid("old"))
+
+	// Invalid files keep the previous tokens
+	for _, data := range []string{"tokens:\n- value: other\n", "current: new\ntokens:\n- value: \"\"\n", "current: new\ntoken: other\n"} {
+		_, err = tokens.Load([]byte(data))
+		assert.Error(t, err, data)
+	}
+	assert.Equal(t, "new", tokens.Current())
+
+	var unset *TokenSet
+	assert.Equal(t, "", unset.Current())
+	assert.False(t, unset.Valid("new"))
+	assert.False(t, NewTokenSet("").Valid(""))
+}
+
+func TestFingerprint(t *testing.T) {
+	fingerprint := Fingerprint("secret")
+	assert.True(t, strings.HasPrefix(fingerprint, "sha256:"))
+	assert.NotContains(t, fingerprint, "secret")
+	assert.Equal(t, fingerprint, Fingerprint("secret"))
+	assert.NotEqual(t, fingerprint, Fingerprint("other"))
+
+	current, accepted := NewTokenSet("secret").Fingerprints()
+	assert.Equal(t, fingerprint, current)
+	assert.Equal(t, []string{fingerprint}, accepted)
+}
+
+func TestScopes(t *testing.T) {
+	tokens := NewTokenSet("")
+	_, err := tokens.Load([]byte("current: peer\ntokens:\n- value: admin\n  name: ops\n  scopes: [admin:write]\n"))
+	require.NoError(t, err)
+
+	peer, ok := tokens.Lookup("peer")
+	require.True(t, ok)
+	assert.Equal(t, Fingerprint("peer"), peer.Name)
+	assert.Equal(t, DefaultScopes, peer.Scopes)
+	assert.False(t, peer.Allows(ScopeAdminWrite))
+
+	admin, ok := tokens.Lookup("admin")
+	require.True(t, ok)
+	assert.Equal(t, "ops", admin.Name)
+	assert.True(t, admin.Allows(ScopeAdminWrite))
+	assert.False(t, admin.Allows(ScopePeerRead))
+
+	_, err = tokens.Load([]byte("current: peer\ntokens:\n- value: admin\n  scopes: [admin:read]\n"))
+	assert.Error(t, err)
+
+	identityScopes, err := ParseIdentityScopes("ops.example.com=status:read|admin:write, pd-assistant.eu=peer:read")
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"ops.example.com": {ScopeStatusRead, ScopeAdminWrite},
+		"pd-assistant.eu": {ScopePeerRead},
+	}, identityScopes)
+	for _, line := range []string{"ops.example.com", "=peer:read", "ops.example.com=admin"} {
+		_, err := ParseIdentityScopes(line)
+		assert.Error(t, err, line)
+	}
+}
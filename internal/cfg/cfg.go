@@ -1,63 +1,438 @@
 package cfg
 
 import (
+	"crypto/ed25519"
+	"errors"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"slices"
+	"strconv"
+	"time"
 
 	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/auth"
 	"github.com/impossiblecloud/pd-cert-assistant/internal/utils"
 	"sigs.k8s.io/yaml"
 )
 
 // TLSConfig holds the TLS configuration parameters.
 type TLSConfig struct {
-	CertPath string
-	KeyPath  string
-	CAPath   string
-	Insecure bool
+	CertPath string `json:"certPath"`
+	KeyPath  string `json:"keyPath"`
+	CAPath   string `json:"caPath"`
+	Insecure bool   `json:"insecure"`
 }
 
 // PDConfig holds the configuration parameters for the PD endpoint.
 type PDConfig struct {
-	Address            string
-	TLSConfig          TLSConfig
-	HTTPRequestTimeout int
+	Address            string    `json:"address"`
+	TLSConfig          TLSConfig `json:"tlsConfig"`
+	HTTPRequestTimeout int       `json:"httpRequestTimeout"`
 }
 
 // PDDiscoveryConfig holds the configuration parameters for the PD Discovery endpoint.
 type PDDiscoveryConfig struct {
-	URL                  string
-	TLSConfig            TLSConfig
-	HTTPRequestTimeout   int
-	TiDBCLusterName      string
-	TiDBCLusterNameSpace string
+	URL                  string    `json:"url"`
+	TLSConfig            TLSConfig `json:"tlsConfig"`
+	HTTPRequestTimeout   int       `json:"httpRequestTimeout"`
+	TiDBCLusterName      string    `json:"tidbClusterName"`
+	TiDBCLusterNameSpace string    `json:"tidbClusterNamespace"`
+}
+
+// LeaderElectionConfig holds the configuration parameters for Lease based leader election.
+type LeaderElectionConfig struct {
+	Enabled        bool   `json:"enabled"`
+	LeaseName      string `json:"leaseName"`
+	LeaseNamespace string `json:"leaseNamespace"`
+	// Identity of this replica, the hostname by default
+	Identity string `json:"identity"`
+	// LeaseDuration, RenewDeadline and RetryPeriod in seconds
+	LeaseDuration int `json:"leaseDuration"`
+	RenewDeadline int `json:"renewDeadline"`
+	RetryPeriod   int `json:"retryPeriod"`
+}
+
+// Issuer modes
+const (
+	IssuerModeCertManager = "cert-manager"
+	IssuerModeLocalCA     = "local-ca"
+	// IssuerModeCertificateRequest creates cert-manager CertificateRequests directly with a key kept by the assistant
+	IssuerModeCertificateRequest = "certificate-request"
+)
+
+// LocalCAConfig holds the configuration parameters for the built-in local CA issuer.
+type LocalCAConfig struct {
+	// SecretName and SecretNamespace point to a Secret with the CA keypair in tls.crt and tls.key
+	SecretName      string `json:"secretName"`
+	SecretNamespace string `json:"secretNamespace"`
+	// CertPath and KeyPath point to files with the CA keypair, alternatively to the Secret
+	CertPath string `json:"certPath"`
+	KeyPath  string `json:"keyPath"`
+}
+
+// Post-issuance actions
+const (
+	PostIssuanceActionNone                = "none"
+	PostIssuanceActionAnnotateStatefulSet = "annotate-statefulset"
+	PostIssuanceActionAnnotateTidbCluster = "annotate-tidbcluster"
+	PostIssuanceActionReloadEndpoint      = "reload-endpoint"
+)
+
+// PostIssuanceConfig holds the configuration parameters for the action run after a new certificate is issued.
+type PostIssuanceConfig struct {
+	// Action is one of the PostIssuanceAction* constants
+	Action string `json:"action"`
+	// TargetName and TargetNamespace point to the PD StatefulSet or TidbCluster to annotate
+	TargetName      string `json:"targetName"`
+	TargetNamespace string `json:"targetNamespace"`
+	// ReloadURL is the endpoint called with POST to reload PD certificates
+	ReloadURL string `json:"reloadUrl"`
+	// MinInterval is the minimum interval between two PD reloads in seconds
+	MinInterval int `json:"minInterval"`
+}
+
+// ReconcileConfig holds the configuration parameters for rate limiting certificate reconcile runs.
+type ReconcileConfig struct {
+	// MinInterval is the minimum interval between two reconcile runs in seconds
+	MinInterval int `json:"minInterval"`
+	// Jitter is the maximum random delay in seconds added to triggered runs, so replicas don't reconcile in lockstep
+	Jitter int `json:"jitter"`
+	// TemplateReloadInterval is the interval for checking the certificate template file for changes in seconds, 0 disables it
+	TemplateReloadInterval int `json:"templateReloadInterval"`
+	// MaxRemovedIPs is the number of IPs a certificate change may remove without approval through the admin API, 0 disables approvals
+	MaxRemovedIPs int `json:"maxRemovedIps"`
+}
+
+// NotifyConfig holds the configuration parameters for push notifications between pd-assistant peers.
+type NotifyConfig struct {
+	// Enabled sends notifications to all peers when the local IP set changes
+	Enabled bool `json:"enabled"`
+	// Source identifies this pd-assistant in notifications
+	Source string `json:"source"`
+	// MinInterval is the minimum interval between two accepted notifications from the same sender in seconds,
+	// later ones are deferred to its end
+	MinInterval int `json:"minInterval"`
+}
+
+// GossipConfig holds the configuration parameters for gossip based pd-assistant membership.
+type GossipConfig struct {
+	// Enabled discovers peers by exchanging membership with them, PD Assistant URLs or discovery only provide seeds
+	Enabled bool `json:"enabled"`
+	// AdvertiseURL is the URL peers use to reach this pd-assistant
+	AdvertiseURL string `json:"advertiseUrl"`
+	// Interval is the interval between two gossip rounds in seconds
+	Interval int `json:"interval"`
+	// Fanout is the number of peers contacted in every gossip round
+	Fanout int `json:"fanout"`
+	// SuspectTimeout is the time without heartbeat after which a peer is suspect in seconds
+	SuspectTimeout int `json:"suspectTimeout"`
+	// DeadTimeout is the time without heartbeat after which a peer is dead in seconds
+	DeadTimeout int `json:"deadTimeout"`
+	// DropDeadMembers leaves dead peers out of IP fetches, so their IPs are dropped from the certificate,
+	// and forgets them after another dead timeout. Otherwise reconciles fail while a peer is dead.
+	DropDeadMembers bool `json:"dropDeadMembers"`
+}
+
+// TLSServerConfig holds the configuration parameters for serving the HTTP API over TLS.
+type TLSServerConfig struct {
+	// CertPath and KeyPath point to the serving certificate files, reloaded when they change
+	CertPath string `json:"certPath"`
+	KeyPath  string `json:"keyPath"`
+	// SecretName and SecretNamespace point to a kubernetes.io/tls Secret with the serving certificate
+	SecretName      string `json:"secretName"`
+	SecretNamespace string `json:"secretNamespace"`
+	// ManagedSecret serves the certificate from the Secret managed by this assistant
+	ManagedSecret bool `json:"managedSecret"`
+	// MinVersion is the minimal TLS version, "1.2" or "1.3"
+	MinVersion string `json:"minVersion"`
+	// CipherSuites are the allowed TLS 1.2 cipher suite names, Go defaults are used if empty
+	CipherSuites []string `json:"cipherSuites"`
+}
+
+// Enabled checks if the HTTP API is served over TLS.
+func (t TLSServerConfig) Enabled() bool {
+	return t.CertPath != "" || t.KeyPath != "" || t.SecretName != "" || t.ManagedSecret
+}
+
+// TokenConfig holds the configuration parameters for loading rotatable bearer tokens.
+type TokenConfig struct {
+	// FilePath points to a token file, it's watched for changes
+	FilePath string `json:"filePath"`
+	// SecretName and SecretNamespace point to a Secret with the token file, alternatively to FilePath
+	SecretName      string `json:"secretName"`
+	SecretNamespace string `json:"secretNamespace"`
+	// ReloadInterval is the interval for checking the token file or Secret for changes in seconds
+	ReloadInterval int `json:"reloadInterval"`
+}
+
+// Enabled checks if bearer tokens are loaded from a token file or Secret instead of BEARER_TOKEN.
+func (t TokenConfig) Enabled() bool {
+	return t.FilePath != "" || t.SecretName != ""
+}
+
+// IPOverridesKey is the key of the IP overrides file in an IP overrides ConfigMap
+const IPOverridesKey = "overrides.yaml"
+
+// IPOverridesConfig holds the configuration parameters for loading manually pinned and excluded IPs.
+type IPOverridesConfig struct {
+	// FilePath points to an IP overrides file, it's watched for changes
+	FilePath string `json:"filePath"`
+	// ConfigMapName and ConfigMapNamespace point to a ConfigMap with the IP overrides file, alternatively to FilePath
+	ConfigMapName      string `json:"configMapName"`
+	ConfigMapNamespace string `json:"configMapNamespace"`
+	// ReloadInterval is the interval for checking the IP overrides file or ConfigMap for changes in seconds
+	ReloadInterval int `json:"reloadInterval"`
+}
+
+// Enabled checks if IP overrides are loaded from a file or ConfigMap.
+func (o IPOverridesConfig) Enabled() bool {
+	return o.FilePath != "" || o.ConfigMapName != ""
+}
+
+// SigningConfig holds the configuration parameters for signing local IPs served to peers and verifying IPs of peers.
+type SigningConfig struct {
+	// ClusterID identifies this cluster in signed responses
+	ClusterID string `json:"clusterId"`
+	// KeyPath points to the PEM encoded Ed25519 private key signing local IPs
+	KeyPath string `json:"keyPath"`
+	// KeyringPath points to a YAML file with public keys of peer clusters, peer signatures are verified if it's set
+	KeyringPath string `json:"keyringPath"`
+	// Strict refuses unsigned responses, responses signed by unknown clusters and expired responses
+	Strict bool `json:"strict"`
+	// MaxAge is the maximal age of signed responses in seconds
+	MaxAge int `json:"maxAge"`
+	// Key is loaded from KeyPath
+	Key ed25519.PrivateKey `json:"-"`
+	// Keyring is loaded from KeyringPath, peer signatures are verified if it's not nil
+	Keyring Keyring `json:"-"`
+	// PeerClusters is loaded from KeyringPath
+	PeerClusters PeerClusters `json:"-"`
+}
+
+// Keyring maps cluster IDs to their public keys, a cluster has multiple keys during key rotation.
+type Keyring map[string][]ed25519.PublicKey
+
+// PeerClusters maps PD Assistant URLs to the cluster ID their signed responses must have,
+// so a peer can't serve IPs signed for another cluster.
+type PeerClusters map[string]string
+
+// KeyringFile is the format of the keyring file.
+type KeyringFile struct {
+	Keys []struct {
+		ClusterID string `json:"cluster_id"`
+		// PublicKey is a PEM encoded Ed25519 public key
+		PublicKey string `json:"public_key"`
+	} `json:"keys"`
+	Peers []struct {
+		URL       string `json:"url"`
+		ClusterID string `json:"cluster_id"`
+	} `json:"peers"`
+}
+
+// LoadKeyring loads public keys of peer clusters and the clusters of peers from a keyring YAML file.
+func LoadKeyring(keyringFilePath string) (Keyring, PeerClusters, error) {
+	data, err := os.ReadFile(keyringFilePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read keyring file %s: %s", keyringFilePath, err.Error())
+	}
+	var file KeyringFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal keyring YAML: %s", err.Error())
+	}
+
+	keyring := Keyring{}
+	for i, entry := range file.Keys {
+		if entry.ClusterID == "" {
+			return nil, nil, fmt.Errorf("key %d in keyring has no cluster ID", i)
+		}
+		key, err := utils.ParseEd25519PublicKeyPEM([]byte(entry.PublicKey))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid key %d of cluster %q in keyring: %v", i, entry.ClusterID, err)
+		}
+		keyring[entry.ClusterID] = append(keyring[entry.ClusterID], key)
+	}
+	peers := PeerClusters{}
+	for i, entry := range file.Peers {
+		if err := ValidateURL(entry.URL); err != nil {
+			return nil, nil, fmt.Errorf("invalid URL %q of peer %d in keyring: %v", entry.URL, i, err)
+		}
+		if _, ok := keyring[entry.ClusterID]; !ok {
+			return nil, nil, fmt.Errorf("peer %s in keyring has cluster %q without keys", entry.URL, entry.ClusterID)
+		}
+		peers[entry.URL] = entry.ClusterID
+	}
+	return keyring, peers, nil
+}
+
+// IPOverride pins or excludes an IP address until it expires, it never expires without Expires.
+type IPOverride struct {
+	IP      string     `json:"ip"`
+	Reason  string     `json:"reason,omitempty"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// Expired checks if the override expired at the given time.
+func (o IPOverride) Expired(now time.Time) bool {
+	return o.Expires != nil && !now.Before(*o.Expires)
+}
+
+// IPOverrides are manual changes of the certificate IPs. Pinned IPs are always in the certificate,
+// e.g. of decommissioned nodes during a migration window, excluded IPs never are, even if discovered.
+type IPOverrides struct {
+	Pinned   []IPOverride `json:"pinned,omitempty"`
+	Excluded []IPOverride `json:"excluded,omitempty"`
+}
+
+// ParseIPOverrides parses an IP overrides YAML file, IPs are normalized.
+func ParseIPOverrides(data []byte) (IPOverrides, error) {
+	var overrides IPOverrides
+	if err := yaml.UnmarshalStrict(data, &overrides); err != nil {
+		return overrides, fmt.Errorf("failed to unmarshal IP overrides YAML: %s", err.Error())
+	}
+	seen := map[string]string{}
+	// Check pinned IPs first, so errors are reported in a stable order
+	for _, kind := range []struct {
+		name string
+		list []IPOverride
+	}{{"pinned", overrides.Pinned}, {"excluded", overrides.Excluded}} {
+		list := kind.list
+		for i := range list {
+			ip := net.ParseIP(list[i].IP)
+			if ip == nil {
+				return overrides, fmt.Errorf("invalid %s IP %q", kind.name, list[i].IP)
+			}
+			list[i].IP = ip.String()
+			if previous, ok := seen[list[i].IP]; ok {
+				return overrides, fmt.Errorf("IP %s is %s more than once", list[i].IP, previous)
+			}
+			seen[list[i].IP] = kind.name
+		}
+	}
+	return overrides, nil
+}
+
+// Active returns the overrides which didn't expire at the given time.
+func (o IPOverrides) Active(now time.Time) IPOverrides {
+	active := func(list []IPOverride) []IPOverride {
+		return slices.DeleteFunc(slices.Clone(list), func(override IPOverride) bool { return override.Expired(now) })
+	}
+	return IPOverrides{Pinned: active(o.Pinned), Excluded: active(o.Excluded)}
+}
+
+// Excludes checks if the IP is excluded.
+func (o IPOverrides) Excludes(ip string) bool {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		ip = parsed.String()
+	}
+	return slices.ContainsFunc(o.Excluded, func(override IPOverride) bool { return override.IP == ip })
+}
+
+// Peer authentication modes
+const (
+	PeerAuthBearer        = "bearer"
+	PeerAuthMTLS          = "mtls"
+	PeerAuthBearerAndMTLS = "bearer-and-mtls"
+	PeerAuthBearerOrMTLS  = "bearer-or-mtls"
+)
+
+// PeerAuthConfig holds the configuration parameters for authenticating pd-assistant peers.
+type PeerAuthConfig struct {
+	// Mode is one of the PeerAuth* constants
+	Mode string `json:"mode"`
+	// ClientCAPath is the CA bundle verifying client certificates of peers
+	ClientCAPath string `json:"clientCaPath"`
+	// AllowedSANs and AllowedCNs authorize verified client certificates by DNS, IP, URI or email SAN and by subject CN,
+	// any certificate signed by the client CA is authorized if both are empty
+	AllowedSANs []string `json:"allowedSans"`
+	AllowedCNs  []string `json:"allowedCns"`
+	// IdentityScopes are the scopes of client certificates by the name they were authorized by,
+	// auth.DefaultScopes are granted to identities without scopes
+	IdentityScopes map[string][]string `json:"identityScopes"`
+	// TLSConfig is the client certificate presented to peers and the CA verifying their serving certificates
+	TLSConfig TLSConfig `json:"tlsConfig"`
+}
+
+// UsesBearer checks if peers may authenticate with the bearer token.
+func (p PeerAuthConfig) UsesBearer() bool {
+	return p.Mode != PeerAuthMTLS
+}
+
+// UsesMTLS checks if peers may authenticate with client certificates.
+func (p PeerAuthConfig) UsesMTLS() bool {
+	return p.Mode == PeerAuthMTLS || p.Mode == PeerAuthBearerAndMTLS || p.Mode == PeerAuthBearerOrMTLS
 }
 
 // AppConfig is the main configuration structure for the application.
 type AppConfig struct {
 	// PDConfig for pulling data from PD instance.
-	PDConfig PDConfig
+	PDConfig PDConfig `json:"pdConfig"`
 	// PDDiscoveryConfig is the URL for PD discovery service.
-	PDDiscoveryConfig PDDiscoveryConfig
-	// BearerToken is the token used for authentication
-	BearerToken string
+	PDDiscoveryConfig PDDiscoveryConfig `json:"pdDiscoveryConfig"`
+	// LeaderElectionConfig for running multiple replicas.
+	LeaderElectionConfig LeaderElectionConfig `json:"leaderElectionConfig"`
+	// IssuerMode defines how the certificate is issued, one of the IssuerMode* constants.
+	IssuerMode string `json:"issuerMode"`
+	// LocalCAConfig for issuing certificates without cert-manager.
+	LocalCAConfig LocalCAConfig `json:"localCaConfig"`
+	// PostIssuanceConfig for reloading PD after a new certificate is issued.
+	PostIssuanceConfig PostIssuanceConfig `json:"postIssuanceConfig"`
+	// ReconcileConfig for triggering certificate reconcile runs.
+	ReconcileConfig ReconcileConfig `json:"reconcileConfig"`
+	// NotifyConfig for notifying peers about local IP changes.
+	NotifyConfig NotifyConfig `json:"notifyConfig"`
+	// GossipConfig for gossip based peer membership.
+	GossipConfig GossipConfig `json:"gossipConfig"`
+	// TLSServerConfig for serving the HTTP API over TLS.
+	TLSServerConfig TLSServerConfig `json:"tlsServerConfig"`
+	// PeerAuthConfig for authenticating pd-assistant peers.
+	PeerAuthConfig PeerAuthConfig `json:"peerAuthConfig"`
+	// SigningConfig for signing and verifying IPs exchanged with peers.
+	SigningConfig SigningConfig `json:"signingConfig"`
+	// TokenConfig for loading bearer tokens from a token file or Secret.
+	TokenConfig TokenConfig `json:"tokenConfig"`
+	// BearerToken is the static bearer token, BEARER_TOKEN if not configured, unused with a token file or Secret
+	BearerToken string `json:"bearerToken,omitempty"`
+	// Tokens are the bearer tokens used for authentication, shared by all copies of the config
+	Tokens *auth.TokenSet `json:"-"`
+	// IPOverridesConfig for loading manually pinned and excluded IPs from a file or ConfigMap.
+	IPOverridesConfig IPOverridesConfig `json:"ipOverridesConfig"`
+	// IPOverrides are the active IP overrides, set by the reconcile loops from the last loaded ones
+	IPOverrides IPOverrides `json:"-"`
+	// DashboardToken is an optional read-only token for the status dashboard
+	DashboardToken string `json:"dashboardToken,omitempty"`
+	// Certificate is the certificate template loaded from CertificateFilePath.
+	Certificate cmapi.Certificate `json:"-"`
 	// CertificateFilePath is the path to the certificate file.
-	Certificate cmapi.Certificate
+	CertificateFilePath string `json:"certificateFilePath"`
 
 	// PD Assistants host parameters
-	PDAssistantURLs        []string
-	PDAssistantHostPrefix  string
-	PDAssistantScheme      string
-	PDAssistantPort        string
-	PDAssistantTLSInsecure bool
-	PDAssistantConsensus   bool
+	PDAssistantURLs        []string `json:"pdAssistantUrls"`
+	PDAssistantHostPrefix  string   `json:"pdAssistantHostPrefix"`
+	PDAssistantScheme      string   `json:"pdAssistantScheme"`
+	PDAssistantPort        string   `json:"pdAssistantPort"`
+	PDAssistantTLSInsecure bool     `json:"pdAssistantTlsInsecure"`
+	PDAssistantConsensus   bool     `json:"pdAssistantConsensus"`
 
 	// HTTPRequestTimeout is the timeout for HTTP requests in seconds.
-	HTTPRequestTimeout int
+	HTTPRequestTimeout int `json:"httpRequestTimeout"`
 	// KubernetesPollInterval is the interval for polling Kubernetes in seconds.
-	KubernetesPollInterval int
+	KubernetesPollInterval int `json:"kubernetesPollInterval"`
 	// PDAssistantPollInterval is the interval for polling all pd-assistants in seconds.
-	PDAssistantPollInterval int
+	PDAssistantPollInterval int `json:"pdAssistantPollInterval"`
+	// CertIssuanceTimeout is the time to wait for cert-manager to issue an updated certificate in seconds.
+	CertIssuanceTimeout int `json:"certIssuanceTimeout"`
+	// EndpointVerifyInterval is the interval for verifying certificates served by PD endpoints in seconds, 0 disables it.
+	EndpointVerifyInterval int `json:"endpointVerifyInterval"`
+	// StaleIntervals is the number of missed intervals after which data is stale or a loop is stuck.
+	StaleIntervals int `json:"staleIntervals"`
+	// ShutdownTimeout is the time to wait for in-flight HTTP requests on shutdown in seconds.
+	ShutdownTimeout int `json:"shutdownTimeout"`
+	// Listen is the address:port the HTTP API listens on.
+	Listen string `json:"listen"`
+	// KubeConfigPath is the path to the kubeconfig file, the in-cluster config is used if empty.
+	KubeConfigPath string `json:"kubeconfig"`
 }
 
 // LoadCertificateYaml loads a certificate YAML file and unmarshals it into a Certificate object.
@@ -77,51 +452,347 @@ func LoadCertificateYaml(certificateFilePath string) (cmapi.Certificate, error)
 	return newCert, nil
 }
 
+// ErrCertificateTemplate is returned by Update when the certificate template failed to load.
+var ErrCertificateTemplate = errors.New("failed to load certificate YAML")
+
+// ValidateOptions select the checks skipped by ValidateWith.
+type ValidateOptions struct {
+	// SkipTemplate skips the certificate template checks, e.g. when the template failed to load
+	SkipTemplate bool
+	// SkipSecrets skips the checks of secrets which only exist in the cluster, e.g. the bearer token
+	SkipSecrets bool
+}
+
 // Create returns a new AppConfig instance with default values.
 func Create() AppConfig {
 	config := AppConfig{}
 	config.PDConfig = PDConfig{}
 	config.PDDiscoveryConfig = PDDiscoveryConfig{}
-	// TODO: make timeouts configurable
+	config.Tokens = auth.NewTokenSet("")
 	config.HTTPRequestTimeout = 5                   // seconds
 	config.PDConfig.HTTPRequestTimeout = 5          // seconds
 	config.PDDiscoveryConfig.HTTPRequestTimeout = 5 // seconds
 	return config
 }
 
-// Update updates the AppConfig instance with values from command line arguments and environment variables.
-func (c *AppConfig) Update(pdAssistantURLs, certPath string) error {
-	// Update config based on command line arguments
-	if pdAssistantURLs != "" {
-		c.PDAssistantURLs = utils.ParseCommaSeparatedLine(pdAssistantURLs)
-	}
-
-	// Update config with environment variables
-	c.BearerToken = os.Getenv("BEARER_TOKEN")
-	if c.BearerToken == "" {
-		return fmt.Errorf("BEARER_TOKEN environment variable is not set")
+// Update loads the files and secrets referenced by the config: the certificate template, the static bearer token and signing keys.
+func (c *AppConfig) Update() error {
+	// Tokens from a token file or Secret are loaded when the assistant starts
+	if !c.TokenConfig.Enabled() {
+		c.Tokens = auth.NewTokenSet(c.BearerToken)
 	}
 
 	// Load Certificate YAML
-	newCert, err := LoadCertificateYaml(certPath)
+	newCert, err := LoadCertificateYaml(c.CertificateFilePath)
 	if err != nil {
-		return fmt.Errorf("failed to load certificate YAML: %s", err.Error())
+		return fmt.Errorf("%w: %s", ErrCertificateTemplate, err.Error())
 	}
 	c.Certificate = newCert
 
+	// Load signing keys
+	if c.SigningConfig.KeyPath != "" {
+		keyPEM, err := os.ReadFile(c.SigningConfig.KeyPath)
+		if err != nil {
+			return fmt.Errorf("failed to read signing key: %s", err.Error())
+		}
+		if c.SigningConfig.Key, err = utils.ParseEd25519PrivateKeyPEM(keyPEM); err != nil {
+			return fmt.Errorf("failed to load signing key: %s", err.Error())
+		}
+	}
+	if c.SigningConfig.KeyringPath != "" {
+		if c.SigningConfig.Keyring, c.SigningConfig.PeerClusters, err = LoadKeyring(c.SigningConfig.KeyringPath); err != nil {
+			return fmt.Errorf("failed to load keyring: %s", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// IsCertManagerMode checks if certificates are issued by cert-manager from a Certificate resource.
+func (c *AppConfig) IsCertManagerMode() bool {
+	return c.IssuerMode == "" || c.IssuerMode == IssuerModeCertManager
+}
+
+// ValidateURL checks a URL has an http or https scheme, a host and a valid port if any.
+func ValidateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("scheme must be http or https")
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("host is missing")
+	}
+	if u.Port() != "" {
+		return validatePort(u.Port())
+	}
+	return nil
+}
+
+// validatePort checks a port is a number between 1 and 65535.
+func validatePort(port string) error {
+	if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+		return fmt.Errorf("port %q must be between 1 and 65535", port)
+	}
 	return nil
 }
 
-// Validate checks if the AppConfig instance has valid values.
+// validateHostPort checks an address is host:port with a valid port, the host may be empty if allowed.
+func validateHostPort(address string, emptyHost bool) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if host == "" && !emptyHost {
+		return fmt.Errorf("host is missing")
+	}
+	return validatePort(port)
+}
+
+// Validate checks if the AppConfig instance has valid values, all errors are returned joined.
 func (c *AppConfig) Validate() error {
+	return c.ValidateWith(ValidateOptions{})
+}
+
+// ValidateWith checks the config like Validate, except for the checks skipped by opts.
+func (c *AppConfig) ValidateWith(opts ValidateOptions) error {
+	var errs []error
+
+	// Peer discovery
+	switch {
+	case len(c.PDAssistantURLs) > 0 && c.PDDiscoveryConfig.URL != "":
+		errs = append(errs, fmt.Errorf("PD Assistant URLs and a PD discovery service are mutually exclusive"))
+	case len(c.PDAssistantURLs) == 0 && c.PDDiscoveryConfig.URL == "":
+		errs = append(errs, fmt.Errorf("PD Assistant URLs or a PD discovery service are required"))
+	}
+	for _, pdaURL := range c.PDAssistantURLs {
+		if err := ValidateURL(pdaURL); err != nil {
+			errs = append(errs, fmt.Errorf("invalid PD Assistant URL %q: %v", pdaURL, err))
+		}
+	}
+	if c.PDAssistantScheme != "http" && c.PDAssistantScheme != "https" {
+		errs = append(errs, fmt.Errorf("PD Assistant scheme %q must be http or https", c.PDAssistantScheme))
+	}
+	if err := validatePort(c.PDAssistantPort); err != nil {
+		errs = append(errs, fmt.Errorf("invalid PD Assistant port: %v", err))
+	}
+	if c.PDDiscoveryConfig.URL != "" {
+		if err := ValidateURL(c.PDDiscoveryConfig.URL); err != nil {
+			errs = append(errs, fmt.Errorf("invalid PD discovery URL %q: %v", c.PDDiscoveryConfig.URL, err))
+		}
+	}
+
+	// Addresses
+	if c.Listen != "" {
+		if err := validateHostPort(c.Listen, true); err != nil {
+			errs = append(errs, fmt.Errorf("invalid listen address %q: %v", c.Listen, err))
+		}
+	}
+	if c.PDConfig.Address != "" {
+		if err := validateHostPort(c.PDConfig.Address, false); err != nil {
+			errs = append(errs, fmt.Errorf("invalid PD address %q: %v", c.PDConfig.Address, err))
+		}
+	}
+	if c.GossipConfig.AdvertiseURL != "" {
+		if err := ValidateURL(c.GossipConfig.AdvertiseURL); err != nil {
+			errs = append(errs, fmt.Errorf("invalid gossip advertise URL %q: %v", c.GossipConfig.AdvertiseURL, err))
+		}
+	}
+	if c.PostIssuanceConfig.ReloadURL != "" {
+		if err := ValidateURL(c.PostIssuanceConfig.ReloadURL); err != nil {
+			errs = append(errs, fmt.Errorf("invalid post-issuance reload URL %q: %v", c.PostIssuanceConfig.ReloadURL, err))
+		}
+	}
+
+	// Intervals and timeouts, the ones where 0 disables something only must not be negative
+	for _, d := range []struct {
+		name     string
+		value    int
+		positive bool
+	}{
+		{"HTTP request timeout", c.HTTPRequestTimeout, true},
+		{"PD HTTP request timeout", c.PDConfig.HTTPRequestTimeout, true},
+		{"PD discovery HTTP request timeout", c.PDDiscoveryConfig.HTTPRequestTimeout, true},
+		{"Kubernetes poll interval", c.KubernetesPollInterval, true},
+		{"PD Assistant poll interval", c.PDAssistantPollInterval, true},
+		{"certificate issuance timeout", c.CertIssuanceTimeout, true},
+		{"stale intervals", c.StaleIntervals, true},
+		{"shutdown timeout", c.ShutdownTimeout, false},
+		{"endpoint verify interval", c.EndpointVerifyInterval, false},
+		{"reconcile min interval", c.ReconcileConfig.MinInterval, false},
+		{"reconcile jitter", c.ReconcileConfig.Jitter, false},
+		{"template reload interval", c.ReconcileConfig.TemplateReloadInterval, false},
+		{"max removed IPs", c.ReconcileConfig.MaxRemovedIPs, false},
+		{"notification min interval", c.NotifyConfig.MinInterval, false},
+		{"post-issuance min interval", c.PostIssuanceConfig.MinInterval, false},
+	} {
+		if d.positive && d.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", d.name))
+		} else if d.value < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative", d.name))
+		}
+	}
+
+	// Certificate template
+	if !opts.SkipTemplate {
+		if c.Certificate.Name == "" || c.Certificate.Namespace == "" {
+			errs = append(errs, fmt.Errorf("certificate template requires a name and namespace"))
+		}
+		if c.Certificate.Spec.SecretName == "" {
+			errs = append(errs, fmt.Errorf("certificate template requires a secretName"))
+		}
+		if c.Certificate.Spec.IssuerRef.Name == "" && c.IssuerMode != IssuerModeLocalCA {
+			errs = append(errs, fmt.Errorf("certificate template requires an issuerRef"))
+		}
+		for _, ip := range c.Certificate.Spec.IPAddresses {
+			if net.ParseIP(ip) == nil {
+				errs = append(errs, fmt.Errorf("certificate template has an invalid IP address %q", ip))
+			}
+		}
+	}
+
+	// Tokens from a token file or Secret are loaded when the assistant starts
+	if !opts.SkipSecrets && !c.TokenConfig.Enabled() && c.BearerToken == "" && c.PeerAuthConfig.UsesBearer() {
+		errs = append(errs, fmt.Errorf("a bearer token is required, set BEARER_TOKEN or PDCA_BEARER_TOKEN"))
+	}
+
 	if c.PDDiscoveryConfig.URL != "" {
 		// In this case we require tidb cluster name and namespace
 		if c.PDDiscoveryConfig.TiDBCLusterName == "" {
-			return fmt.Errorf("PD discovery service requires a TiDB cluster name")
+			errs = append(errs, fmt.Errorf("PD discovery service requires a TiDB cluster name"))
 		}
 		if c.PDDiscoveryConfig.TiDBCLusterNameSpace == "" {
-			return fmt.Errorf("PD discovery service requires a TiDB cluster namespace")
+			errs = append(errs, fmt.Errorf("PD discovery service requires a TiDB cluster namespace"))
 		}
 	}
-	return nil
+
+	if c.LeaderElectionConfig.Enabled {
+		le := c.LeaderElectionConfig
+		if le.LeaseName == "" || le.Identity == "" {
+			errs = append(errs, fmt.Errorf("leader election requires a lease name and an identity"))
+		}
+		if le.LeaseDuration <= le.RenewDeadline || le.RenewDeadline <= le.RetryPeriod || le.RetryPeriod <= 0 {
+			errs = append(errs, fmt.Errorf("leader election requires lease duration > renew deadline > retry period > 0"))
+		}
+	}
+
+	if c.GossipConfig.Enabled {
+		g := c.GossipConfig
+		if g.AdvertiseURL == "" {
+			errs = append(errs, fmt.Errorf("gossip membership requires an advertise URL"))
+		}
+		if g.Interval <= 0 || g.Fanout <= 0 || g.SuspectTimeout <= g.Interval || g.DeadTimeout <= g.SuspectTimeout {
+			errs = append(errs, fmt.Errorf("gossip membership requires dead timeout > suspect timeout > interval > 0 and fanout > 0"))
+		}
+	}
+
+	if t := c.TLSServerConfig; t.Enabled() {
+		sources := 0
+		for _, set := range []bool{t.CertPath != "" || t.KeyPath != "", t.SecretName != "", t.ManagedSecret} {
+			if set {
+				sources++
+			}
+		}
+		if sources != 1 {
+			errs = append(errs, fmt.Errorf("TLS serving requires exactly one of certificate files, a Secret or the managed Secret"))
+		}
+		if (t.CertPath == "") != (t.KeyPath == "") {
+			errs = append(errs, fmt.Errorf("TLS serving requires both certificate and key files"))
+		}
+		if _, err := utils.ParseTLSVersion(t.MinVersion); err != nil {
+			errs = append(errs, err)
+		}
+		if _, err := utils.ParseCipherSuites(t.CipherSuites); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if sc := c.SigningConfig; sc.KeyPath != "" || sc.KeyringPath != "" {
+		if sc.KeyPath != "" && sc.ClusterID == "" {
+			errs = append(errs, fmt.Errorf("signing local IPs requires a cluster ID"))
+		}
+		if sc.KeyringPath != "" && sc.MaxAge <= 0 {
+			errs = append(errs, fmt.Errorf("verifying peer signatures requires a positive max age"))
+		}
+	}
+	if c.SigningConfig.Strict && c.SigningConfig.KeyringPath == "" {
+		errs = append(errs, fmt.Errorf("strict peer signature verification requires a keyring"))
+	}
+
+	if t := c.TokenConfig; t.Enabled() {
+		if t.FilePath != "" && t.SecretName != "" {
+			errs = append(errs, fmt.Errorf("bearer tokens require either a token file or a token Secret"))
+		}
+		if t.ReloadInterval <= 0 {
+			errs = append(errs, fmt.Errorf("bearer token reload interval must be positive"))
+		}
+	}
+
+	if o := c.IPOverridesConfig; o.Enabled() {
+		if o.FilePath != "" && o.ConfigMapName != "" {
+			errs = append(errs, fmt.Errorf("IP overrides require either a file or a ConfigMap"))
+		}
+		if o.ReloadInterval <= 0 {
+			errs = append(errs, fmt.Errorf("IP overrides reload interval must be positive"))
+		}
+	}
+
+	switch p := c.PeerAuthConfig; p.Mode {
+	case "", PeerAuthBearer:
+		if p.ClientCAPath != "" {
+			errs = append(errs, fmt.Errorf("a client CA requires a peer authentication mode with mTLS"))
+		}
+	case PeerAuthMTLS, PeerAuthBearerAndMTLS, PeerAuthBearerOrMTLS:
+		if !c.TLSServerConfig.Enabled() || p.ClientCAPath == "" {
+			errs = append(errs, fmt.Errorf("peer authentication mode %q requires TLS serving and a client CA", p.Mode))
+		}
+		if p.Mode != PeerAuthBearerOrMTLS && (p.TLSConfig.CertPath == "" || p.TLSConfig.KeyPath == "") {
+			errs = append(errs, fmt.Errorf("peer authentication mode %q requires a client certificate and key for peers", p.Mode))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown peer authentication mode %q", p.Mode))
+	}
+	for identity, scopes := range c.PeerAuthConfig.IdentityScopes {
+		if err := auth.ValidateScopes(scopes); err != nil {
+			errs = append(errs, fmt.Errorf("invalid scopes of identity %q: %v", identity, err))
+		}
+	}
+	if t := c.PeerAuthConfig.TLSConfig; (t.CertPath == "") != (t.KeyPath == "") {
+		errs = append(errs, fmt.Errorf("peer client certificate requires both certificate and key files"))
+	}
+
+	switch c.IssuerMode {
+	case "", IssuerModeCertManager, IssuerModeCertificateRequest:
+	case IssuerModeLocalCA:
+		fromSecret := c.LocalCAConfig.SecretName != ""
+		fromFiles := c.LocalCAConfig.CertPath != "" || c.LocalCAConfig.KeyPath != ""
+		if fromSecret == fromFiles {
+			errs = append(errs, fmt.Errorf("issuer mode %q requires either a CA secret or CA certificate and key files", c.IssuerMode))
+		}
+		if fromFiles && (c.LocalCAConfig.CertPath == "" || c.LocalCAConfig.KeyPath == "") {
+			errs = append(errs, fmt.Errorf("issuer mode %q requires both CA certificate and key files", c.IssuerMode))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown issuer mode %q", c.IssuerMode))
+	}
+
+	switch c.PostIssuanceConfig.Action {
+	case "", PostIssuanceActionNone:
+	case PostIssuanceActionAnnotateStatefulSet, PostIssuanceActionAnnotateTidbCluster:
+		if c.PostIssuanceConfig.TargetName == "" || c.PostIssuanceConfig.TargetNamespace == "" {
+			errs = append(errs, fmt.Errorf("post-issuance action %q requires a target name and namespace", c.PostIssuanceConfig.Action))
+		}
+	case PostIssuanceActionReloadEndpoint:
+		if c.PostIssuanceConfig.ReloadURL == "" {
+			errs = append(errs, fmt.Errorf("post-issuance action %q requires a reload URL", c.PostIssuanceConfig.Action))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown post-issuance action %q", c.PostIssuanceConfig.Action))
+	}
+	if c.PostIssuanceConfig.Action != "" && c.PostIssuanceConfig.Action != PostIssuanceActionNone && c.PDConfig.Address == "" {
+		errs = append(errs, fmt.Errorf("post-issuance action %q requires a PD address for health checks", c.PostIssuanceConfig.Action))
+	}
+	return errors.Join(errs...)
 }
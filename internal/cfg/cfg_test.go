@@ -1,7 +1,18 @@
 package cfg
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
 )
 
 func TestLoadCertificateYaml(t *testing.T) {
@@ -60,3 +71,433 @@ func TestCreate(t *testing.T) {
 		t.Errorf("expected PDDiscoveryConfig to be initialized, got an empty struct")
 	}
 }
+
+// validConfig returns a config passing validation, tests change the parts they validate.
+func validConfig(t *testing.T) AppConfig {
+	t.Helper()
+	config := Create()
+	cert, err := LoadCertificateYaml("../../fixtures/certificate.yaml")
+	if err != nil {
+		t.Fatalf("failed to load certificate YAML: %v", err)
+	}
+	config.Certificate = cert
+	config.BearerToken = "token"
+	config.PDAssistantURLs = []string{"https://pd-assistant.eu.example.com:443", "https://pd-assistant.us.example.com"}
+	config.PDAssistantScheme = "https"
+	config.PDAssistantPort = "443"
+	config.KubernetesPollInterval = 60
+	config.PDAssistantPollInterval = 60
+	config.CertIssuanceTimeout = 300
+	config.StaleIntervals = 3
+	return config
+}
+
+func TestValidate(t *testing.T) {
+	config := validConfig(t)
+	if err := config.Validate(); err != nil {
+		t.Fatalf("expected config to be valid, got %v", err)
+	}
+
+	// All errors are reported at once
+	config.PDAssistantURLs = append(config.PDAssistantURLs, "ftp://pd-assistant.ap.example.com", "https://:443", "https://pd-assistant.sa.example.com:70000")
+	config.PDDiscoveryConfig = PDDiscoveryConfig{URL: "http://basic-discovery.tidb:10261", TiDBCLusterName: "basic", TiDBCLusterNameSpace: "tidb", HTTPRequestTimeout: 5}
+	config.PDAssistantScheme = "tcp"
+	config.PDAssistantPort = "0"
+	config.Listen = "8080"
+	config.PDConfig.Address = "pd:http"
+	config.KubernetesPollInterval = 0
+	config.ShutdownTimeout = -1
+	config.Certificate.Spec.SecretName = ""
+	config.Certificate.Spec.IssuerRef.Name = ""
+	config.Certificate.Spec.IPAddresses = append(config.Certificate.Spec.IPAddresses, "10.0.0.256")
+	config.BearerToken = ""
+	err := config.Validate()
+	if err == nil {
+		t.Fatal("expected config to be invalid")
+	}
+	for _, expected := range []string{
+		`mutually exclusive`,
+		`invalid PD Assistant URL "ftp://pd-assistant.ap.example.com": scheme must be http or https`,
+		`invalid PD Assistant URL "https://:443": host is missing`,
+		`invalid PD Assistant URL "https://pd-assistant.sa.example.com:70000": port "70000" must be between 1 and 65535`,
+		`PD Assistant scheme "tcp" must be http or https`,
+		`invalid PD Assistant port`,
+		`invalid listen address "8080"`,
+		`invalid PD address "pd:http"`,
+		`Kubernetes poll interval must be positive`,
+		`shutdown timeout must not be negative`,
+		`certificate template requires a secretName`,
+		`certificate template requires an issuerRef`,
+		`certificate template has an invalid IP address "10.0.0.256"`,
+		`a bearer token is required`,
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error %q, got:\n%v", expected, err)
+		}
+	}
+
+	// Validating manifests skips the template after it failed to load and secrets missing outside the cluster
+	config = validConfig(t)
+	config.CertificateFilePath = "missing.yaml"
+	config.BearerToken = ""
+	updateErr := config.Update()
+	if !errors.Is(updateErr, ErrCertificateTemplate) {
+		t.Fatalf("expected the template to fail to load, got %v", updateErr)
+	}
+	config.Certificate = cmapi.Certificate{}
+	if err := config.ValidateWith(ValidateOptions{SkipTemplate: true, SkipSecrets: true}); err != nil {
+		t.Errorf("expected the skipped checks to pass, got %v", err)
+	}
+
+	// The local CA issues certificates itself and needs no issuer, discovery alone is enough
+	config = validConfig(t)
+	config.IssuerMode = IssuerModeLocalCA
+	config.LocalCAConfig.SecretName = "pd-assistant-ca"
+	config.Certificate.Spec.IssuerRef.Name = ""
+	config.PDAssistantURLs = nil
+	config.PDDiscoveryConfig = PDDiscoveryConfig{URL: "http://basic-discovery.tidb:10261", TiDBCLusterName: "basic", TiDBCLusterNameSpace: "tidb", HTTPRequestTimeout: 5}
+	if err := config.Validate(); err != nil {
+		t.Errorf("expected config to be valid, got %v", err)
+	}
+	config.PDDiscoveryConfig.URL = ""
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "PD Assistant URLs or a PD discovery service are required") {
+		t.Errorf("expected peer discovery to be required, got %v", err)
+	}
+}
+
+func TestValidatePostIssuanceConfig(t *testing.T) {
+	tests := []struct {
+		config PostIssuanceConfig
+		pdAddr string
+		valid  bool
+	}{
+		{PostIssuanceConfig{}, "", true},
+		{PostIssuanceConfig{Action: PostIssuanceActionNone}, "", true},
+		{PostIssuanceConfig{Action: PostIssuanceActionAnnotateStatefulSet, TargetName: "pd", TargetNamespace: "tidb"}, "pd:2379", true},
+		{PostIssuanceConfig{Action: PostIssuanceActionAnnotateStatefulSet, TargetName: "pd", TargetNamespace: "tidb"}, "", false},
+		{PostIssuanceConfig{Action: PostIssuanceActionAnnotateTidbCluster, TargetName: "tidb"}, "pd:2379", false},
+		{PostIssuanceConfig{Action: PostIssuanceActionReloadEndpoint, ReloadURL: "http://reloader/reload"}, "pd:2379", true},
+		{PostIssuanceConfig{Action: PostIssuanceActionReloadEndpoint}, "pd:2379", false},
+		{PostIssuanceConfig{Action: "restart-everything"}, "pd:2379", false},
+	}
+
+	for _, test := range tests {
+		config := validConfig(t)
+		config.PostIssuanceConfig = test.config
+		config.PDConfig.Address = test.pdAddr
+		err := config.Validate()
+		if test.valid && err != nil {
+			t.Errorf("expected config %+v to be valid, got %v", test.config, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("expected config %+v to be invalid, got nil", test.config)
+		}
+	}
+}
+
+func TestValidateIssuerMode(t *testing.T) {
+	tests := []struct {
+		mode    string
+		localCA LocalCAConfig
+		valid   bool
+	}{
+		{"", LocalCAConfig{}, true},
+		{IssuerModeCertManager, LocalCAConfig{}, true},
+		{IssuerModeLocalCA, LocalCAConfig{SecretName: "ca"}, true},
+		{IssuerModeLocalCA, LocalCAConfig{CertPath: "ca.crt", KeyPath: "ca.key"}, true},
+		{IssuerModeLocalCA, LocalCAConfig{}, false},
+		{IssuerModeLocalCA, LocalCAConfig{CertPath: "ca.crt"}, false},
+		{IssuerModeLocalCA, LocalCAConfig{SecretName: "ca", CertPath: "ca.crt", KeyPath: "ca.key"}, false},
+		{"vault", LocalCAConfig{}, false},
+	}
+
+	for _, test := range tests {
+		config := validConfig(t)
+		config.IssuerMode = test.mode
+		config.LocalCAConfig = test.localCA
+		err := config.Validate()
+		if test.valid && err != nil {
+			t.Errorf("expected issuer mode %q with %+v to be valid, got %v", test.mode, test.localCA, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("expected issuer mode %q with %+v to be invalid, got nil", test.mode, test.localCA)
+		}
+	}
+}
+
+func TestValidateTLSServerConfig(t *testing.T) {
+	tests := []struct {
+		tls   TLSServerConfig
+		valid bool
+	}{
+		{TLSServerConfig{}, true},
+		{TLSServerConfig{CertPath: "tls.crt", KeyPath: "tls.key", MinVersion: "1.2"}, true},
+		{TLSServerConfig{SecretName: "pd-assistant-tls", MinVersion: "1.3"}, true},
+		{TLSServerConfig{ManagedSecret: true, MinVersion: "1.2", CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}}, true},
+		{TLSServerConfig{CertPath: "tls.crt", MinVersion: "1.2"}, false},
+		{TLSServerConfig{CertPath: "tls.crt", KeyPath: "tls.key", ManagedSecret: true, MinVersion: "1.2"}, false},
+		{TLSServerConfig{SecretName: "pd-assistant-tls", MinVersion: "1.1"}, false},
+		{TLSServerConfig{SecretName: "pd-assistant-tls", MinVersion: "1.2", CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}, false},
+	}
+
+	for _, test := range tests {
+		config := validConfig(t)
+		config.TLSServerConfig = test.tls
+		err := config.Validate()
+		if test.valid && err != nil {
+			t.Errorf("expected TLS config %+v to be valid, got %v", test.tls, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("expected TLS config %+v to be invalid, got nil", test.tls)
+		}
+	}
+}
+
+func TestValidatePeerAuthConfig(t *testing.T) {
+	clientCert := TLSConfig{CertPath: "client.crt", KeyPath: "client.key"}
+	tests := []struct {
+		auth  PeerAuthConfig
+		valid bool
+	}{
+		{PeerAuthConfig{}, true},
+		{PeerAuthConfig{Mode: PeerAuthBearer}, true},
+		{PeerAuthConfig{Mode: PeerAuthMTLS, ClientCAPath: "ca.crt", TLSConfig: clientCert}, true},
+		{PeerAuthConfig{Mode: PeerAuthBearerAndMTLS, ClientCAPath: "ca.crt", AllowedSANs: []string{"pd-assistant.eu"}, TLSConfig: clientCert}, true},
+		{PeerAuthConfig{Mode: PeerAuthBearerOrMTLS, ClientCAPath: "ca.crt"}, true},
+		{PeerAuthConfig{Mode: PeerAuthBearer, ClientCAPath: "ca.crt"}, false},
+		{PeerAuthConfig{Mode: PeerAuthMTLS, TLSConfig: clientCert}, false},
+		{PeerAuthConfig{Mode: PeerAuthMTLS, ClientCAPath: "ca.crt"}, false},
+		{PeerAuthConfig{Mode: PeerAuthBearer, TLSConfig: TLSConfig{CertPath: "client.crt"}}, false},
+		{PeerAuthConfig{Mode: "token"}, false},
+	}
+
+	for _, test := range tests {
+		config := validConfig(t)
+		config.TLSServerConfig = TLSServerConfig{SecretName: "pd-assistant-tls", MinVersion: "1.2"}
+		config.PeerAuthConfig = test.auth
+		err := config.Validate()
+		if test.valid && err != nil {
+			t.Errorf("expected peer auth config %+v to be valid, got %v", test.auth, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("expected peer auth config %+v to be invalid, got nil", test.auth)
+		}
+	}
+
+	config := validConfig(t)
+	config.PeerAuthConfig = PeerAuthConfig{Mode: PeerAuthMTLS, ClientCAPath: "ca.crt", TLSConfig: clientCert}
+	if err := config.Validate(); err == nil {
+		t.Error("expected mTLS without TLS serving to be invalid, got nil")
+	}
+}
+
+func TestValidateTokenConfig(t *testing.T) {
+	tests := []struct {
+		tokens TokenConfig
+		valid  bool
+	}{
+		{TokenConfig{}, true},
+		{TokenConfig{FilePath: "tokens.yaml", ReloadInterval: 30}, true},
+		{TokenConfig{SecretName: "pd-assistant-tokens", ReloadInterval: 30}, true},
+		{TokenConfig{FilePath: "tokens.yaml", SecretName: "pd-assistant-tokens", ReloadInterval: 30}, false},
+		{TokenConfig{FilePath: "tokens.yaml"}, false},
+	}
+
+	for _, test := range tests {
+		config := validConfig(t)
+		config.TokenConfig = test.tokens
+		err := config.Validate()
+		if test.valid && err != nil {
+			t.Errorf("expected token config %+v to be valid, got %v", test.tokens, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("expected token config %+v to be invalid, got nil", test.tokens)
+		}
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	publicKeyPEM := func() string {
+		public, _, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, _ := x509.MarshalPKIXPublicKey(public)
+		return strings.ReplaceAll(string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), "\n", "\n    ")
+	}
+	path := filepath.Join(t.TempDir(), "keyring.yaml")
+	keyring := "keys:\n- cluster_id: eu\n  public_key: |\n    " + publicKeyPEM() + "\n- cluster_id: eu\n  public_key: |\n    " + publicKeyPEM() + "\n" +
+		"peers:\n- url: https://pd-assistant.eu:8443\n  cluster_id: eu\n"
+	if err := os.WriteFile(path, []byte(keyring), 0600); err != nil {
+		t.Fatal(err)
+	}
+	loaded, peers, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatalf("failed to load keyring: %v", err)
+	}
+	if len(loaded["eu"]) != 2 {
+		t.Errorf("expected 2 keys of cluster eu, got %d", len(loaded["eu"]))
+	}
+	if peers["https://pd-assistant.eu:8443"] != "eu" {
+		t.Errorf("expected the peer to be mapped to cluster eu, got %v", peers)
+	}
+
+	os.WriteFile(path, []byte("keys:\n- cluster_id: eu\n  public_key: invalid\n"), 0600)
+	if _, _, err := LoadKeyring(path); err == nil {
+		t.Error("expected an invalid public key to be refused")
+	}
+	os.WriteFile(path, []byte(keyring+"- url: https://pd-assistant.us\n  cluster_id: us\n"), 0600)
+	if _, _, err := LoadKeyring(path); err == nil {
+		t.Error("expected a peer of a cluster without keys to be refused")
+	}
+	os.WriteFile(path, []byte(keyring), 0600)
+
+	config := validConfig(t)
+	config.SigningConfig = SigningConfig{KeyPath: "signing.key"}
+	if err := config.Validate(); err == nil {
+		t.Error("expected a signing key without cluster ID to be invalid")
+	}
+	config.SigningConfig = SigningConfig{Strict: true}
+	if err := config.Validate(); err == nil {
+		t.Error("expected strict verification without keyring to be invalid")
+	}
+	config.SigningConfig = SigningConfig{ClusterID: "eu", KeyPath: "signing.key", KeyringPath: path, Strict: true, MaxAge: 300}
+	if err := config.Validate(); err != nil {
+		t.Errorf("expected signing config to be valid, got %v", err)
+	}
+}
+
+func TestParseIPOverrides(t *testing.T) {
+	overrides, err := ParseIPOverrides([]byte(`pinned:
+- ip: 10.0.0.5
+  reason: migration of pd-3
+  expires: 2025-01-02T00:00:00Z
+excluded:
+- ip: "2001:db8:0::1"
+  reason: flapping node
+`))
+	if err != nil {
+		t.Fatalf("failed to parse IP overrides: %v", err)
+	}
+	if overrides.Excluded[0].IP != "2001:db8::1" {
+		t.Errorf("expected excluded IP to be normalized, got %s", overrides.Excluded[0].IP)
+	}
+	if !overrides.Excludes("2001:db8:0:0::1") || overrides.Excludes("10.0.0.5") {
+		t.Errorf("expected only the excluded IP to be excluded")
+	}
+
+	expires := *overrides.Pinned[0].Expires
+	if active := overrides.Active(expires.Add(-time.Second)); len(active.Pinned) != 1 || len(active.Excluded) != 1 {
+		t.Errorf("expected all overrides to be active before expiry, got %+v", active)
+	}
+	if active := overrides.Active(expires); len(active.Pinned) != 0 || len(active.Excluded) != 1 {
+		t.Errorf("expected the pinned IP to expire, got %+v", active)
+	}
+
+	for _, data := range []string{
+		"pinned:\n- ip: 10.0.0.300\n",
+		"pinned:\n- ip: 10.0.0.5\nexcluded:\n- ip: 10.0.0.5\n",
+		"pinned:\n- ip: 10.0.0.5\n  until: tomorrow\n",
+	} {
+		if _, err := ParseIPOverrides([]byte(data)); err == nil {
+			t.Errorf("expected IP overrides %q to be invalid", data)
+		}
+	}
+	for range 10 {
+		_, err := ParseIPOverrides([]byte("pinned:\n- ip: 10.0.0.5\nexcluded:\n- ip: 10.0.0.5\n- ip: 10.0.0.6\n- ip: 10.0.0.6\n"))
+		if err == nil || err.Error() != "IP 10.0.0.5 is pinned more than once" {
+			t.Fatalf("expected the first duplicate to be reported, got %v", err)
+		}
+	}
+
+	config := validConfig(t)
+	config.IPOverridesConfig = IPOverridesConfig{FilePath: "overrides.yaml", ConfigMapName: "overrides", ReloadInterval: 30}
+	if err := config.Validate(); err == nil {
+		t.Error("expected IP overrides from both a file and a ConfigMap to be invalid")
+	}
+}
+
+func TestConfigSources(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	configYAML := "listen: :9000\npdConfig:\n  address: pd:2379\n  httpRequestTimeout: 12\nreconcileConfig:\n  jitter: 9\npeerAuthConfig:\n  identityScopes:\n    old.example.com: [peer:read]\n"
+	if err := os.WriteFile(path, []byte(configYAML), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config := Create()
+	config.ReconcileConfig.MinInterval = 10
+	if err := config.LoadFile(path); err != nil {
+		t.Fatalf("failed to load config file: %v", err)
+	}
+	if config.Listen != ":9000" || config.PDConfig.HTTPRequestTimeout != 12 || config.ReconcileConfig.MinInterval != 10 {
+		t.Errorf("expected the config file to override only its fields, got %+v", config)
+	}
+
+	env := map[string]string{
+		"PDCA_RECONCILE_CONFIG_JITTER":               "3",
+		"PDCA_PD_CONFIG_TLS_CONFIG_INSECURE":         "true",
+		"PDCA_PD_ASSISTANT_URLS":                     "https://a.example, https://b.example",
+		"PDCA_PEER_AUTH_CONFIG_IDENTITY_SCOPES":      "ops.example.com=admin:write|status:read",
+		"BEARER_TOKEN":                               "legacy-token",
+		"PDCA_DASHBOARD_TOKEN":                       "dashboard-token",
+		"PDCA_PD_DISCOVERY_CONFIG_TIDB_CLUSTER_NAME": "basic",
+	}
+	lookupEnv := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+	applied, err := config.ApplyEnv(lookupEnv)
+	if err != nil {
+		t.Fatalf("failed to apply environment variables: %v", err)
+	}
+	if len(applied) != len(env) {
+		t.Errorf("expected all environment variables to be applied, got %v", applied)
+	}
+	if config.ReconcileConfig.Jitter != 3 || !config.PDConfig.TLSConfig.Insecure || len(config.PDAssistantURLs) != 2 ||
+		len(config.PeerAuthConfig.IdentityScopes) != 1 || len(config.PeerAuthConfig.IdentityScopes["ops.example.com"]) != 2 ||
+		config.PeerAuthConfig.IdentityScopes["ops.example.com"][0] != "admin:write" || config.BearerToken != "legacy-token" ||
+		config.PDDiscoveryConfig.TiDBCLusterName != "basic" {
+		t.Errorf("expected environment variables to override the config, got %+v", config)
+	}
+
+	env = map[string]string{"PDCA_RECONCILE_CONFIG_JITTER": "soon"}
+	if _, err := config.ApplyEnv(lookupEnv); err == nil {
+		t.Error("expected an invalid number to be refused")
+	}
+	if err := os.WriteFile(path, []byte("tokens: [secret]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.LoadFile(path); err == nil {
+		t.Error("expected runtime fields to be refused in the config file")
+	}
+
+	dump, err := config.Dump()
+	if err != nil {
+		t.Fatalf("failed to dump config: %v", err)
+	}
+	if strings.Contains(string(dump), "legacy-token") || strings.Contains(string(dump), "dashboard-token") {
+		t.Errorf("expected secrets to be omitted:\n%s", dump)
+	}
+	if err := os.WriteFile(path, dump, 0600); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := Create()
+	if err := reloaded.LoadFile(path); err != nil || reloaded.BearerToken != "" || reloaded.ReconcileConfig.Jitter != 3 {
+		t.Errorf("expected the dump to load back without secrets, got %v: %+v", err, reloaded)
+	}
+	if !strings.Contains(string(dump), "jitter: 3") {
+		t.Errorf("expected the merged config in the dump:\n%s", dump)
+	}
+
+	// Flag values are set back from their string form when flags take precedence
+	scopes := IdentityScopesValue{IdentityScopes: &config.PeerAuthConfig.IdentityScopes}
+	if err := scopes.Set("b=peer:read,a=status:read|admin:write"); err != nil {
+		t.Fatal(err)
+	}
+	if scopes.String() != "a=status:read|admin:write,b=peer:read" {
+		t.Errorf("expected sorted identity scopes, got %s", scopes.String())
+	}
+	urls := ListValue{List: &config.PDAssistantURLs}
+	if err := urls.Set(""); err != nil || urls.String() != "" || config.PDAssistantURLs != nil {
+		t.Errorf("expected an empty list, got %v", config.PDAssistantURLs)
+	}
+}
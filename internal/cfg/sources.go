@@ -0,0 +1,179 @@
+package cfg
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/impossiblecloud/pd-cert-assistant/internal/auth"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/utils"
+	"sigs.k8s.io/yaml"
+)
+
+// EnvPrefix is the prefix of environment variables overriding config fields,
+// e.g. PDCA_PD_CONFIG_HTTP_REQUEST_TIMEOUT overrides pdConfig.httpRequestTimeout
+const EnvPrefix = "PDCA_"
+
+// legacyEnv are environment variables used before PDCA_ ones, they apply if the PDCA_ one isn't set
+var legacyEnv = map[string]string{
+	EnvPrefix + "BEARER_TOKEN":    "BEARER_TOKEN",
+	EnvPrefix + "DASHBOARD_TOKEN": "DASHBOARD_TOKEN",
+}
+
+// ListValue is a flag value setting a list from a comma-separated line.
+type ListValue struct {
+	List *[]string
+}
+
+func (v ListValue) String() string {
+	if v.List == nil {
+		return ""
+	}
+	return strings.Join(*v.List, ",")
+}
+
+func (v ListValue) Set(line string) error {
+	*v.List = nil
+	if line != "" {
+		*v.List = utils.ParseCommaSeparatedLine(line)
+	}
+	return nil
+}
+
+// IdentityScopesValue is a flag value setting identity scopes in the "identity=scope|scope,identity=scope" format.
+type IdentityScopesValue struct {
+	IdentityScopes *map[string][]string
+}
+
+func (v IdentityScopesValue) String() string {
+	if v.IdentityScopes == nil {
+		return ""
+	}
+	entries := []string{}
+	for identity, scopes := range *v.IdentityScopes {
+		entries = append(entries, identity+"="+strings.Join(scopes, "|"))
+	}
+	slices.Sort(entries)
+	return strings.Join(entries, ",")
+}
+
+func (v IdentityScopesValue) Set(line string) error {
+	identityScopes, err := auth.ParseIdentityScopes(line)
+	if err != nil {
+		return err
+	}
+	*v.IdentityScopes = identityScopes
+	return nil
+}
+
+// LoadFile loads a YAML config file onto the config, fields missing in the file keep their values.
+func (c *AppConfig) LoadFile(configFilePath string) error {
+	data, err := os.ReadFile(configFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file %s: %s", configFilePath, err.Error())
+	}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return fmt.Errorf("failed to unmarshal config YAML %s: %s", configFilePath, err.Error())
+	}
+	return nil
+}
+
+// envName returns the environment variable of a config field from the JSON names of the field and its parents.
+func envName(path []string) string {
+	var name strings.Builder
+	name.WriteString(EnvPrefix)
+	for i, field := range path {
+		if i > 0 {
+			name.WriteRune('_')
+		}
+		for j, r := range field {
+			if j > 0 && unicode.IsUpper(r) {
+				name.WriteRune('_')
+			}
+			name.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return name.String()
+}
+
+// ApplyEnv overrides config fields with PDCA_ environment variables, lists and identity scopes use
+// the format of their flags and other non-scalar fields are YAML. It returns the names of the applied variables.
+func (c *AppConfig) ApplyEnv(lookupEnv func(string) (string, bool)) ([]string, error) {
+	applied := []string{}
+	err := applyEnv(reflect.ValueOf(c).Elem(), nil, func(name string) (string, bool) {
+		if value, ok := lookupEnv(name); ok {
+			applied = append(applied, name)
+			return value, true
+		}
+		if legacy, ok := legacyEnv[name]; ok {
+			if value, ok := lookupEnv(legacy); ok {
+				applied = append(applied, legacy)
+				return value, true
+			}
+		}
+		return "", false
+	})
+	return applied, err
+}
+
+func applyEnv(v reflect.Value, path []string, lookupEnv func(string) (string, bool)) error {
+	for i := 0; i < v.NumField(); i++ {
+		name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		field := v.Field(i)
+		fieldPath := append(slices.Clone(path), name)
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field, fieldPath, lookupEnv); err != nil {
+				return err
+			}
+			continue
+		}
+
+		env := envName(fieldPath)
+		value, ok := lookupEnv(env)
+		if !ok {
+			continue
+		}
+		var err error
+		switch field.Interface().(type) {
+		case string:
+			field.SetString(value)
+		case int:
+			var number int
+			if number, err = strconv.Atoi(value); err == nil {
+				field.SetInt(int64(number))
+			}
+		case bool:
+			var flag bool
+			if flag, err = strconv.ParseBool(value); err == nil {
+				field.SetBool(flag)
+			}
+		case []string:
+			err = ListValue{List: field.Addr().Interface().(*[]string)}.Set(value)
+		case map[string][]string:
+			err = IdentityScopesValue{IdentityScopes: field.Addr().Interface().(*map[string][]string)}.Set(value)
+		default:
+			// Unmarshalling merges into maps, the variable replaces the field like for other types
+			field.Set(reflect.Zero(field.Type()))
+			err = yaml.UnmarshalStrict([]byte(value), field.Addr().Interface())
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s environment variable: %v", env, err)
+		}
+	}
+	return nil
+}
+
+// Dump returns the config as YAML in the config file format. Secrets are omitted,
+// so the dump can be loaded as a config file without turning placeholders into tokens.
+func (c AppConfig) Dump() ([]byte, error) {
+	c.BearerToken = ""
+	c.DashboardToken = ""
+	return yaml.Marshal(c)
+}
@@ -0,0 +1,288 @@
+package issuer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/utils"
+)
+
+// Defaults matching cert-manager behaviour
+const (
+	DefaultDuration = 90 * 24 * time.Hour
+	defaultRSASize  = 2048
+)
+
+// CA is a certificate authority keypair used to sign leaf certificates.
+type CA struct {
+	Cert    *x509.Certificate
+	CertPEM []byte
+	Key     crypto.Signer
+}
+
+// LoadCA parses a PEM encoded CA certificate and private key.
+func LoadCA(certPEM, keyPEM []byte) (*CA, error) {
+	cert, err := utils.ParseCertificatePEM(certPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %v", err)
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("certificate %q is not a CA", cert.Subject.CommonName)
+	}
+	key, err := ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA private key: %v", err)
+	}
+	return &CA{Cert: cert, CertPEM: certPEM, Key: key}, nil
+}
+
+// GeneratePrivateKey generates a private key according to the Certificate private key spec.
+func GeneratePrivateKey(spec *cmapi.CertificatePrivateKey) (crypto.Signer, error) {
+	algorithm := cmapi.RSAKeyAlgorithm
+	size := 0
+	if spec != nil {
+		if spec.Algorithm != "" {
+			algorithm = spec.Algorithm
+		}
+		size = spec.Size
+	}
+
+	switch algorithm {
+	case cmapi.RSAKeyAlgorithm:
+		if size == 0 {
+			size = defaultRSASize
+		}
+		return rsa.GenerateKey(rand.Reader, size)
+	case cmapi.ECDSAKeyAlgorithm:
+		switch size {
+		case 0, 256:
+			return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		case 384:
+			return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		case 521:
+			return ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+		}
+		return nil, fmt.Errorf("unsupported ECDSA key size %d", size)
+	case cmapi.Ed25519KeyAlgorithm:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	return nil, fmt.Errorf("unsupported private key algorithm %q", algorithm)
+}
+
+// EncodePrivateKeyPEM encodes the private key using the encoding from the Certificate private key spec.
+func EncodePrivateKeyPEM(key crypto.Signer, spec *cmapi.CertificatePrivateKey) ([]byte, error) {
+	if spec == nil || spec.Encoding != cmapi.PKCS8 {
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}), nil
+		case *ecdsa.PrivateKey:
+			der, err := x509.MarshalECPrivateKey(k)
+			if err != nil {
+				return nil, err
+			}
+			return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+		}
+	}
+
+	// Ed25519 keys are always PKCS8 encoded
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// ParsePrivateKeyPEM parses a PKCS1, SEC1 or PKCS8 PEM encoded private key.
+func ParsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM encoded private key found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported PKCS8 private key type %T", key)
+		}
+		return signer, nil
+	}
+	return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+}
+
+// keyUsages converts cert-manager key usages to x509 key usages.
+// Without usages cert-manager issues certificates for digital signature and key encipherment.
+func keyUsages(usages []cmapi.KeyUsage) (x509.KeyUsage, []x509.ExtKeyUsage, error) {
+	if len(usages) == 0 {
+		usages = []cmapi.KeyUsage{cmapi.UsageDigitalSignature, cmapi.UsageKeyEncipherment}
+	}
+
+	keyUsageMap := map[cmapi.KeyUsage]x509.KeyUsage{
+		cmapi.UsageSigning:           x509.KeyUsageDigitalSignature,
+		cmapi.UsageDigitalSignature:  x509.KeyUsageDigitalSignature,
+		cmapi.UsageContentCommitment: x509.KeyUsageContentCommitment,
+		cmapi.UsageKeyEncipherment:   x509.KeyUsageKeyEncipherment,
+		cmapi.UsageKeyAgreement:      x509.KeyUsageKeyAgreement,
+		cmapi.UsageDataEncipherment:  x509.KeyUsageDataEncipherment,
+		cmapi.UsageCertSign:          x509.KeyUsageCertSign,
+		cmapi.UsageCRLSign:           x509.KeyUsageCRLSign,
+		cmapi.UsageEncipherOnly:      x509.KeyUsageEncipherOnly,
+		cmapi.UsageDecipherOnly:      x509.KeyUsageDecipherOnly,
+	}
+	extKeyUsageMap := map[cmapi.KeyUsage]x509.ExtKeyUsage{
+		cmapi.UsageAny:             x509.ExtKeyUsageAny,
+		cmapi.UsageServerAuth:      x509.ExtKeyUsageServerAuth,
+		cmapi.UsageClientAuth:      x509.ExtKeyUsageClientAuth,
+		cmapi.UsageCodeSigning:     x509.ExtKeyUsageCodeSigning,
+		cmapi.UsageEmailProtection: x509.ExtKeyUsageEmailProtection,
+		cmapi.UsageSMIME:           x509.ExtKeyUsageEmailProtection,
+		cmapi.UsageIPsecEndSystem:  x509.ExtKeyUsageIPSECEndSystem,
+		cmapi.UsageIPsecTunnel:     x509.ExtKeyUsageIPSECTunnel,
+		cmapi.UsageIPsecUser:       x509.ExtKeyUsageIPSECUser,
+		cmapi.UsageTimestamping:    x509.ExtKeyUsageTimeStamping,
+		cmapi.UsageOCSPSigning:     x509.ExtKeyUsageOCSPSigning,
+		cmapi.UsageMicrosoftSGC:    x509.ExtKeyUsageMicrosoftServerGatedCrypto,
+		cmapi.UsageNetscapeSGC:     x509.ExtKeyUsageNetscapeServerGatedCrypto,
+	}
+
+	var keyUsage x509.KeyUsage
+	extKeyUsages := []x509.ExtKeyUsage{}
+	for _, usage := range usages {
+		if ku, ok := keyUsageMap[usage]; ok {
+			keyUsage |= ku
+		} else if eku, ok := extKeyUsageMap[usage]; ok {
+			extKeyUsages = append(extKeyUsages, eku)
+		} else {
+			return 0, nil, fmt.Errorf("unsupported key usage %q", usage)
+		}
+	}
+	return keyUsage, extKeyUsages, nil
+}
+
+// Duration returns the certificate duration from the spec or the cert-manager default.
+func Duration(spec cmapi.CertificateSpec) time.Duration {
+	if spec.Duration != nil && spec.Duration.Duration > 0 {
+		return spec.Duration.Duration
+	}
+	return DefaultDuration
+}
+
+// RenewBefore returns how long before expiry the certificate should be renewed,
+// either from the spec or a third of the certificate duration, like cert-manager does.
+func RenewBefore(spec cmapi.CertificateSpec) time.Duration {
+	if spec.RenewBefore != nil && spec.RenewBefore.Duration > 0 {
+		return spec.RenewBefore.Duration
+	}
+	return Duration(spec) / 3
+}
+
+// CertificateTemplate builds an x509 certificate template from the Certificate spec and the IP addresses.
+func CertificateTemplate(spec cmapi.CertificateSpec, ips []string) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %v", err)
+	}
+
+	keyUsage, extKeyUsages, err := keyUsages(spec.Usages)
+	if err != nil {
+		return nil, err
+	}
+
+	ipAddresses := []net.IP{}
+	for _, ip := range ips {
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return nil, fmt.Errorf("invalid IP address %q", ip)
+		}
+		ipAddresses = append(ipAddresses, parsed)
+	}
+
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName: spec.CommonName,
+		},
+		DNSNames:              spec.DNSNames,
+		IPAddresses:           ipAddresses,
+		NotBefore:             now,
+		NotAfter:              now.Add(Duration(spec)),
+		KeyUsage:              keyUsage,
+		ExtKeyUsage:           extKeyUsages,
+		BasicConstraintsValid: true,
+		IsCA:                  spec.IsCA,
+	}, nil
+}
+
+// CreateCSR creates a PEM encoded certificate signing request from the Certificate spec and the IP addresses.
+func CreateCSR(spec cmapi.CertificateSpec, ips []string, key crypto.Signer) ([]byte, error) {
+	template, err := CertificateTemplate(spec, ips)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:     template.Subject,
+		DNSNames:    template.DNSNames,
+		IPAddresses: template.IPAddresses,
+	}, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate signing request: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
+
+// Sign signs the certificate template for the public key and returns the PEM encoded certificate.
+func (ca *CA) Sign(template *x509.Certificate, publicKey crypto.PublicKey) ([]byte, error) {
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Cert, publicKey, ca.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign certificate: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+// NeedsRenewal checks if the issued certificate must be reissued because it doesn't match
+// the desired common name, DNS names and IP addresses or it is due for renewal.
+// It returns the reason for renewal or an empty string.
+func NeedsRenewal(cert *x509.Certificate, spec cmapi.CertificateSpec, desiredIPs []string, now time.Time) string {
+	if cert.Subject.CommonName != spec.CommonName {
+		return fmt.Sprintf("common name changed from %q to %q", cert.Subject.CommonName, spec.CommonName)
+	}
+
+	issuedIPs := []string{}
+	for _, ip := range cert.IPAddresses {
+		issuedIPs = append(issuedIPs, ip.String())
+	}
+	if missing, extra := utils.DiffLists(utils.NormalizeIPs(desiredIPs), issuedIPs); len(missing)+len(extra) > 0 {
+		return fmt.Sprintf("IP addresses changed: missing %v, extra %v", missing, extra)
+	}
+	if missing, extra := utils.DiffLists(spec.DNSNames, cert.DNSNames); len(missing)+len(extra) > 0 {
+		return fmt.Sprintf("DNS names changed: missing %v, extra %v", missing, extra)
+	}
+
+	if renewAt := cert.NotAfter.Add(-RenewBefore(spec)); now.After(renewAt) {
+		return fmt.Sprintf("certificate expires at %s and is due for renewal", cert.NotAfter.Format(time.RFC3339))
+	}
+	return ""
+}
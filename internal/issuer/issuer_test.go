@@ -0,0 +1,153 @@
+package issuer
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newTestCA creates a self-signed CA for tests.
+func newTestCA(t *testing.T) *CA {
+	key, err := GeneratePrivateKey(&cmapi.CertificatePrivateKey{Algorithm: cmapi.ECDSAKeyAlgorithm})
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(nil, template, template, key.Public(), key)
+	require.NoError(t, err)
+
+	keyPEM, err := EncodePrivateKeyPEM(key, nil)
+	require.NoError(t, err)
+	ca, err := LoadCA(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), keyPEM)
+	require.NoError(t, err)
+	return ca
+}
+
+func TestPrivateKeyRoundTrip(t *testing.T) {
+	specs := []*cmapi.CertificatePrivateKey{
+		nil,
+		{Algorithm: cmapi.RSAKeyAlgorithm, Encoding: cmapi.PKCS8},
+		{Algorithm: cmapi.ECDSAKeyAlgorithm, Size: 384},
+		{Algorithm: cmapi.Ed25519KeyAlgorithm},
+	}
+
+	for _, spec := range specs {
+		key, err := GeneratePrivateKey(spec)
+		require.NoError(t, err, "Key generation should succeed for %+v", spec)
+
+		keyPEM, err := EncodePrivateKeyPEM(key, spec)
+		require.NoError(t, err, "Key encoding should succeed for %+v", spec)
+
+		parsed, err := ParsePrivateKeyPEM(keyPEM)
+		require.NoError(t, err, "Key parsing should succeed for %+v", spec)
+		assert.Equal(t, key.Public(), parsed.Public(), "Parsed key should match the generated one for %+v", spec)
+	}
+
+	_, err := GeneratePrivateKey(&cmapi.CertificatePrivateKey{Algorithm: cmapi.ECDSAKeyAlgorithm, Size: 123})
+	assert.Error(t, err, "Unsupported ECDSA key size should fail")
+}
+
+func TestSignCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	spec := cmapi.CertificateSpec{
+		CommonName: "tidb-cluster",
+		DNSNames:   []string{"localhost", "tidb-cluster-pd"},
+		Duration:   &metav1.Duration{Duration: time.Hour},
+		Usages:     []cmapi.KeyUsage{cmapi.UsageDigitalSignature, cmapi.UsageServerAuth, cmapi.UsageClientAuth},
+	}
+
+	key, err := GeneratePrivateKey(nil)
+	require.NoError(t, err)
+	template, err := CertificateTemplate(spec, []string{"10.0.0.1", "10.0.0.2"})
+	require.NoError(t, err)
+	certPEM, err := ca.Sign(template, key.Public())
+	require.NoError(t, err)
+
+	block, _ := pem.Decode(certPEM)
+	require.NotNil(t, block)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+	_, err = cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	assert.NoError(t, err, "Certificate should be signed by the CA")
+
+	assert.Equal(t, "tidb-cluster", cert.Subject.CommonName)
+	assert.Equal(t, spec.DNSNames, cert.DNSNames)
+	assert.Len(t, cert.IPAddresses, 2)
+	assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}, cert.ExtKeyUsage)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), cert.NotAfter, time.Minute)
+
+	_, err = CertificateTemplate(spec, []string{"not-an-ip"})
+	assert.Error(t, err, "Invalid IP address should fail")
+}
+
+func TestNeedsRenewal(t *testing.T) {
+	ca := newTestCA(t)
+	spec := cmapi.CertificateSpec{
+		CommonName: "tidb-cluster",
+		DNSNames:   []string{"localhost"},
+		Duration:   &metav1.Duration{Duration: 3 * time.Hour},
+	}
+	ips := []string{"10.0.0.1", "10.0.0.2"}
+
+	key, err := GeneratePrivateKey(nil)
+	require.NoError(t, err)
+	template, err := CertificateTemplate(spec, ips)
+	require.NoError(t, err)
+	certPEM, err := ca.Sign(template, key.Public())
+	require.NoError(t, err)
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+
+	now := time.Now()
+	assert.Empty(t, NeedsRenewal(cert, spec, []string{"10.0.0.2", "10.0.0.1"}, now), "Matching certificate should not be renewed")
+	assert.NotEmpty(t, NeedsRenewal(cert, spec, []string{"10.0.0.1"}, now), "Removed IP should trigger renewal")
+	assert.NotEmpty(t, NeedsRenewal(cert, spec, append(ips, "10.0.0.3"), now), "Added IP should trigger renewal")
+	assert.NotEmpty(t, NeedsRenewal(cert, spec, ips, now.Add(2*time.Hour+time.Minute)), "Certificate in the renewal window should be renewed")
+
+	changed := spec
+	changed.DNSNames = []string{"localhost", "pd"}
+	assert.NotEmpty(t, NeedsRenewal(cert, changed, ips, now), "Changed DNS names should trigger renewal")
+}
+
+func TestCreateCSR(t *testing.T) {
+	spec := cmapi.CertificateSpec{
+		CommonName: "tidb-cluster",
+		DNSNames:   []string{"localhost"},
+	}
+	key, err := GeneratePrivateKey(nil)
+	require.NoError(t, err)
+
+	csrPEM, err := CreateCSR(spec, []string{"10.0.0.1"}, key)
+	require.NoError(t, err)
+
+	block, _ := pem.Decode(csrPEM)
+	require.NotNil(t, block)
+	assert.Equal(t, "CERTIFICATE REQUEST", block.Type)
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	require.NoError(t, err)
+	assert.NoError(t, csr.CheckSignature(), "CSR should be signed by the key")
+	assert.Equal(t, "tidb-cluster", csr.Subject.CommonName)
+	assert.Equal(t, []string{"localhost"}, csr.DNSNames)
+	require.Len(t, csr.IPAddresses, 1)
+	assert.Equal(t, "10.0.0.1", csr.IPAddresses[0].String())
+	assert.Equal(t, key.Public(), csr.PublicKey)
+}
@@ -0,0 +1,136 @@
+package k8s
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	cmclient "github.com/cert-manager/cert-manager/pkg/client/clientset/versioned"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/cfg"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// GetCertificate fetches the Certificate resource described by the certificate template.
+func (c *Client) GetCertificate(ctx context.Context, conf cfg.AppConfig) (*cmapi.Certificate, error) {
+	client, err := cmclient.NewForConfig(c.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	certificate, err := client.CertmanagerV1().Certificates(conf.Certificate.Namespace).Get(ctx, conf.Certificate.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get certificate %s/%s: %w", conf.Certificate.Namespace, conf.Certificate.Name, err)
+	}
+	return certificate, nil
+}
+
+// annotationPatch returns a merge patch setting an annotation, or removing it if the value is empty.
+func annotationPatch(key, value string) ([]byte, error) {
+	var annotationValue interface{}
+	if value != "" {
+		annotationValue = value
+	}
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{key: annotationValue},
+		},
+	})
+}
+
+// AnnotateCertificate sets an annotation on the Certificate described by the certificate template, an empty value removes it.
+func (c *Client) AnnotateCertificate(ctx context.Context, conf cfg.AppConfig, key, value string) error {
+	client, err := cmclient.NewForConfig(c.Config)
+	if err != nil {
+		return err
+	}
+	patch, err := annotationPatch(key, value)
+	if err != nil {
+		return err
+	}
+	_, err = client.CertmanagerV1().Certificates(conf.Certificate.Namespace).Patch(ctx, conf.Certificate.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to annotate certificate %s/%s: %w", conf.Certificate.Namespace, conf.Certificate.Name, err)
+	}
+	return nil
+}
+
+// GetIssuedCertificate reads the Secret named in the certificate template spec.secretName
+// and returns the parsed x509 leaf certificate stored in it.
+func (c *Client) GetIssuedCertificate(ctx context.Context, conf cfg.AppConfig) (*x509.Certificate, error) {
+	secretName := conf.Certificate.Spec.SecretName
+	secret, err := c.GetSecret(ctx, conf.Certificate.Namespace, secretName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s/%s: %s", conf.Certificate.Namespace, secretName, err.Error())
+	}
+
+	cert, err := utils.ParseCertificatePEM(secret.Data[corev1.TLSCertKey])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate from secret %s/%s: %s", conf.Certificate.Namespace, secretName, err.Error())
+	}
+	return cert, nil
+}
+
+// GetCertificateCondition returns the condition of the given type, or nil if the Certificate doesn't have it.
+func GetCertificateCondition(certificate *cmapi.Certificate, conditionType cmapi.CertificateConditionType) *cmapi.CertificateCondition {
+	for i := range certificate.Status.Conditions {
+		if certificate.Status.Conditions[i].Type == conditionType {
+			return &certificate.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// IsCertificateReady checks if the Certificate has the Ready=True condition.
+func IsCertificateReady(certificate *cmapi.Certificate) bool {
+	condition := GetCertificateCondition(certificate, cmapi.CertificateConditionReady)
+	return condition != nil && condition.Status == cmmeta.ConditionTrue
+}
+
+// CertificateReference returns an object reference to the Certificate, used for events.
+func CertificateReference(certificate *cmapi.Certificate) corev1.ObjectReference {
+	return corev1.ObjectReference{
+		APIVersion:      cmapi.SchemeGroupVersion.String(),
+		Kind:            cmapi.CertificateKind,
+		Name:            certificate.Name,
+		Namespace:       certificate.Namespace,
+		UID:             certificate.UID,
+		ResourceVersion: certificate.ResourceVersion,
+	}
+}
+
+// RecordEvent creates a Kubernetes Event attached to the referenced object.
+func (c *Client) RecordEvent(ctx context.Context, ref corev1.ObjectReference, eventType, reason, message string) error {
+	clientset, err := kubernetes.NewForConfig(c.Config)
+	if err != nil {
+		return fmt.Errorf("failed to create kubernetes client: %v", err)
+	}
+
+	now := metav1.NewTime(time.Now())
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: ref.Name + ".",
+			Namespace:    ref.Namespace,
+		},
+		InvolvedObject: ref,
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         corev1.EventSource{Component: "pd-assistant"},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	_, err = clientset.CoreV1().Events(ref.Namespace).Create(ctx, event, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to create event for %s %s/%s: %s", ref.Kind, ref.Namespace, ref.Name, err.Error())
+	}
+	return nil
+}
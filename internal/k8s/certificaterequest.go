@@ -0,0 +1,144 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"maps"
+
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	cmclient "github.com/cert-manager/cert-manager/pkg/client/clientset/versioned"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/cfg"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CertificateRequestLabel is the label set on CertificateRequests created by the assistant, holding the certificate name.
+const CertificateRequestLabel = "pd-assistant/certificate"
+
+// CertificateRequestHashAnnotation holds the hash of the spec and IPs a CertificateRequest was created for.
+const CertificateRequestHashAnnotation = "pd-assistant/request-hash"
+
+// CreateCertificateRequest creates a cert-manager CertificateRequest for the CSR, using the issuerRef,
+// duration, usages and isCA from the certificate template, annotated with the request hash.
+func (c *Client) CreateCertificateRequest(ctx context.Context, conf cfg.AppConfig, csrPEM []byte, requestHash string) (*cmapi.CertificateRequest, error) {
+	client, err := cmclient.NewForConfig(c.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := conf.Certificate.Spec
+	// Copy the annotations, the hash must not end up in the certificate template
+	annotations := maps.Clone(injectAnnotations(conf.Certificate))
+	annotations[CertificateRequestHashAnnotation] = requestHash
+	request := &cmapi.CertificateRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: conf.Certificate.Name + "-",
+			Namespace:    conf.Certificate.Namespace,
+			Labels:       map[string]string{CertificateRequestLabel: conf.Certificate.Name},
+			Annotations:  annotations,
+		},
+		Spec: cmapi.CertificateRequestSpec{
+			Request:   csrPEM,
+			IssuerRef: spec.IssuerRef,
+			Duration:  spec.Duration,
+			Usages:    spec.Usages,
+			IsCA:      spec.IsCA,
+		},
+	}
+
+	created, err := client.CertmanagerV1().CertificateRequests(request.Namespace).Create(ctx, request, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate request for %s/%s: %s", conf.Certificate.Namespace, conf.Certificate.Name, err.Error())
+	}
+	return created, nil
+}
+
+// GetCertificateRequest fetches a CertificateRequest.
+func (c *Client) GetCertificateRequest(ctx context.Context, namespace, name string) (*cmapi.CertificateRequest, error) {
+	client, err := cmclient.NewForConfig(c.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := client.CertmanagerV1().CertificateRequests(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get certificate request %s/%s: %s", namespace, name, err.Error())
+	}
+	return request, nil
+}
+
+// LatestCertificateRequest returns the most recently created CertificateRequest created by the assistant
+// for the certificate, or nil if there is none.
+func (c *Client) LatestCertificateRequest(ctx context.Context, conf cfg.AppConfig) (*cmapi.CertificateRequest, error) {
+	client, err := cmclient.NewForConfig(c.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	requests, err := client.CertmanagerV1().CertificateRequests(conf.Certificate.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: CertificateRequestLabel + "=" + conf.Certificate.Name,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list certificate requests for %s/%s: %s", conf.Certificate.Namespace, conf.Certificate.Name, err.Error())
+	}
+	var latest *cmapi.CertificateRequest
+	for i := range requests.Items {
+		request := &requests.Items[i]
+		if latest == nil || latest.CreationTimestamp.Before(&request.CreationTimestamp) ||
+			(latest.CreationTimestamp.Equal(&request.CreationTimestamp) && latest.Name < request.Name) {
+			latest = request
+		}
+	}
+	return latest, nil
+}
+
+// DeleteCertificateRequests deletes all CertificateRequests created by the assistant for the certificate.
+func (c *Client) DeleteCertificateRequests(ctx context.Context, conf cfg.AppConfig) error {
+	client, err := cmclient.NewForConfig(c.Config)
+	if err != nil {
+		return err
+	}
+
+	err = client.CertmanagerV1().CertificateRequests(conf.Certificate.Namespace).DeleteCollection(ctx, metav1.DeleteOptions{}, metav1.ListOptions{
+		LabelSelector: CertificateRequestLabel + "=" + conf.Certificate.Name,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete certificate requests for %s/%s: %s", conf.Certificate.Namespace, conf.Certificate.Name, err.Error())
+	}
+	return nil
+}
+
+// CertificateRequestReference returns an object reference to the CertificateRequest, used for events.
+func CertificateRequestReference(request *cmapi.CertificateRequest) corev1.ObjectReference {
+	return corev1.ObjectReference{
+		APIVersion:      cmapi.SchemeGroupVersion.String(),
+		Kind:            cmapi.CertificateRequestKind,
+		Name:            request.Name,
+		Namespace:       request.Namespace,
+		UID:             request.UID,
+		ResourceVersion: request.ResourceVersion,
+	}
+}
+
+// HasCertificateRequestCondition checks if the CertificateRequest has the condition of the given type set to True.
+// It returns the condition message as well.
+func HasCertificateRequestCondition(request *cmapi.CertificateRequest, conditionType cmapi.CertificateRequestConditionType) (bool, string) {
+	for _, condition := range request.Status.Conditions {
+		if condition.Type == conditionType && condition.Status == cmmeta.ConditionTrue {
+			return true, condition.Message
+		}
+	}
+	return false, ""
+}
+
+// CertificateRequestFailed checks if the CertificateRequest Ready condition is False with reason Failed.
+// It returns the condition message as well.
+func CertificateRequestFailed(request *cmapi.CertificateRequest) (bool, string) {
+	for _, condition := range request.Status.Conditions {
+		if condition.Type == cmapi.CertificateRequestConditionReady && condition.Status == cmmeta.ConditionFalse && condition.Reason == cmapi.CertificateRequestReasonFailed {
+			return true, condition.Message
+		}
+	}
+	return false, ""
+}
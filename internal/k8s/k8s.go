@@ -3,6 +3,7 @@ package k8s
 import (
 	"context"
 	"fmt"
+	"slices"
 	"time"
 
 	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
@@ -56,7 +57,7 @@ func (c *Client) Init(kubeConfigPath string) error {
 
 // GetCiliumNodes retrieves a list of CiliumNode resources from the cilium.io/v2 API
 // and returns a list of CiliumInternalIP addresses.
-func (c *Client) GetCiliumNodes() ([]string, error) {
+func (c *Client) GetCiliumNodes(ctx context.Context) ([]string, error) {
 
 	ciliumNodeGVR := schema.GroupVersionResource{
 		Group:    "cilium.io",
@@ -69,7 +70,7 @@ func (c *Client) GetCiliumNodes() ([]string, error) {
 		return nil, fmt.Errorf("failed to create dynamic client: %v", err)
 	}
 
-	ciliumNodes, err := dynamicClient.Resource(ciliumNodeGVR).Namespace("").List(context.TODO(), metav1.ListOptions{})
+	ciliumNodes, err := dynamicClient.Resource(ciliumNodeGVR).Namespace("").List(ctx, metav1.ListOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to list CiliumNode resources: %v", err)
 	}
@@ -105,18 +106,35 @@ func (c *Client) GetCiliumNodes() ([]string, error) {
 	return internalIPs, nil
 }
 
+// DesiredIPAddresses returns the IP addresses the certificate should contain:
+// the static ones from the certificate template followed by the provided ones and the pinned ones,
+// without the excluded ones.
+func DesiredIPAddresses(conf cfg.AppConfig, inIPs []string) []string {
+	IPs := make([]string, 0, len(conf.Certificate.Spec.IPAddresses)+len(inIPs)+len(conf.IPOverrides.Pinned))
+	IPs = append(IPs, conf.Certificate.Spec.IPAddresses...)
+	IPs = append(IPs, inIPs...)
+	present := utils.NormalizeIPs(IPs)
+	for _, pinned := range conf.IPOverrides.Pinned {
+		if !slices.Contains(present, pinned.IP) {
+			IPs = append(IPs, pinned.IP)
+		}
+	}
+	return slices.DeleteFunc(IPs, conf.IPOverrides.Excludes)
+}
+
 // UpdateCertificate updates the certificate in Kubernetes with the provided IP addresses.
-func (c *Client) UpdateCertificate(conf cfg.AppConfig, inIPs []string) error {
+// It returns the resulting Certificate and whether its spec was changed.
+func (c *Client) UpdateCertificate(ctx context.Context, conf cfg.AppConfig, inIPs []string) (*cmapi.Certificate, bool, error) {
 	client, err := cmclient.NewForConfig(c.Config)
 	if err != nil {
-		return err
+		return nil, false, err
 	}
 
 	// Add the IPs to the certificate loaded from the configuration
-	IPs := append(conf.Certificate.Spec.IPAddresses, inIPs...)
+	IPs := DesiredIPAddresses(conf, inIPs)
 
 	// Check if the certificate already exists
-	certificate, err := client.CertmanagerV1().Certificates(conf.Certificate.Namespace).Get(context.TODO(), conf.Certificate.Name, metav1.GetOptions{})
+	certificate, err := client.CertmanagerV1().Certificates(conf.Certificate.Namespace).Get(ctx, conf.Certificate.Name, metav1.GetOptions{})
 	if err != nil {
 		if errors.IsNotFound(err) {
 			// Override IP addresses from the configuration
@@ -124,30 +142,30 @@ func (c *Client) UpdateCertificate(conf cfg.AppConfig, inIPs []string) error {
 			newCert.Spec.IPAddresses = IPs
 			newCert.SetAnnotations(injectAnnotations(conf.Certificate))
 			glog.Infof("Certificate %s/%s not found, creating a new one", newCert.Namespace, newCert.Name)
-			_, err = client.CertmanagerV1().Certificates(newCert.Namespace).Create(context.TODO(), newCert, metav1.CreateOptions{})
+			created, err := client.CertmanagerV1().Certificates(newCert.Namespace).Create(ctx, newCert, metav1.CreateOptions{})
 			if err != nil {
-				return fmt.Errorf("failed to create certificate %s/%s: %s", newCert.Namespace, newCert.Name, err.Error())
+				return nil, false, fmt.Errorf("failed to create certificate %s/%s: %s", newCert.Namespace, newCert.Name, err.Error())
 			}
 			glog.Infof("Certificate %s/%s created successfully", newCert.Namespace, newCert.Name)
-			return nil
+			return created, true, nil
 		}
-		return fmt.Errorf("failed to get certificate %s/%s: %s", certificate.Namespace, conf.Certificate.Name, err.Error())
+		return nil, false, fmt.Errorf("failed to get certificate %s/%s: %s", conf.Certificate.Namespace, conf.Certificate.Name, err.Error())
 	}
 
 	// Check if the IPs are already set and are the same as the current ones
 	if utils.IPListsEqual(certificate.Spec.IPAddresses, IPs) {
 		glog.V(4).Infof("Certificate %s/%s already has the same IPs, no update needed", conf.Certificate.Namespace, conf.Certificate.Name)
-		return nil
+		return certificate, false, nil
 	}
 
 	// Update Certificate IPs and some annotations
 	glog.V(6).Infof("Certificate %s/%s found, updating IPs: %v", conf.Certificate.Namespace, conf.Certificate.Name, IPs)
 	certificate.Spec.IPAddresses = IPs
 	certificate.SetAnnotations(injectAnnotations(*certificate))
-	_, err = client.CertmanagerV1().Certificates(conf.Certificate.Namespace).Update(context.TODO(), certificate, metav1.UpdateOptions{})
+	updated, err := client.CertmanagerV1().Certificates(conf.Certificate.Namespace).Update(ctx, certificate, metav1.UpdateOptions{})
 	if err != nil {
-		return fmt.Errorf("failed to update certificate %s/%s: %s", conf.Certificate.Namespace, conf.Certificate.Name, err.Error())
+		return nil, false, fmt.Errorf("failed to update certificate %s/%s: %s", conf.Certificate.Namespace, conf.Certificate.Name, err.Error())
 	}
 	glog.Infof("Certificate %s/%s updated successfully", conf.Certificate.Namespace, conf.Certificate.Name)
-	return nil
+	return updated, true, nil
 }
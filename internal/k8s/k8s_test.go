@@ -5,6 +5,8 @@ import (
 	"time"
 
 	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/cfg"
 	"github.com/stretchr/testify/assert"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -67,3 +69,59 @@ func TestInjectAnnotationsWithAnnotation(t *testing.T) {
 	assert.NotEmpty(t, annotations["last-updated"], "Annotation 'last-updated' should not be empty")
 	assert.Equal(t, "existing-value", annotations["existing-key"], "Existing annotations should be preserved")
 }
+
+func TestIsCertificateReady(t *testing.T) {
+	certificate := &cmapi.Certificate{}
+	assert.False(t, IsCertificateReady(certificate), "Certificate without conditions should not be ready")
+
+	certificate.Status.Conditions = []cmapi.CertificateCondition{
+		{Type: cmapi.CertificateConditionIssuing, Status: cmmeta.ConditionTrue},
+		{Type: cmapi.CertificateConditionReady, Status: cmmeta.ConditionFalse},
+	}
+	assert.False(t, IsCertificateReady(certificate), "Certificate with Ready=False should not be ready")
+	assert.NotNil(t, GetCertificateCondition(certificate, cmapi.CertificateConditionIssuing), "Issuing condition should be found")
+
+	certificate.Status.Conditions[1].Status = cmmeta.ConditionTrue
+	assert.True(t, IsCertificateReady(certificate), "Certificate with Ready=True should be ready")
+}
+
+func TestDesiredIPAddresses(t *testing.T) {
+	conf := cfg.AppConfig{}
+	conf.Certificate.Spec.IPAddresses = make([]string, 1, 10)
+	conf.Certificate.Spec.IPAddresses[0] = "10.0.0.1"
+
+	first := DesiredIPAddresses(conf, []string{"10.0.0.2"})
+	second := DesiredIPAddresses(conf, []string{"10.0.0.3"})
+
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2"}, first, "Template IPs should come first")
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.3"}, second, "Template IPs should not be shared between calls")
+
+	conf.IPOverrides = cfg.IPOverrides{
+		Pinned:   []cfg.IPOverride{{IP: "10.0.0.4"}, {IP: "10.0.0.2"}},
+		Excluded: []cfg.IPOverride{{IP: "10.0.0.3"}},
+	}
+	overridden := DesiredIPAddresses(conf, []string{"10.0.0.2", "10.0.0.3"})
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2", "10.0.0.4"}, overridden, "Pinned IPs should be added once and excluded IPs removed")
+}
+
+func TestCertificateRequestConditions(t *testing.T) {
+	request := &cmapi.CertificateRequest{}
+	approved, _ := HasCertificateRequestCondition(request, cmapi.CertificateRequestConditionApproved)
+	assert.False(t, approved, "Request without conditions should not be approved")
+
+	request.Status.Conditions = []cmapi.CertificateRequestCondition{
+		{Type: cmapi.CertificateRequestConditionDenied, Status: cmmeta.ConditionTrue, Message: "IPs not allowed"},
+		{Type: cmapi.CertificateRequestConditionReady, Status: cmmeta.ConditionFalse, Reason: cmapi.CertificateRequestReasonFailed, Message: "issuer failed"},
+	}
+	denied, message := HasCertificateRequestCondition(request, cmapi.CertificateRequestConditionDenied)
+	assert.True(t, denied, "Request should be denied")
+	assert.Equal(t, "IPs not allowed", message)
+
+	failed, message := CertificateRequestFailed(request)
+	assert.True(t, failed, "Request should be failed")
+	assert.Equal(t, "issuer failed", message)
+
+	request.Status.Conditions[1].Reason = cmapi.CertificateRequestReasonPending
+	failed, _ = CertificateRequestFailed(request)
+	assert.False(t, failed, "Pending request should not be failed")
+}
@@ -0,0 +1,74 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// CertificateHashAnnotation is the pod template annotation holding the hash of the issued certificate.
+const CertificateHashAnnotation = "pd-assistant/certificate-hash"
+
+var tidbClusterGVR = schema.GroupVersionResource{
+	Group:    "pingcap.com",
+	Version:  "v1alpha1",
+	Resource: "tidbclusters",
+}
+
+// AnnotateStatefulSetPodTemplate sets an annotation on the StatefulSet pod template, which makes its pods roll.
+func (c *Client) AnnotateStatefulSetPodTemplate(ctx context.Context, namespace, name, key, value string) error {
+	clientset, err := kubernetes.NewForConfig(c.Config)
+	if err != nil {
+		return fmt.Errorf("failed to create kubernetes client: %v", err)
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]string{key: value},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = clientset.AppsV1().StatefulSets(namespace).Patch(ctx, name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to patch statefulset %s/%s: %s", namespace, name, err.Error())
+	}
+	return nil
+}
+
+// AnnotateTidbClusterPDPods sets an annotation on PD pods of the TidbCluster, which makes the operator roll them.
+func (c *Client) AnnotateTidbClusterPDPods(ctx context.Context, namespace, name, key, value string) error {
+	dynamicClient, err := dynamic.NewForConfig(c.Config)
+	if err != nil {
+		return fmt.Errorf("failed to create dynamic client: %v", err)
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"pd": map[string]interface{}{
+				"annotations": map[string]string{key: value},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = dynamicClient.Resource(tidbClusterGVR).Namespace(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to patch tidbcluster %s/%s: %s", namespace, name, err.Error())
+	}
+	return nil
+}
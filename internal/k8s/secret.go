@@ -0,0 +1,111 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// CACertKey is the Secret key holding the CA certificate, as used by cert-manager.
+const CACertKey = "ca.crt"
+
+// GetSecret fetches a Secret, it returns a NotFound API error if the Secret doesn't exist.
+func (c *Client) GetSecret(ctx context.Context, namespace, name string) (*corev1.Secret, error) {
+	clientset, err := kubernetes.NewForConfig(c.Config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client: %v", err)
+	}
+	return clientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+// GetConfigMap fetches a ConfigMap, it returns a NotFound API error if the ConfigMap doesn't exist.
+func (c *Client) GetConfigMap(ctx context.Context, namespace, name string) (*corev1.ConfigMap, error) {
+	clientset, err := kubernetes.NewForConfig(c.Config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client: %v", err)
+	}
+	return clientset.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+// AnnotateSecret sets an annotation on the Secret, an empty value removes it.
+func (c *Client) AnnotateSecret(ctx context.Context, namespace, name, key, value string) error {
+	clientset, err := kubernetes.NewForConfig(c.Config)
+	if err != nil {
+		return fmt.Errorf("failed to create kubernetes client: %v", err)
+	}
+	patch, err := annotationPatch(key, value)
+	if err != nil {
+		return err
+	}
+	if _, err := clientset.CoreV1().Secrets(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to annotate secret %s/%s: %w", namespace, name, err)
+	}
+	return nil
+}
+
+// PrivateKeySecretSuffix is appended to the certificate Secret name to get the Secret
+// holding the private key used for CertificateRequests.
+const PrivateKeySecretSuffix = "-key"
+
+// ApplyTLSSecret creates or updates a kubernetes.io/tls Secret with the certificate, key and CA certificate.
+func (c *Client) ApplyTLSSecret(ctx context.Context, namespace, name string, certPEM, keyPEM, caPEM []byte) error {
+	return c.applySecret(ctx, namespace, name, corev1.SecretTypeTLS, map[string][]byte{
+		corev1.TLSCertKey:       certPEM,
+		corev1.TLSPrivateKeyKey: keyPEM,
+		CACertKey:               caPEM,
+	})
+}
+
+// ApplyPrivateKeySecret creates or updates an Opaque Secret holding just the private key.
+func (c *Client) ApplyPrivateKeySecret(ctx context.Context, namespace, name string, keyPEM []byte) error {
+	return c.applySecret(ctx, namespace, name, corev1.SecretTypeOpaque, map[string][]byte{
+		corev1.TLSPrivateKeyKey: keyPEM,
+	})
+}
+
+// applySecret creates a Secret of the given type or updates keys of the existing one.
+func (c *Client) applySecret(ctx context.Context, namespace, name string, secretType corev1.SecretType, data map[string][]byte) error {
+	clientset, err := kubernetes.NewForConfig(c.Config)
+	if err != nil {
+		return fmt.Errorf("failed to create kubernetes client: %v", err)
+	}
+
+	secret, err := clientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to get secret %s/%s: %s", namespace, name, err.Error())
+		}
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   namespace,
+				Annotations: map[string]string{"managed-by": "pd-assistant"},
+			},
+			Type: secretType,
+			Data: data,
+		}
+		if _, err := clientset.CoreV1().Secrets(namespace).Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create secret %s/%s: %s", namespace, name, err.Error())
+		}
+		return nil
+	}
+
+	if secret.Type != secretType {
+		return fmt.Errorf("secret %s/%s has type %q, expected %q", namespace, name, secret.Type, secretType)
+	}
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	for key, value := range data {
+		secret.Data[key] = value
+	}
+	if _, err := clientset.CoreV1().Secrets(namespace).Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update secret %s/%s: %s", namespace, name, err.Error())
+	}
+	return nil
+}
@@ -11,15 +11,42 @@ type AppMetrics struct {
 	Registry *prometheus.Registry
 
 	// Gauges
-	Config   *prometheus.GaugeVec
-	AllIPs   *prometheus.GaugeVec
-	LocalIPs *prometheus.GaugeVec
+	Config                 *prometheus.GaugeVec
+	AllIPs                 *prometheus.GaugeVec
+	LocalIPs               *prometheus.GaugeVec
+	CertSANDrift           *prometheus.GaugeVec
+	CertNotAfter           *prometheus.GaugeVec
+	CertReady              *prometheus.GaugeVec
+	CertIssuanceInFlight   *prometheus.GaugeVec
+	CertIssuanceDuration   *prometheus.GaugeVec
+	PDReloadPending        *prometheus.GaugeVec
+	EndpointCertCovered    *prometheus.GaugeVec
+	EndpointCertMissingIPs *prometheus.GaugeVec
+	EndpointCertExpiryDays *prometheus.GaugeVec
+	Leader                 *prometheus.GaugeVec
+	Members                *prometheus.GaugeVec
+	CertUpdatesPaused      *prometheus.GaugeVec
+	CertChangeBlocked      *prometheus.GaugeVec
+	IPOverrides            *prometheus.GaugeVec
 
 	// Counters
 	CertUpdateErrors       *prometheus.CounterVec
 	PDAssistantFetchErrors *prometheus.CounterVec
+	FetchErrorReasons      *prometheus.CounterVec
 	ConsensusErrors        *prometheus.CounterVec
 	K8sPollErrors          *prometheus.CounterVec
+	CertCheckErrors        *prometheus.CounterVec
+	CertIssuances          *prometheus.CounterVec
+	PDReloads              *prometheus.CounterVec
+	EndpointVerifyErrors   *prometheus.CounterVec
+	Reconciles             *prometheus.CounterVec
+	TemplateReloads        *prometheus.CounterVec
+	NotificationsSent      *prometheus.CounterVec
+	NotificationsReceived  *prometheus.CounterVec
+	GossipErrors           *prometheus.CounterVec
+	TokenReloads           *prometheus.CounterVec
+	AdminActions           *prometheus.CounterVec
+	IPOverridesReloads     *prometheus.CounterVec
 }
 
 func InitMetrics(version string) AppMetrics {
@@ -55,6 +82,96 @@ func InitMetrics(version string) AppMetrics {
 		[]string{},
 	)
 
+	am.CertSANDrift = promauto.With(am.Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "pd_assistant",
+			Name:      "cert_san_drift",
+			Help:      "Number of SANs differing between the issued certificate and the desired set",
+		},
+		[]string{"type"},
+	)
+
+	am.CertNotAfter = promauto.With(am.Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "pd_assistant",
+			Name:      "cert_not_after_timestamp_seconds",
+			Help:      "Expiration time of the issued certificate as a unix timestamp",
+		},
+		[]string{},
+	)
+
+	am.CertReady = promauto.With(am.Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "pd_assistant",
+			Name:      "cert_ready",
+			Help:      "Certificate Ready condition status (1 if Ready=True, 0 otherwise)",
+		},
+		[]string{},
+	)
+
+	am.CertIssuanceInFlight = promauto.With(am.Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "pd_assistant",
+			Name:      "cert_issuance_in_flight",
+			Help:      "Whether a certificate issuance is in flight (1) or not (0)",
+		},
+		[]string{},
+	)
+
+	am.CertIssuanceDuration = promauto.With(am.Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "pd_assistant",
+			Name:      "cert_issuance_duration_seconds",
+			Help:      "Duration of the last finished certificate issuance in seconds",
+		},
+		[]string{},
+	)
+
+	am.PDReloadPending = promauto.With(am.Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "pd_assistant",
+			Name:      "pd_reload_pending",
+			Help:      "Whether a PD reload is pending after certificate issuance (1) or not (0)",
+		},
+		[]string{},
+	)
+
+	am.EndpointCertCovered = promauto.With(am.Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "pd_assistant",
+			Name:      "endpoint_cert_ips_covered",
+			Help:      "Whether the certificate served by a PD endpoint covers all desired IPs (1) or not (0)",
+		},
+		[]string{"endpoint", "type"},
+	)
+
+	am.EndpointCertMissingIPs = promauto.With(am.Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "pd_assistant",
+			Name:      "endpoint_cert_missing_ips",
+			Help:      "Number of desired IPs missing from the certificate served by a PD endpoint",
+		},
+		[]string{"endpoint", "type"},
+	)
+
+	am.EndpointCertExpiryDays = promauto.With(am.Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "pd_assistant",
+			Name:      "endpoint_cert_expiry_days",
+			Help:      "Days until the certificate served by a PD endpoint expires",
+		},
+		[]string{"endpoint", "type"},
+	)
+
+	am.Leader = promauto.With(am.Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "pd_assistant",
+			Name:      "leader",
+			Help:      "Whether this assistant is the leader allowed to write certificates (1) or not (0)",
+		},
+		[]string{},
+	)
+
 	am.CertUpdateErrors = promauto.With(am.Registry).NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: "pd_assistant",
@@ -73,6 +190,15 @@ func InitMetrics(version string) AppMetrics {
 		[]string{"pd_assistant", "type"},
 	)
 
+	am.FetchErrorReasons = promauto.With(am.Registry).NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "pd_assistant",
+			Name:      "fetch_error_reasons_total",
+			Help:      "Total number of errors fetching data from PD Assistants by reason",
+		},
+		[]string{"pd_assistant", "type", "reason"},
+	)
+
 	am.ConsensusErrors = promauto.With(am.Registry).NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: "pd_assistant",
@@ -91,10 +217,157 @@ func InitMetrics(version string) AppMetrics {
 		[]string{},
 	)
 
+	am.CertCheckErrors = promauto.With(am.Registry).NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "pd_assistant",
+			Name:      "cert_check_errors_total",
+			Help:      "Total number of errors checking the issued certificate",
+		},
+		[]string{},
+	)
+
+	am.CertIssuances = promauto.With(am.Registry).NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "pd_assistant",
+			Name:      "cert_issuances_total",
+			Help:      "Total number of finished certificate issuances by result",
+		},
+		[]string{"result"},
+	)
+
+	am.PDReloads = promauto.With(am.Registry).NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "pd_assistant",
+			Name:      "pd_reloads_total",
+			Help:      "Total number of PD reload attempts after certificate issuance by result",
+		},
+		[]string{"result"},
+	)
+
+	am.EndpointVerifyErrors = promauto.With(am.Registry).NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "pd_assistant",
+			Name:      "endpoint_verify_errors_total",
+			Help:      "Total number of errors verifying certificates served by PD endpoints",
+		},
+		[]string{"endpoint", "type"},
+	)
+
+	am.Reconciles = promauto.With(am.Registry).NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "pd_assistant",
+			Name:      "reconciles_total",
+			Help:      "Total number of certificate reconcile runs by trigger reason",
+		},
+		[]string{"reason"},
+	)
+
+	am.TemplateReloads = promauto.With(am.Registry).NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "pd_assistant",
+			Name:      "template_reloads_total",
+			Help:      "Total number of certificate template reloads by result",
+		},
+		[]string{"result"},
+	)
+
+	am.NotificationsSent = promauto.With(am.Registry).NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "pd_assistant",
+			Name:      "notifications_sent_total",
+			Help:      "Total number of local IP change notifications sent to pd-assistants by result",
+		},
+		[]string{"pd_assistant", "result"},
+	)
+
+	am.NotificationsReceived = promauto.With(am.Registry).NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "pd_assistant",
+			Name:      "notifications_received_total",
+			Help:      "Total number of local IP change notifications received from pd-assistants by result",
+		},
+		[]string{"result"},
+	)
+
+	am.Members = promauto.With(am.Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "pd_assistant",
+			Name:      "gossip_members",
+			Help:      "Number of pd-assistants known in gossip membership by state",
+		},
+		[]string{"state"},
+	)
+
+	am.GossipErrors = promauto.With(am.Registry).NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "pd_assistant",
+			Name:      "gossip_errors_total",
+			Help:      "Total number of failed gossip exchanges with pd-assistants",
+		},
+		[]string{"pd_assistant"},
+	)
+
+	am.TokenReloads = promauto.With(am.Registry).NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "pd_assistant",
+			Name:      "token_reloads_total",
+			Help:      "Total number of bearer token reloads by result",
+		},
+		[]string{"result"},
+	)
+
+	am.CertUpdatesPaused = promauto.With(am.Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "pd_assistant",
+			Name:      "cert_updates_paused",
+			Help:      "Whether certificate updates are paused through the admin API (1) or not (0)",
+		},
+		[]string{},
+	)
+
+	am.CertChangeBlocked = promauto.With(am.Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "pd_assistant",
+			Name:      "cert_change_blocked",
+			Help:      "Whether a certificate change removing too many IPs waits for approval (1) or not (0)",
+		},
+		[]string{},
+	)
+
+	am.AdminActions = promauto.With(am.Registry).NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "pd_assistant",
+			Name:      "admin_actions_total",
+			Help:      "Total number of admin API actions by action and result",
+		},
+		[]string{"action", "result"},
+	)
+
+	am.IPOverrides = promauto.With(am.Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "pd_assistant",
+			Name:      "ip_overrides",
+			Help:      "Number of active manual IP overrides by kind, pinned or excluded",
+		},
+		[]string{"kind"},
+	)
+
+	am.IPOverridesReloads = promauto.With(am.Registry).NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "pd_assistant",
+			Name:      "ip_overrides_reloads_total",
+			Help:      "Total number of IP overrides reloads by result",
+		},
+		[]string{"result"},
+	)
+
 	am.Config.WithLabelValues(version).Set(1)
 	am.CertUpdateErrors.WithLabelValues().Add(0)
 	am.ConsensusErrors.WithLabelValues().Add(0)
 	am.K8sPollErrors.WithLabelValues().Add(0)
+	am.CertCheckErrors.WithLabelValues().Add(0)
+	am.CertIssuanceInFlight.WithLabelValues().Set(0)
+	am.PDReloadPending.WithLabelValues().Set(0)
 
 	am.Registry.MustRegister()
 	return am
@@ -0,0 +1,353 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/api"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/cfg"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/k8s"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/utils"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// ReconcileAdmin is the reason of reconciles forced through the admin API, they aren't delayed
+const ReconcileAdmin = "admin"
+
+// Annotations persisting the admin state on the Certificate, or on the certificate Secret without cert-manager,
+// so it's shared by all replicas and survives restarts
+const (
+	PausedAnnotation   = "pd-assistant/paused"
+	BlockedAnnotation  = "pd-assistant/blocked-change"
+	ApprovedAnnotation = "pd-assistant/approved-change"
+)
+
+var (
+	errPaused           = errors.New("certificate updates are paused")
+	errApprovalRequired = errors.New("certificate change requires approval")
+)
+
+// Pause is a pause of certificate updates through the admin API.
+type Pause struct {
+	By     string    `json:"by"`
+	At     time.Time `json:"at"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// BlockedChange is a certificate change removing more IPs than allowed, waiting for approval.
+type BlockedChange struct {
+	// Hash is the v2 content hash of the desired IPs, it identifies the change in approvals
+	Hash       string    `json:"hash"`
+	RemovedIPs []string  `json:"removed_ips"`
+	Since      time.Time `json:"since"`
+}
+
+// AdminStatus holds the state changed through the admin API.
+type AdminStatus struct {
+	Paused       *Pause         `json:"paused,omitempty"`
+	Blocked      *BlockedChange `json:"blocked,omitempty"`
+	ApprovedHash string         `json:"approved_hash,omitempty"`
+}
+
+func (s *State) getAdmin() AdminStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.admin
+}
+
+// updateAdmin changes the admin state and its metrics.
+func (s *State) updateAdmin(update func(admin *AdminStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	update(&s.admin)
+	if s.admin.Paused != nil {
+		s.Metrics.CertUpdatesPaused.WithLabelValues().Set(1)
+	} else {
+		s.Metrics.CertUpdatesPaused.WithLabelValues().Set(0)
+	}
+	if s.admin.Blocked != nil {
+		s.Metrics.CertChangeBlocked.WithLabelValues().Set(1)
+	} else {
+		s.Metrics.CertChangeBlocked.WithLabelValues().Set(0)
+	}
+}
+
+// adminAnnotations returns annotations of the object holding the admin state, nil if it doesn't exist yet.
+func adminAnnotations(ctx context.Context, conf cfg.AppConfig, kc k8s.Client) (map[string]string, error) {
+	if conf.IsCertManagerMode() {
+		certificate, err := kc.GetCertificate(ctx, conf)
+		if k8serrors.IsNotFound(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return certificate.Annotations, nil
+	}
+	secret, err := kc.GetSecret(ctx, conf.Certificate.Namespace, conf.Certificate.Spec.SecretName)
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s/%s: %v", conf.Certificate.Namespace, conf.Certificate.Spec.SecretName, err)
+	}
+	return secret.Annotations, nil
+}
+
+// annotateAdmin persists admin state in an annotation, an empty value removes it.
+func annotateAdmin(ctx context.Context, conf cfg.AppConfig, kc k8s.Client, key, value string) error {
+	if conf.IsCertManagerMode() {
+		return kc.AnnotateCertificate(ctx, conf, key, value)
+	}
+	return kc.AnnotateSecret(ctx, conf.Certificate.Namespace, conf.Certificate.Spec.SecretName, key, value)
+}
+
+// syncAdmin reads the persisted admin state, changes made through other replicas included.
+func (s *State) syncAdmin(ctx context.Context, conf cfg.AppConfig, kc k8s.Client) error {
+	annotations, err := adminAnnotations(ctx, conf, kc)
+	if err != nil {
+		return err
+	}
+
+	var pause *Pause
+	if value, ok := annotations[PausedAnnotation]; ok {
+		pause = &Pause{}
+		if err := json.Unmarshal([]byte(value), pause); err != nil {
+			// Stay on the safe side, something meant to pause updates
+			glog.Errorf("Invalid %s annotation %q, certificate updates stay paused: %v", PausedAnnotation, value, err)
+			pause = &Pause{Reason: "invalid annotation"}
+		}
+	}
+	var blocked *BlockedChange
+	if value, ok := annotations[BlockedAnnotation]; ok {
+		blocked = &BlockedChange{}
+		if err := json.Unmarshal([]byte(value), blocked); err != nil {
+			// The next reconcile of the leader blocks the change again
+			glog.Errorf("Ignoring invalid %s annotation %q: %v", BlockedAnnotation, value, err)
+			blocked = nil
+		}
+	}
+	s.updateAdmin(func(admin *AdminStatus) {
+		admin.Paused = pause
+		admin.Blocked = blocked
+		admin.ApprovedHash = annotations[ApprovedAnnotation]
+	})
+	return nil
+}
+
+// setBlocked persists the blocked change, so approvals work through every replica, nil unblocks it.
+func (s *State) setBlocked(ctx context.Context, conf cfg.AppConfig, kc k8s.Client, blocked *BlockedChange) {
+	value := ""
+	if blocked != nil {
+		data, _ := json.Marshal(blocked)
+		value = string(data)
+	}
+	if s.getAdmin().Blocked != nil || blocked != nil {
+		if err := annotateAdmin(ctx, conf, kc, BlockedAnnotation, value); err != nil {
+			glog.Errorf("Failed to persist the blocked certificate change: %v", err)
+		}
+	}
+	s.updateAdmin(func(admin *AdminStatus) { admin.Blocked = blocked })
+}
+
+// currentCertificateIPs returns the IPs of the Certificate spec, or of the issued certificate without cert-manager.
+// It returns nil if there is no certificate yet.
+func currentCertificateIPs(ctx context.Context, conf cfg.AppConfig, kc k8s.Client) ([]string, error) {
+	if conf.IsCertManagerMode() {
+		certificate, err := kc.GetCertificate(ctx, conf)
+		if k8serrors.IsNotFound(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return certificate.Spec.IPAddresses, nil
+	}
+	if annotations, err := adminAnnotations(ctx, conf, kc); err != nil || annotations == nil {
+		return nil, err
+	}
+	issued, err := kc.GetIssuedCertificate(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+	ips := []string{}
+	for _, ip := range issued.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+	return ips, nil
+}
+
+// checkApproval blocks certificate changes removing more IPs than allowed, until they are approved.
+func (s *State) checkApproval(ctx context.Context, conf cfg.AppConfig, kc k8s.Client, allIPAddresses []string) error {
+	if conf.ReconcileConfig.MaxRemovedIPs <= 0 {
+		return nil
+	}
+	currentIPs, err := currentCertificateIPs(ctx, conf, kc)
+	if err != nil {
+		return fmt.Errorf("failed to read certificate IPs: %v", err)
+	}
+	desiredIPs := utils.NormalizeIPs(k8s.DesiredIPAddresses(conf, allIPAddresses))
+	_, removed := utils.DiffLists(desiredIPs, utils.NormalizeIPs(currentIPs))
+	if len(removed) <= conf.ReconcileConfig.MaxRemovedIPs {
+		s.setBlocked(ctx, conf, kc, nil)
+		return nil
+	}
+
+	hash := api.ContentHash(desiredIPs)
+	admin := s.getAdmin()
+	if admin.ApprovedHash == hash {
+		glog.Infof("Applying approved certificate change %s removing IPs %v", hash, removed)
+		s.setBlocked(ctx, conf, kc, nil)
+		return nil
+	}
+	slices.Sort(removed)
+	if admin.Blocked == nil || admin.Blocked.Hash != hash {
+		glog.Warningf("Certificate change %s removes %d IPs %v, more than %d, approve it through %s",
+			hash, len(removed), removed, conf.ReconcileConfig.MaxRemovedIPs, api.ApiAdminApprovePath)
+		s.setBlocked(ctx, conf, kc, &BlockedChange{Hash: hash, RemovedIPs: removed, Since: time.Now().UTC()})
+	}
+	return fmt.Errorf("%w: change %s removes %d IPs, more than %d", errApprovalRequired, hash, len(removed), conf.ReconcileConfig.MaxRemovedIPs)
+}
+
+// clearApproval removes an approval once the approved change was applied, so it can't approve a later change.
+// Updates postponed by an issuance in flight apply nothing, the approval is kept until the certificate matches it.
+func (s *State) clearApproval(ctx context.Context, conf cfg.AppConfig, kc k8s.Client) {
+	approvedHash := s.getAdmin().ApprovedHash
+	if approvedHash == "" {
+		return
+	}
+	currentIPs, err := currentCertificateIPs(ctx, conf, kc)
+	if err != nil {
+		glog.Errorf("Failed to check if the approved change was applied: %v", err)
+		return
+	}
+	if api.ContentHash(utils.NormalizeIPs(currentIPs)) != approvedHash {
+		glog.V(4).Infof("Approved certificate change %s isn't applied yet, keeping the approval", approvedHash)
+		return
+	}
+	if err := annotateAdmin(ctx, conf, kc, ApprovedAnnotation, ""); err != nil {
+		glog.Errorf("Failed to remove applied approval: %v", err)
+		return
+	}
+	s.updateAdmin(func(admin *AdminStatus) { admin.ApprovedHash = "" })
+}
+
+// adminResponse writes the admin state, or an error, as JSON.
+func (s *State) adminResponse(w http.ResponseWriter, action string, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	var body []byte
+	if err != nil {
+		s.Metrics.AdminActions.WithLabelValues(action, "error").Inc()
+		body, _ = json.Marshal(api.ErrorResponse{Error: err.Error()})
+	} else {
+		s.Metrics.AdminActions.WithLabelValues(action, "success").Inc()
+		body, _ = json.Marshal(s.getAdmin())
+	}
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// handleAdminReconcile forces a reconcile without waiting for the rate limit.
+// Only the leader updates certificates, other replicas refuse it.
+func (s *State) handleAdminReconcile() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if leader := s.getLeader(); !leader.IsLeader {
+			err := fmt.Errorf("only the leader reconciles certificates, the leader is %q", leader.Leader)
+			s.adminResponse(w, "reconcile", http.StatusConflict, err)
+			return
+		}
+		s.TriggerReconcile(ReconcileAdmin)
+		s.adminResponse(w, "reconcile", http.StatusAccepted, nil)
+	}
+}
+
+// handleAdminPause pauses certificate updates until they are resumed.
+func (s *State) handleAdminPause(conf cfg.AppConfig, kc k8s.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req api.PauseRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				s.adminResponse(w, "pause", http.StatusBadRequest, fmt.Errorf("invalid pause request: %v", err))
+				return
+			}
+		}
+		pause := Pause{By: requestPrincipal(r).Name, At: time.Now().UTC(), Reason: req.Reason}
+		value, _ := json.Marshal(pause)
+		conf.Certificate = s.certificateTemplate(conf)
+		if err := annotateAdmin(r.Context(), conf, kc, PausedAnnotation, string(value)); k8serrors.IsNotFound(err) {
+			err = fmt.Errorf("the certificate doesn't exist yet, updates can be paused once it was issued: %v", err)
+			s.adminResponse(w, "pause", http.StatusConflict, err)
+			return
+		} else if err != nil {
+			glog.Errorf("Failed to pause certificate updates: %v", err)
+			s.adminResponse(w, "pause", http.StatusInternalServerError, err)
+			return
+		}
+		glog.Warningf("Certificate updates paused by %s: %s", pause.By, pause.Reason)
+		s.updateAdmin(func(admin *AdminStatus) { admin.Paused = &pause })
+		s.adminResponse(w, "pause", http.StatusOK, nil)
+	}
+}
+
+// handleAdminResume resumes certificate updates and reconciles right away.
+func (s *State) handleAdminResume(conf cfg.AppConfig, kc k8s.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conf.Certificate = s.certificateTemplate(conf)
+		// Without a certificate nothing can be paused
+		if err := annotateAdmin(r.Context(), conf, kc, PausedAnnotation, ""); err != nil && !k8serrors.IsNotFound(err) {
+			glog.Errorf("Failed to resume certificate updates: %v", err)
+			s.adminResponse(w, "resume", http.StatusInternalServerError, err)
+			return
+		}
+		glog.Infof("Certificate updates resumed by %s", requestPrincipal(r).Name)
+		s.updateAdmin(func(admin *AdminStatus) { admin.Paused = nil })
+		s.TriggerReconcile(ReconcileAdmin)
+		s.adminResponse(w, "resume", http.StatusOK, nil)
+	}
+}
+
+// handleAdminApprove approves the blocked certificate change and reconciles right away.
+// The blocked change is persisted by the leader, so it can be approved through every replica.
+func (s *State) handleAdminApprove(conf cfg.AppConfig, kc k8s.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req api.ApproveRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				s.adminResponse(w, "approve", http.StatusBadRequest, fmt.Errorf("invalid approve request: %v", err))
+				return
+			}
+		}
+		conf.Certificate = s.certificateTemplate(conf)
+		if err := s.syncAdmin(r.Context(), conf, kc); err != nil {
+			glog.Errorf("Failed to read the blocked certificate change: %v", err)
+			s.adminResponse(w, "approve", http.StatusInternalServerError, err)
+			return
+		}
+		blocked := s.getAdmin().Blocked
+		if blocked == nil {
+			s.adminResponse(w, "approve", http.StatusConflict, errors.New("no certificate change waits for approval"))
+			return
+		}
+		if req.Hash != "" && req.Hash != blocked.Hash {
+			err := fmt.Errorf("certificate change %s waits for approval, not %s", blocked.Hash, req.Hash)
+			s.adminResponse(w, "approve", http.StatusConflict, err)
+			return
+		}
+
+		if err := annotateAdmin(r.Context(), conf, kc, ApprovedAnnotation, blocked.Hash); err != nil {
+			glog.Errorf("Failed to approve certificate change: %v", err)
+			s.adminResponse(w, "approve", http.StatusInternalServerError, err)
+			return
+		}
+		glog.Warningf("Certificate change %s removing IPs %v approved by %s", blocked.Hash, blocked.RemovedIPs, requestPrincipal(r).Name)
+		s.updateAdmin(func(admin *AdminStatus) { admin.ApprovedHash = blocked.Hash })
+		s.TriggerReconcile(ReconcileAdmin)
+		s.adminResponse(w, "approve", http.StatusAccepted, nil)
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/auth"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/cfg"
+)
+
+// bearerPrincipal returns the principal of the bearer token in the Authorization header.
+func bearerPrincipal(r *http.Request, tokens *auth.TokenSet) (auth.Principal, bool) {
+	authHeader := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(authHeader) != 2 || authHeader[0] != "Bearer" {
+		return auth.Principal{}, false
+	}
+	return tokens.Lookup(authHeader[1])
+}
+
+// certificatePrincipal returns the principal of an authorized client certificate.
+func certificatePrincipal(r *http.Request, p cfg.PeerAuthConfig) (auth.Principal, bool) {
+	name, ok := authorizedPeerCertificate(r, p)
+	if !ok {
+		return auth.Principal{}, false
+	}
+	scopes, ok := p.IdentityScopes[name]
+	if !ok {
+		scopes = auth.DefaultScopes
+	}
+	return auth.Principal{Name: name, Scopes: scopes}, true
+}
+
+// authenticate returns the principal of a request according to the peer authentication mode.
+// When both credentials are required the principal only has scopes granted to both,
+// when either is enough it has scopes granted to any of them.
+func authenticate(r *http.Request, conf cfg.AppConfig) (auth.Principal, bool) {
+	token, bearerOK := bearerPrincipal(r, conf.Tokens)
+	cert, mtlsOK := certificatePrincipal(r, conf.PeerAuthConfig)
+
+	switch conf.PeerAuthConfig.Mode {
+	case cfg.PeerAuthMTLS:
+		return cert, mtlsOK
+	case cfg.PeerAuthBearerAndMTLS:
+		if !bearerOK || !mtlsOK {
+			return auth.Principal{}, false
+		}
+		scopes := slices.DeleteFunc(slices.Clone(token.Scopes), func(scope string) bool { return !cert.Allows(scope) })
+		return auth.Principal{Name: token.Name + "+" + cert.Name, Scopes: scopes}, true
+	case cfg.PeerAuthBearerOrMTLS:
+		switch {
+		case bearerOK && mtlsOK:
+			scopes := slices.Concat(token.Scopes, cert.Scopes)
+			slices.Sort(scopes)
+			return auth.Principal{Name: token.Name + "+" + cert.Name, Scopes: slices.Compact(scopes)}, true
+		case mtlsOK:
+			return cert, true
+		}
+	}
+	return token, bearerOK
+}
+
+// principalContextKey is the request context key of the authenticated principal
+type principalContextKey struct{}
+
+// requestPrincipal returns the principal authenticated by authHandler.
+func requestPrincipal(r *http.Request) auth.Principal {
+	principal, _ := r.Context().Value(principalContextKey{}).(auth.Principal)
+	return principal
+}
+
+// statusRecorder records the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// audit logs an admin action with the principal and the response status.
+func audit(r *http.Request, principal auth.Principal, status int) {
+	glog.Infof("Audit: %s %s by %s from %s: %d %s", r.Method, r.URL.Path, principal.Name, r.RemoteAddr, status, http.StatusText(status))
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/cfg"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/k8s"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/utils"
+)
+
+// CertStatus holds the last observed state of the issued certificate.
+type CertStatus struct {
+	SecretName      string    `json:"secret_name"`
+	Ready           bool      `json:"ready"`
+	NotAfter        time.Time `json:"not_after"`
+	Drift           bool      `json:"drift"`
+	DesiredIPs      []string  `json:"desired_ips"`
+	AppliedIPs      []string  `json:"applied_ips"`
+	MissingIPs      []string  `json:"missing_ips"`
+	ExtraIPs        []string  `json:"extra_ips"`
+	MissingDNSNames []string  `json:"missing_dns_names"`
+	ExtraDNSNames   []string  `json:"extra_dns_names"`
+	LastChecked     time.Time `json:"last_checked"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// checkIssuedCertificate compares SANs of the certificate stored in the Secret with the desired ones
+// and updates metrics and the certificate status accordingly.
+func (s *State) checkIssuedCertificate(ctx context.Context, conf cfg.AppConfig, kc k8s.Client) {
+	status := CertStatus{
+		SecretName:  conf.Certificate.Spec.SecretName,
+		LastChecked: time.Now(),
+	}
+	defer func() { s.setCertStatus(status) }()
+
+	allIPAddresses := s.AllIPs()
+	if len(allIPAddresses) == 0 {
+		// Desired set is unknown until all pd-assistants were polled successfully
+		glog.V(4).Info("No IPs fetched from pd-assistants yet, skipping issued certificate check")
+		status.Error = "desired IP set is not known yet"
+		return
+	}
+
+	// Only cert-manager reports readiness, in other modes a readable certificate means it's ready
+	if conf.IsCertManagerMode() {
+		certificate, err := kc.GetCertificate(ctx, conf)
+		if err != nil {
+			s.Metrics.CertCheckErrors.WithLabelValues().Inc()
+			glog.Errorf("Failed to check certificate Ready condition: %v", err)
+			status.Error = err.Error()
+			return
+		}
+		status.Ready = k8s.IsCertificateReady(certificate)
+	}
+
+	issued, err := kc.GetIssuedCertificate(ctx, conf)
+	if err != nil {
+		s.Metrics.CertCheckErrors.WithLabelValues().Inc()
+		glog.Errorf("Failed to read issued certificate: %v", err)
+		status.Error = err.Error()
+		return
+	}
+	status.NotAfter = issued.NotAfter
+	if !conf.IsCertManagerMode() {
+		status.Ready = time.Now().Before(issued.NotAfter)
+	}
+	if status.Ready {
+		s.Metrics.CertReady.WithLabelValues().Set(1)
+	} else {
+		s.Metrics.CertReady.WithLabelValues().Set(0)
+	}
+	s.Metrics.CertNotAfter.WithLabelValues().Set(float64(issued.NotAfter.Unix()))
+
+	issuedIPs := []string{}
+	for _, ip := range issued.IPAddresses {
+		issuedIPs = append(issuedIPs, ip.String())
+	}
+	desiredIPs := utils.NormalizeIPs(k8s.DesiredIPAddresses(conf, allIPAddresses))
+	status.DesiredIPs = slices.Sorted(slices.Values(desiredIPs))
+	status.AppliedIPs = slices.Sorted(slices.Values(issuedIPs))
+	status.MissingIPs, status.ExtraIPs = utils.DiffLists(desiredIPs, issuedIPs)
+	status.MissingDNSNames, status.ExtraDNSNames = utils.DiffLists(conf.Certificate.Spec.DNSNames, issued.DNSNames)
+	status.Drift = len(status.MissingIPs)+len(status.ExtraIPs)+len(status.MissingDNSNames)+len(status.ExtraDNSNames) > 0
+
+	s.Metrics.CertSANDrift.WithLabelValues("ip").Set(float64(len(status.MissingIPs) + len(status.ExtraIPs)))
+	s.Metrics.CertSANDrift.WithLabelValues("dns").Set(float64(len(status.MissingDNSNames) + len(status.ExtraDNSNames)))
+	if status.Drift {
+		glog.Warningf("Issued certificate in secret %s/%s doesn't match desired SANs: missing IPs %v, extra IPs %v, missing DNS names %v, extra DNS names %v",
+			conf.Certificate.Namespace, status.SecretName, status.MissingIPs, status.ExtraIPs, status.MissingDNSNames, status.ExtraDNSNames)
+	} else {
+		glog.V(4).Infof("Issued certificate in secret %s/%s matches desired SANs", conf.Certificate.Namespace, status.SecretName)
+	}
+}
+
+// CertWatchLoop continuously checks the issued certificate against the desired SANs
+func (s *State) CertWatchLoop(ctx context.Context, conf cfg.AppConfig, kc k8s.Client) {
+	for {
+		s.beat("cert-watch", time.Duration(conf.KubernetesPollInterval)*time.Second)
+		conf.Certificate = s.certificateTemplate(conf)
+		conf.IPOverrides = s.activeIPOverrides(time.Now())
+		s.checkIssuedCertificate(ctx, conf, kc)
+
+		// Sleep for a while before the next iteration
+		if !sleepContext(ctx, time.Duration(conf.KubernetesPollInterval)*time.Second) {
+			glog.V(4).Info("Certificate watch stopped")
+			return
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	"github.com/golang/glog"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/cfg"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/issuer"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/k8s"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/utils"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// loadRequestKey loads the private key used for CertificateRequests from its Secret, or generates
+// and stores a new one. Keeping the key means IP set changes don't rotate it.
+func loadRequestKey(ctx context.Context, conf cfg.AppConfig, kc k8s.Client) (crypto.Signer, []byte, error) {
+	namespace := conf.Certificate.Namespace
+	secretName := conf.Certificate.Spec.SecretName + k8s.PrivateKeySecretSuffix
+
+	secret, err := kc.GetSecret(ctx, namespace, secretName)
+	if err == nil {
+		keyPEM := secret.Data[corev1.TLSPrivateKeyKey]
+		key, err := issuer.ParsePrivateKeyPEM(keyPEM)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse private key from secret %s/%s: %s", namespace, secretName, err.Error())
+		}
+		return key, keyPEM, nil
+	}
+	if !k8serrors.IsNotFound(err) {
+		return nil, nil, fmt.Errorf("failed to get secret %s/%s: %s", namespace, secretName, err.Error())
+	}
+
+	glog.Infof("Private key secret %s/%s not found, generating a new private key", namespace, secretName)
+	key, err := issuer.GeneratePrivateKey(conf.Certificate.Spec.PrivateKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate private key: %v", err)
+	}
+	keyPEM, err := issuer.EncodePrivateKeyPEM(key, conf.Certificate.Spec.PrivateKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode private key: %v", err)
+	}
+	if err := kc.ApplyPrivateKeySecret(ctx, namespace, secretName, keyPEM); err != nil {
+		return nil, nil, err
+	}
+	return key, keyPEM, nil
+}
+
+// errRequestRefused is returned when the last CertificateRequest for the same spec and IPs was refused
+var errRequestRefused = errors.New("certificate request was refused")
+
+// certificateRequestFailure returns why the CertificateRequest was denied, failed or is invalid,
+// or an empty string if it wasn't refused.
+func certificateRequestFailure(request *cmapi.CertificateRequest) string {
+	if denied, message := k8s.HasCertificateRequestCondition(request, cmapi.CertificateRequestConditionDenied); denied {
+		return fmt.Sprintf("certificate request %s was denied: %s", request.Name, message)
+	}
+	if failed, message := k8s.CertificateRequestFailed(request); failed {
+		return fmt.Sprintf("certificate request %s failed: %s", request.Name, message)
+	}
+	if invalid, message := k8s.HasCertificateRequestCondition(request, cmapi.CertificateRequestConditionInvalidRequest); invalid {
+		return fmt.Sprintf("certificate request %s is invalid: %s", request.Name, message)
+	}
+	return ""
+}
+
+// certificateRequestFinished checks if the CertificateRequest was issued or refused.
+func certificateRequestFinished(request *cmapi.CertificateRequest) bool {
+	ready, _ := k8s.HasCertificateRequestCondition(request, cmapi.CertificateRequestConditionReady)
+	return ready || certificateRequestFailure(request) != ""
+}
+
+// certificateRequestHash returns a hash of the certificate spec and IPs a CertificateRequest is created for.
+func certificateRequestHash(spec cmapi.CertificateSpec, desiredIPs []string) (string, error) {
+	spec.IPAddresses = slices.Sorted(slices.Values(desiredIPs))
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// adoptCertificateRequest tracks the pending CertificateRequest found by its label, so a request created
+// before a restart is followed instead of being replaced. It returns the latest request, or nil if there is none.
+func (s *State) adoptCertificateRequest(ctx context.Context, conf cfg.AppConfig, kc k8s.Client) (*cmapi.CertificateRequest, error) {
+	latest, err := kc.LatestCertificateRequest(ctx, conf)
+	if err != nil || latest == nil {
+		return nil, err
+	}
+	issuance := s.getIssuance()
+	if issuance.State == IssuanceInFlight || issuance.Request == latest.Name || certificateRequestFinished(latest) {
+		return latest, nil
+	}
+
+	glog.Infof("Found pending certificate request %s/%s, waiting for it to be approved and issued", latest.Namespace, latest.Name)
+	s.setIssuance(IssuanceStatus{
+		State:     IssuanceInFlight,
+		StartedAt: latest.CreationTimestamp.Time,
+		Request:   latest.Name,
+	})
+	s.Metrics.CertIssuanceInFlight.WithLabelValues().Set(1)
+	return latest, nil
+}
+
+// checkCertificateRequest checks the progress of the in-flight CertificateRequest, if any, and writes
+// the issued chain to the Secret once it's ready.
+// It returns true if no request is in flight anymore and a new one may be created.
+func (s *State) checkCertificateRequest(ctx context.Context, conf cfg.AppConfig, kc k8s.Client, now time.Time) bool {
+	issuance := s.getIssuance()
+	if issuance.State != IssuanceInFlight || issuance.Request == "" {
+		return true
+	}
+	timeout := time.Duration(conf.CertIssuanceTimeout) * time.Second
+	timedOut := now.Sub(issuance.StartedAt) > timeout
+	namespace := conf.Certificate.Namespace
+
+	request, err := kc.GetCertificateRequest(ctx, namespace, issuance.Request)
+	if err != nil {
+		glog.Errorf("Failed to check certificate request: %v", err)
+		if timedOut {
+			ref := corev1.ObjectReference{APIVersion: cmapi.SchemeGroupVersion.String(), Kind: cmapi.CertificateRequestKind, Name: issuance.Request, Namespace: namespace}
+			s.finishIssuance(ctx, kc, ref, issuance, IssuanceTimedOut, fmt.Sprintf("no successful issuance within %s", timeout), now)
+		}
+		return timedOut
+	}
+	ref := k8s.CertificateRequestReference(request)
+
+	if failure := certificateRequestFailure(request); failure != "" {
+		s.finishIssuance(ctx, kc, ref, issuance, IssuanceFailed, failure, now)
+		return true
+	}
+
+	if ready, _ := k8s.HasCertificateRequestCondition(request, cmapi.CertificateRequestConditionReady); ready && len(request.Status.Certificate) > 0 {
+		issued, err := utils.ParseCertificatePEM(request.Status.Certificate)
+		if err != nil {
+			s.finishIssuance(ctx, kc, ref, issuance, IssuanceFailed, fmt.Sprintf("certificate request %s returned an invalid certificate: %v", request.Name, err), now)
+			return true
+		}
+		_, keyPEM, err := loadRequestKey(ctx, conf, kc)
+		if err == nil {
+			err = kc.ApplyTLSSecret(ctx, namespace, conf.Certificate.Spec.SecretName, request.Status.Certificate, keyPEM, request.Status.CA)
+		}
+		if err != nil {
+			// Keep the request in flight and retry writing the Secret on the next iteration
+			glog.Errorf("Failed to store certificate issued by certificate request %s: %v", request.Name, err)
+			return false
+		}
+		s.finishIssuance(ctx, kc, ref, issuance, IssuanceSucceeded, fmt.Sprintf("certificate request %s issued serial %s", request.Name, issued.SerialNumber), now)
+		return true
+	}
+
+	if timedOut {
+		s.finishIssuance(ctx, kc, ref, issuance, IssuanceTimedOut, fmt.Sprintf("certificate request %s was not issued within %s", request.Name, timeout), now)
+		return true
+	}
+
+	if approved, _ := k8s.HasCertificateRequestCondition(request, cmapi.CertificateRequestConditionApproved); !approved {
+		glog.V(4).Infof("Certificate request %s/%s is waiting for approval", namespace, request.Name)
+	} else {
+		glog.V(4).Infof("Certificate request %s/%s is approved and waiting to be issued", namespace, request.Name)
+	}
+	return false
+}
+
+// requestCertificate creates a CertificateRequest signed with the assistant's private key
+// if the certificate in the Secret doesn't match the desired SANs or is due for renewal.
+func (s *State) requestCertificate(ctx context.Context, conf cfg.AppConfig, kc k8s.Client, allIPAddresses []string) error {
+	latest, err := s.adoptCertificateRequest(ctx, conf, kc)
+	if err != nil {
+		return err
+	}
+	// Don't stack further requests while the previous one is still in flight
+	if !s.checkCertificateRequest(ctx, conf, kc, time.Now()) {
+		glog.Warning("Certificate request is still in flight, postponing certificate update")
+		return nil
+	}
+
+	spec := conf.Certificate.Spec
+	namespace := conf.Certificate.Namespace
+	desiredIPs := utils.NormalizeIPs(k8s.DesiredIPAddresses(conf, allIPAddresses))
+
+	reason, _, err := secretRenewalReason(ctx, conf, kc, desiredIPs)
+	if err != nil {
+		return err
+	}
+	if reason == "" {
+		glog.V(4).Infof("Certificate in secret %s/%s is up to date, no update needed", namespace, spec.SecretName)
+		return nil
+	}
+
+	// A refused request is not repeated until the template or IPs change, the same request would be refused again
+	requestHash, err := certificateRequestHash(spec, desiredIPs)
+	if err != nil {
+		return err
+	}
+	if latest != nil && latest.Annotations[k8s.CertificateRequestHashAnnotation] == requestHash {
+		if failure := certificateRequestFailure(latest); failure != "" {
+			return fmt.Errorf("%w, waiting for the certificate template or IPs to change: %s", errRequestRefused, failure)
+		}
+	}
+	glog.Infof("Requesting certificate for secret %s/%s: %s", namespace, spec.SecretName, reason)
+
+	key, _, err := loadRequestKey(ctx, conf, kc)
+	if err != nil {
+		return err
+	}
+	csrPEM, err := issuer.CreateCSR(spec, desiredIPs, key)
+	if err != nil {
+		return err
+	}
+
+	// Clean up requests left from previous issuances
+	if err := kc.DeleteCertificateRequests(ctx, conf); err != nil {
+		glog.Warningf("Failed to clean up old certificate requests: %v", err)
+	}
+	request, err := kc.CreateCertificateRequest(ctx, conf, csrPEM, requestHash)
+	if err != nil {
+		return err
+	}
+
+	glog.Infof("Waiting for certificate request %s/%s to be approved and issued", namespace, request.Name)
+	s.setIssuance(IssuanceStatus{
+		State:     IssuanceInFlight,
+		StartedAt: time.Now(),
+		Request:   request.Name,
+	})
+	s.Metrics.CertIssuanceInFlight.WithLabelValues().Set(1)
+	return nil
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/auth"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/cfg"
+)
+
+//go:embed dashboard.html
+var dashboardHTML string
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(dashboardHTML))
+
+// dashboardCluster is a peer with its local IPs, every pd-assistant serves a single cluster
+type dashboardCluster struct {
+	Name string
+	Peer PeerStatus
+}
+
+// dashboardData is rendered by the dashboard template
+type dashboardData struct {
+	Now          time.Time
+	Status       Status
+	Clusters     []dashboardCluster
+	DaysToExpiry float64
+}
+
+// clusterName returns the host of a peer URL, which names the cluster the peer serves.
+func clusterName(peerURL string) string {
+	if parsed, err := url.Parse(peerURL); err == nil && parsed.Hostname() != "" {
+		return parsed.Hostname()
+	}
+	return peerURL
+}
+
+// handleDashboard renders a human-readable status page
+func (s *State) handleDashboard(config cfg.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		glog.V(10).Info("Got HTTP request for /")
+
+		data := dashboardData{Now: time.Now(), Status: s.status(config)}
+		for _, peer := range data.Status.Peers {
+			data.Clusters = append(data.Clusters, dashboardCluster{Name: clusterName(peer.URL), Peer: peer})
+		}
+		if !data.Status.Certificate.NotAfter.IsZero() {
+			data.DaysToExpiry = time.Until(data.Status.Certificate.NotAfter).Hours() / 24
+		}
+
+		var page bytes.Buffer
+		if err := dashboardTemplate.Execute(&page, data); err != nil {
+			glog.Errorf("Failed to render dashboard: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "Failed to render dashboard")
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(page.Bytes())
+	}
+}
+
+// dashboardAuthHandler accepts principals with the status:read scope, authenticated like API requests
+// according to the peer authentication mode, or the read-only dashboard token. Tokens can be sent
+// as bearer token or as basic auth password, so the dashboard can be opened in a browser
+func dashboardAuthHandler(endpoint http.HandlerFunc, conf cfg.AppConfig) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		authRequest := r
+		if !ok {
+			if _, token, ok = r.BasicAuth(); ok {
+				authRequest = r.Clone(r.Context())
+				authRequest.Header.Set("Authorization", "Bearer "+token)
+			}
+		}
+		principal, valid := authenticate(authRequest, conf)
+		valid = valid && principal.Allows(auth.ScopeStatusRead)
+		validDashboardToken := ok && token != "" && conf.DashboardToken != "" && auth.Equal(token, conf.DashboardToken)
+		if !valid && !validDashboardToken {
+			glog.Warning("Missing or invalid dashboard credentials")
+			w.Header().Set("WWW-Authenticate", `Basic realm="pd-assistant"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintf(w, "Unauthorized")
+			return
+		}
+		endpoint(w, r)
+	})
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	"github.com/golang/glog"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/cfg"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/k8s"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Certificate issuance states
+const (
+	IssuanceIdle      = "idle"
+	IssuanceInFlight  = "issuing"
+	IssuanceSucceeded = "succeeded"
+	IssuanceFailed    = "failed"
+	// IssuanceTimedOut is only a result, the issuance state of a timed out issuance is failed
+	IssuanceTimedOut = "timeout"
+)
+
+// IssuanceStatus holds the state of the last certificate issuance triggered by a spec update.
+type IssuanceStatus struct {
+	State      string    `json:"state"`
+	Generation int64     `json:"generation,omitempty"`
+	StartedAt  time.Time `json:"started_at,omitempty"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+	Message    string    `json:"message,omitempty"`
+	// Request is the name of the CertificateRequest in certificate-request issuer mode
+	Request string `json:"request,omitempty"`
+
+	// previousSerial is the serial number of the certificate in the Secret before the spec update
+	previousSerial string
+}
+
+func (s *State) getIssuance() IssuanceStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.issuance.State == "" {
+		return IssuanceStatus{State: IssuanceIdle}
+	}
+	return s.issuance
+}
+
+func (s *State) setIssuance(issuance IssuanceStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.issuance = issuance
+}
+
+// issuedSerial returns the serial number of the certificate currently stored in the Secret, or an empty string.
+func issuedSerial(ctx context.Context, conf cfg.AppConfig, kc k8s.Client) string {
+	issued, err := kc.GetIssuedCertificate(ctx, conf)
+	if err != nil {
+		glog.V(4).Infof("Unable to read issued certificate serial: %v", err)
+		return ""
+	}
+	return issued.SerialNumber.String()
+}
+
+// startIssuance records that the Certificate spec was changed and cert-manager is expected to issue a new certificate.
+func (s *State) startIssuance(certificate *cmapi.Certificate, previousSerial string, now time.Time) {
+	glog.Infof("Waiting for cert-manager to issue certificate %s/%s generation %d", certificate.Namespace, certificate.Name, certificate.Generation)
+	s.setIssuance(IssuanceStatus{
+		State:          IssuanceInFlight,
+		Generation:     certificate.Generation,
+		StartedAt:      now,
+		previousSerial: previousSerial,
+	})
+	s.Metrics.CertIssuanceInFlight.WithLabelValues().Set(1)
+}
+
+// finishIssuance records the issuance result in the state, metrics and events of the referenced object.
+func (s *State) finishIssuance(ctx context.Context, kc k8s.Client, ref corev1.ObjectReference, issuance IssuanceStatus, result, message string, now time.Time) {
+	issuance.FinishedAt = now
+	issuance.Message = message
+	eventType := corev1.EventTypeNormal
+	reason := "IssuanceSucceeded"
+	if result == IssuanceSucceeded {
+		issuance.State = IssuanceSucceeded
+		glog.Infof("Certificate %s/%s issued successfully: %s", ref.Namespace, ref.Name, message)
+	} else {
+		issuance.State = IssuanceFailed
+		eventType = corev1.EventTypeWarning
+		reason = "IssuanceFailed"
+		if result == IssuanceTimedOut {
+			reason = "IssuanceTimeout"
+		}
+		glog.Errorf("Certificate %s/%s issuance failed: %s", ref.Namespace, ref.Name, message)
+	}
+	s.setIssuance(issuance)
+
+	s.Metrics.CertIssuanceInFlight.WithLabelValues().Set(0)
+	s.Metrics.CertIssuances.WithLabelValues(result).Inc()
+	s.Metrics.CertIssuanceDuration.WithLabelValues().Set(issuance.FinishedAt.Sub(issuance.StartedAt).Seconds())
+
+	if err := kc.RecordEvent(ctx, ref, eventType, reason, message); err != nil {
+		glog.Errorf("Failed to record certificate event: %v", err)
+	}
+}
+
+// checkIssuance checks the progress of the in-flight issuance, if any.
+// It returns true if no issuance is in flight anymore and the Certificate spec may be changed.
+func (s *State) checkIssuance(ctx context.Context, conf cfg.AppConfig, kc k8s.Client, now time.Time) bool {
+	issuance := s.getIssuance()
+	if issuance.State != IssuanceInFlight {
+		return true
+	}
+	timeout := time.Duration(conf.CertIssuanceTimeout) * time.Second
+	timedOut := now.Sub(issuance.StartedAt) > timeout
+
+	certificate, err := kc.GetCertificate(ctx, conf)
+	if err != nil {
+		glog.Errorf("Failed to check certificate issuance: %v", err)
+		if timedOut {
+			s.finishIssuance(ctx, kc, k8s.CertificateReference(&conf.Certificate), issuance, IssuanceTimedOut, fmt.Sprintf("no successful issuance within %s", timeout), now)
+			return true
+		}
+		return false
+	}
+
+	// Ready=True for our generation and a renewed Secret means cert-manager issued the certificate
+	ready := k8s.GetCertificateCondition(certificate, cmapi.CertificateConditionReady)
+	if ready != nil && ready.Status == cmmeta.ConditionTrue && ready.ObservedGeneration >= issuance.Generation {
+		issued, err := kc.GetIssuedCertificate(ctx, conf)
+		if err == nil && issued.SerialNumber.String() != issuance.previousSerial {
+			s.finishIssuance(ctx, kc, k8s.CertificateReference(certificate), issuance, IssuanceSucceeded, fmt.Sprintf("generation %d issued with serial %s", issuance.Generation, issued.SerialNumber), now)
+			return true
+		}
+	}
+
+	// cert-manager sets Issuing=False with reason Failed and the last failure time when issuance fails
+	if certificate.Status.LastFailureTime != nil && certificate.Status.LastFailureTime.Time.After(issuance.StartedAt) {
+		message := "cert-manager reported an issuance failure"
+		if issuing := k8s.GetCertificateCondition(certificate, cmapi.CertificateConditionIssuing); issuing != nil && issuing.Message != "" {
+			message = issuing.Message
+		}
+		s.finishIssuance(ctx, kc, k8s.CertificateReference(certificate), issuance, IssuanceFailed, message, now)
+		return true
+	}
+
+	if timedOut {
+		s.finishIssuance(ctx, kc, k8s.CertificateReference(certificate), issuance, IssuanceTimedOut, fmt.Sprintf("no successful issuance within %s", timeout), now)
+		return true
+	}
+
+	glog.V(4).Infof("Certificate %s/%s generation %d is still being issued", certificate.Namespace, certificate.Name, issuance.Generation)
+	return false
+}
+
+// updateCertManagerCertificate updates the Certificate spec with the new IPs and tracks the resulting issuance.
+func (s *State) updateCertManagerCertificate(ctx context.Context, conf cfg.AppConfig, kc k8s.Client, allIPAddresses []string) error {
+	// Don't stack further spec changes while cert-manager is still issuing the previous one
+	if !s.checkIssuance(ctx, conf, kc, time.Now()) {
+		glog.Warning("Certificate issuance is still in flight, postponing certificate update")
+		return nil
+	}
+
+	previousSerial := issuedSerial(ctx, conf, kc)
+	certificate, updated, err := kc.UpdateCertificate(ctx, conf, allIPAddresses)
+	if err != nil {
+		return err
+	}
+	if updated {
+		s.startIssuance(certificate, previousSerial, time.Now())
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/cfg"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/k8s"
+	"k8s.io/client-go/tools/leaderelection"
+)
+
+// LeaderStatus holds the leader election state of this assistant.
+type LeaderStatus struct {
+	Enabled  bool   `json:"enabled"`
+	Identity string `json:"identity"`
+	IsLeader bool   `json:"is_leader"`
+	Leader   string `json:"leader"`
+}
+
+func (s *State) getLeader() LeaderStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.leader
+}
+
+// IsLeader checks if this assistant is allowed to write certificates.
+func (s *State) IsLeader() bool {
+	return s.getLeader().IsLeader
+}
+
+// leaderContext returns a context cancelled when this assistant loses the leadership, it's already cancelled
+// for followers. Without leader election the leadership never ends.
+func (s *State) leaderContext() context.Context {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	switch {
+	case !s.leader.IsLeader:
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		return ctx
+	case s.leaderCtx != nil:
+		return s.leaderCtx
+	default:
+		return context.Background()
+	}
+}
+
+// startLeading starts a leadership term, ctx is cancelled when the term ends.
+// client-go starts the term in its own goroutine, so it may only run after a short term already ended.
+func (s *State) startLeading(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ctx.Err() != nil {
+		glog.Warning("Leadership term ended before it started, staying a follower")
+		return
+	}
+	s.leaderCtx = ctx
+	s.setLeadingLocked(true)
+}
+
+func (s *State) setLeading(leading bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setLeadingLocked(leading)
+}
+
+// setLeadingLocked updates the leadership, s.mu must be held.
+func (s *State) setLeadingLocked(leading bool) {
+	s.leader.IsLeader = leading
+	if !leading {
+		s.leaderCtx = nil
+	}
+	if leading {
+		s.leader.Leader = s.leader.Identity
+		s.Metrics.Leader.WithLabelValues().Set(1)
+	} else {
+		s.Metrics.Leader.WithLabelValues().Set(0)
+	}
+}
+
+func (s *State) setLeaderIdentity(identity string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.leader.Leader = identity
+}
+
+// RunLeaderElection runs Lease based leader election, only the leader writes certificates.
+// Without leader election enabled this assistant is always the leader.
+// The returned channel is closed once the election stopped and the Lease was released after ctx is cancelled.
+func (s *State) RunLeaderElection(ctx context.Context, conf cfg.AppConfig, kc k8s.Client) <-chan struct{} {
+	done := make(chan struct{})
+	le := conf.LeaderElectionConfig
+	s.mu.Lock()
+	s.leader = LeaderStatus{Enabled: le.Enabled, Identity: le.Identity}
+	s.mu.Unlock()
+
+	if !le.Enabled {
+		s.setLeading(true)
+		close(done)
+		return done
+	}
+	s.setLeading(false)
+
+	namespace := le.LeaseNamespace
+	if namespace == "" {
+		namespace = conf.Certificate.Namespace
+	}
+	lock, err := kc.NewLeaseLock(namespace, le.LeaseName, le.Identity)
+	if err != nil {
+		glog.Fatalf("Failed to create leader election lock: %v", err)
+	}
+
+	go func() {
+		defer close(done)
+		// Keep participating in elections after losing the leadership
+		for ctx.Err() == nil {
+			leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+				Lock:            lock,
+				LeaseDuration:   time.Duration(le.LeaseDuration) * time.Second,
+				RenewDeadline:   time.Duration(le.RenewDeadline) * time.Second,
+				RetryPeriod:     time.Duration(le.RetryPeriod) * time.Second,
+				ReleaseOnCancel: true,
+				Name:            le.LeaseName,
+				Callbacks: leaderelection.LeaderCallbacks{
+					OnStartedLeading: func(ctx context.Context) {
+						glog.Infof("Became the leader %s, certificate updates are enabled", le.Identity)
+						s.startLeading(ctx)
+					},
+					OnStoppedLeading: func() {
+						if ctx.Err() != nil {
+							glog.Infof("Released the leadership on shutdown")
+						} else {
+							glog.Warningf("Lost the leadership, certificate updates are disabled")
+						}
+						s.setLeading(false)
+					},
+					OnNewLeader: func(identity string) {
+						glog.V(4).Infof("Observed new leader: %s", identity)
+						s.setLeaderIdentity(identity)
+					},
+				},
+			})
+		}
+	}()
+	return done
+}
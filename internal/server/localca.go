@@ -0,0 +1,182 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	"github.com/golang/glog"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/cfg"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/issuer"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/k8s"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+// loadLocalCA loads the CA keypair from the configured Secret or files.
+func loadLocalCA(ctx context.Context, conf cfg.AppConfig, kc k8s.Client) (*issuer.CA, error) {
+	caConf := conf.LocalCAConfig
+	if caConf.SecretName != "" {
+		namespace := caConf.SecretNamespace
+		if namespace == "" {
+			namespace = conf.Certificate.Namespace
+		}
+		secret, err := kc.GetSecret(ctx, namespace, caConf.SecretName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get CA secret %s/%s: %s", namespace, caConf.SecretName, err.Error())
+		}
+		return issuer.LoadCA(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	}
+
+	certPEM, err := os.ReadFile(caConf.CertPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate file %s: %s", caConf.CertPath, err.Error())
+	}
+	keyPEM, err := os.ReadFile(caConf.KeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA key file %s: %s", caConf.KeyPath, err.Error())
+	}
+	return issuer.LoadCA(certPEM, keyPEM)
+}
+
+// secretRenewalReason checks if the certificate in the Secret needs to be reissued and returns the reason
+// or an empty string. It also returns the current Secret, or nil if it doesn't exist.
+func secretRenewalReason(ctx context.Context, conf cfg.AppConfig, kc k8s.Client, desiredIPs []string) (string, *corev1.Secret, error) {
+	namespace := conf.Certificate.Namespace
+	secretName := conf.Certificate.Spec.SecretName
+	secret, err := kc.GetSecret(ctx, namespace, secretName)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return "secret doesn't exist", nil, nil
+		}
+		return "", nil, fmt.Errorf("failed to get secret %s/%s: %s", namespace, secretName, err.Error())
+	}
+
+	cert, err := utils.ParseCertificatePEM(secret.Data[corev1.TLSCertKey])
+	if err != nil {
+		return fmt.Sprintf("current certificate is invalid: %v", err), secret, nil
+	}
+	return issuer.NeedsRenewal(cert, conf.Certificate.Spec, desiredIPs, time.Now()), secret, nil
+}
+
+// issueLocalCACertificate signs a new leaf certificate with the local CA and stores it in the Secret
+// if the current certificate doesn't match the desired SANs or is due for renewal.
+func (s *State) issueLocalCACertificate(ctx context.Context, conf cfg.AppConfig, kc k8s.Client, allIPAddresses []string) error {
+	s.localCAMu.Lock()
+	defer s.localCAMu.Unlock()
+	desiredIPs := utils.NormalizeIPs(k8s.DesiredIPAddresses(conf, allIPAddresses))
+
+	reason, secret, err := secretRenewalReason(ctx, conf, kc, desiredIPs)
+	if err != nil {
+		return err
+	}
+	if reason == "" {
+		glog.V(4).Infof("Certificate in secret %s/%s is up to date, no update needed", conf.Certificate.Namespace, conf.Certificate.Spec.SecretName)
+		return nil
+	}
+	return s.signLocalCACertificate(ctx, conf, kc, secret, desiredIPs, reason)
+}
+
+// renewLocalCACertificate re-issues the certificate in the Secret with its current IPs when it's due for renewal.
+// It doesn't need peers, so the certificate doesn't expire while they are unreachable or don't agree.
+func (s *State) renewLocalCACertificate(ctx context.Context, conf cfg.AppConfig, kc k8s.Client, now time.Time) error {
+	s.localCAMu.Lock()
+	defer s.localCAMu.Unlock()
+	namespace, secretName := conf.Certificate.Namespace, conf.Certificate.Spec.SecretName
+
+	secret, err := kc.GetSecret(ctx, namespace, secretName)
+	if errors.IsNotFound(err) {
+		// The first certificate needs the IPs of all peers, it's issued by a reconcile
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get secret %s/%s: %s", namespace, secretName, err.Error())
+	}
+	cert, err := utils.ParseCertificatePEM(secret.Data[corev1.TLSCertKey])
+	if err != nil {
+		return fmt.Errorf("failed to parse certificate from secret %s/%s: %s", namespace, secretName, err.Error())
+	}
+	if renewAt := cert.NotAfter.Add(-issuer.RenewBefore(conf.Certificate.Spec)); !now.After(renewAt) {
+		return nil
+	}
+
+	currentIPs := []string{}
+	for _, ip := range cert.IPAddresses {
+		currentIPs = append(currentIPs, ip.String())
+	}
+	reason := fmt.Sprintf("certificate expires at %s and is due for renewal, keeping its IP addresses", cert.NotAfter.Format(time.RFC3339))
+	return s.signLocalCACertificate(ctx, conf, kc, secret, currentIPs, reason)
+}
+
+// signLocalCACertificate signs a leaf certificate for the IPs with the local CA and stores it in the Secret,
+// the current Secret is nil if it doesn't exist yet.
+func (s *State) signLocalCACertificate(ctx context.Context, conf cfg.AppConfig, kc k8s.Client, secret *corev1.Secret, ips []string, reason string) error {
+	spec := conf.Certificate.Spec
+	namespace := conf.Certificate.Namespace
+	glog.Infof("Issuing certificate for secret %s/%s with local CA: %s", namespace, spec.SecretName, reason)
+
+	ca, err := loadLocalCA(ctx, conf, kc)
+	if err != nil {
+		return err
+	}
+
+	// Keep the current private key only if the Certificate asks to never rotate it
+	var keyPEM []byte
+	if secret != nil && spec.PrivateKey != nil && spec.PrivateKey.RotationPolicy == cmapi.RotationPolicyNever {
+		keyPEM = secret.Data[corev1.TLSPrivateKeyKey]
+	}
+	key, err := issuer.ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		if key, err = issuer.GeneratePrivateKey(spec.PrivateKey); err != nil {
+			return fmt.Errorf("failed to generate private key: %v", err)
+		}
+		if keyPEM, err = issuer.EncodePrivateKeyPEM(key, spec.PrivateKey); err != nil {
+			return fmt.Errorf("failed to encode private key: %v", err)
+		}
+	}
+
+	template, err := issuer.CertificateTemplate(spec, ips)
+	if err != nil {
+		return err
+	}
+	certPEM, err := ca.Sign(template, key.Public())
+	if err != nil {
+		return err
+	}
+
+	if err := kc.ApplyTLSSecret(ctx, namespace, spec.SecretName, certPEM, keyPEM, ca.CertPEM); err != nil {
+		s.Metrics.CertIssuances.WithLabelValues(IssuanceFailed).Inc()
+		return err
+	}
+	s.Metrics.CertIssuances.WithLabelValues(IssuanceSucceeded).Inc()
+	glog.Infof("Certificate for secret %s/%s issued with local CA, serial %s", namespace, spec.SecretName, template.SerialNumber)
+	return nil
+}
+
+// LocalCARenewalLoop continuously renews the local CA certificate before it expires, independently of reconcile runs.
+// Renewals keep the IPs, so they also run while certificate updates are paused or blocked, an expired certificate is worse.
+func (s *State) LocalCARenewalLoop(ctx context.Context, conf cfg.AppConfig, kc k8s.Client) {
+	if conf.IssuerMode != cfg.IssuerModeLocalCA {
+		return
+	}
+	for {
+		s.beat("local-ca-renewal", time.Duration(conf.KubernetesPollInterval)*time.Second)
+		// A started renewal is not cancelled on shutdown like reconcile updates, but stops when the leadership is lost
+		if leaderCtx := s.leaderContext(); leaderCtx.Err() == nil {
+			conf.Certificate = s.certificateTemplate(conf)
+			if err := s.renewLocalCACertificate(leaderCtx, conf, kc, time.Now()); err != nil {
+				s.Metrics.CertUpdateErrors.WithLabelValues().Inc()
+				glog.Errorf("Failed to renew local CA certificate: %v", err)
+			}
+		}
+
+		// Sleep for a while before the next iteration
+		if !sleepContext(ctx, time.Duration(conf.KubernetesPollInterval)*time.Second) {
+			glog.V(4).Info("Local CA renewal stopped")
+			return
+		}
+	}
+}
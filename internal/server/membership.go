@@ -0,0 +1,335 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/api"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/cfg"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/tidb"
+)
+
+// Gossip member states
+const (
+	MemberAlive   = "alive"
+	MemberSuspect = "suspect"
+	MemberDead    = "dead"
+)
+
+// member is a pd-assistant known in gossip membership
+type member struct {
+	// Incarnation is the start time of the member, heartbeats are only compared within an incarnation
+	Incarnation uint64
+	Heartbeat   uint64
+	// LastSeen is the time the heartbeat was last seen increasing
+	LastSeen time.Time
+	// Seed members are never forgotten and always gossiped with, so the membership can recover from a partition
+	Seed bool
+	// Heard is set once a heartbeat was received, seeds are known before
+	Heard bool
+}
+
+// MemberStatus is a pd-assistant known in gossip membership, as shown by the peers endpoint
+type MemberStatus struct {
+	URL         string    `json:"url"`
+	Incarnation uint64    `json:"incarnation,omitempty"`
+	Heartbeat   uint64    `json:"heartbeat"`
+	State       string    `json:"state"`
+	LastSeen    time.Time `json:"last_seen"`
+	Self        bool      `json:"self,omitempty"`
+	Seed        bool      `json:"seed,omitempty"`
+}
+
+// memberState returns the state of a member by the time since its heartbeat last increased.
+func memberState(conf cfg.AppConfig, m member, now time.Time) string {
+	silence := now.Sub(m.LastSeen)
+	switch {
+	case silence >= time.Duration(conf.GossipConfig.DeadTimeout)*time.Second:
+		return MemberDead
+	case silence >= time.Duration(conf.GossipConfig.SuspectTimeout)*time.Second:
+		return MemberSuspect
+	default:
+		return MemberAlive
+	}
+}
+
+// maxHeartbeatJump returns how much the heartbeat of a member can have increased since it was last seen increasing.
+// Members increase it once per interval, gossip can have delayed the previous one by up to the dead timeout.
+func maxHeartbeatJump(conf cfg.AppConfig, since time.Duration) uint64 {
+	g := conf.GossipConfig
+	return uint64((since+time.Duration(g.DeadTimeout)*time.Second)/(time.Duration(g.Interval)*time.Second)) + 1
+}
+
+// addSeeds adds seed URLs not known yet, they count as alive until they miss heartbeats.
+func (s *State) addSeeds(seeds []string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.members == nil {
+		s.members = map[string]member{}
+	}
+	for _, url := range seeds {
+		if s.selfAliases[url] {
+			continue
+		}
+		m, ok := s.members[url]
+		if !ok {
+			m.LastSeen = now
+		}
+		m.Seed = true
+		s.members[url] = m
+	}
+}
+
+// mergeMembers merges members received from a peer, only an increased heartbeat or a new incarnation
+// of a restarted member refreshes it. Heartbeats of this pd-assistant are only ever increased by itself.
+// URLs must be valid like the configured ones and heartbeats can't jump ahead further than members increase them,
+// since they were last seen or since they started, so a bogus heartbeat or incarnation can't freeze a member.
+func (s *State) mergeMembers(conf cfg.AppConfig, remote []api.Member, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.members == nil {
+		s.members = map[string]member{}
+	}
+	for _, rm := range remote {
+		if rm.URL == conf.GossipConfig.AdvertiseURL || s.selfAliases[rm.URL] {
+			continue
+		}
+		if err := cfg.ValidateURL(rm.URL); err != nil {
+			glog.Warningf("Ignoring pd-assistant with invalid URL %q: %v", rm.URL, err)
+			continue
+		}
+		m, ok := s.members[rm.URL]
+		if ok && (rm.Incarnation < m.Incarnation || rm.Incarnation == m.Incarnation && rm.Heartbeat <= m.Heartbeat) {
+			continue
+		}
+		if rm.Incarnation != m.Incarnation && rm.Incarnation != 0 {
+			started := time.UnixMilli(int64(rm.Incarnation))
+			if started.After(now.Add(time.Duration(conf.GossipConfig.DeadTimeout) * time.Second)) {
+				glog.Warningf("Ignoring incarnation %d of pd-assistant %s, it starts in the future", rm.Incarnation, rm.URL)
+				continue
+			}
+			if maxJump := maxHeartbeatJump(conf, now.Sub(started)); rm.Heartbeat > maxJump {
+				glog.Warningf("Ignoring heartbeat %d of pd-assistant %s, it can't have increased beyond %d since it started", rm.Heartbeat, rm.URL, maxJump)
+				continue
+			}
+			if m.Heard {
+				glog.Infof("pd-assistant %s restarted, resetting its heartbeat", rm.URL)
+			}
+		} else if maxJump := maxHeartbeatJump(conf, now.Sub(m.LastSeen)); ok && m.Heard && rm.Heartbeat-m.Heartbeat > maxJump {
+			glog.Warningf("Ignoring heartbeat %d of pd-assistant %s, it can't have increased by more than %d since %d", rm.Heartbeat, rm.URL, maxJump, m.Heartbeat)
+			continue
+		}
+		m.Incarnation = rm.Incarnation
+		m.Heartbeat = rm.Heartbeat
+		m.LastSeen = now
+		m.Heard = true
+		s.members[rm.URL] = m
+	}
+}
+
+// addSelfAlias forgets a seed URL which turned out to reach this pd-assistant, so it isn't listed twice.
+func (s *State) addSelfAlias(url string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.selfAliases == nil {
+		s.selfAliases = map[string]bool{}
+	}
+	s.selfAliases[url] = true
+	delete(s.members, url)
+}
+
+// heartbeat increases the heartbeat of this pd-assistant and forgets long dead members.
+func (s *State) heartbeat(conf cfg.AppConfig, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.members == nil {
+		s.members = map[string]member{}
+	}
+	self := s.members[conf.GossipConfig.AdvertiseURL]
+	if self.Incarnation == 0 {
+		self.Incarnation = uint64(now.UnixMilli())
+	}
+	self.Heartbeat++
+	self.LastSeen = now
+	s.members[conf.GossipConfig.AdvertiseURL] = self
+
+	// Dead members are only forgotten when they may be dropped from the certificate,
+	// they are kept for another dead timeout, so they aren't resurrected by stale gossip
+	if !conf.GossipConfig.DropDeadMembers {
+		return
+	}
+	for url, m := range s.members {
+		if !m.Seed && now.Sub(m.LastSeen) >= 2*time.Duration(conf.GossipConfig.DeadTimeout)*time.Second {
+			glog.Infof("Forgetting dead pd-assistant %s", url)
+			delete(s.members, url)
+		}
+	}
+}
+
+// digest returns all known members with their heartbeats to be sent to peers.
+func (s *State) digest() []api.Member {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	digest := make([]api.Member, 0, len(s.members))
+	for url, m := range s.members {
+		digest = append(digest, api.Member{URL: url, Incarnation: m.Incarnation, Heartbeat: m.Heartbeat})
+	}
+	return digest
+}
+
+// membersStatus returns all known members sorted by URL.
+func (s *State) membersStatus(conf cfg.AppConfig, now time.Time) []MemberStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	members := make([]MemberStatus, 0, len(s.members))
+	for url, m := range s.members {
+		members = append(members, MemberStatus{
+			URL:         url,
+			Incarnation: m.Incarnation,
+			Heartbeat:   m.Heartbeat,
+			State:       memberState(conf, m, now),
+			LastSeen:    m.LastSeen,
+			Self:        url == conf.GossipConfig.AdvertiseURL,
+			Seed:        m.Seed,
+		})
+	}
+	slices.SortFunc(members, func(a, b MemberStatus) int { return strings.Compare(a.URL, b.URL) })
+	return members
+}
+
+// fetchMembers returns URLs of members IPs are fetched from and consensus is checked with.
+// All members are, so a dead member fails reconciles instead of silently dropping its IPs from the certificate,
+// unless dead members are explicitly dropped.
+func (s *State) fetchMembers(conf cfg.AppConfig, now time.Time) []string {
+	urls := []string{}
+	for _, m := range s.membersStatus(conf, now) {
+		if m.State == MemberDead && conf.GossipConfig.DropDeadMembers {
+			glog.Warningf("Leaving dead pd-assistant %s out, its IPs are dropped from the certificate", m.URL)
+			continue
+		}
+		urls = append(urls, m.URL)
+	}
+	return urls
+}
+
+// gossipTargets picks up to fanout random members other than this pd-assistant which are not dead or are seeds.
+func (s *State) gossipTargets(conf cfg.AppConfig, now time.Time) []string {
+	targets := []string{}
+	for _, m := range s.membersStatus(conf, now) {
+		if !m.Self && (m.State != MemberDead || m.Seed) {
+			targets = append(targets, m.URL)
+		}
+	}
+	rand.Shuffle(len(targets), func(i, j int) { targets[i], targets[j] = targets[j], targets[i] })
+	if len(targets) > conf.GossipConfig.Fanout {
+		targets = targets[:conf.GossipConfig.Fanout]
+	}
+	return targets
+}
+
+// gossip runs a single gossip round: exchange membership with random peers and update metrics.
+func (s *State) gossip(ctx context.Context, conf cfg.AppConfig) {
+	seeds := conf.PDAssistantURLs
+	if len(seeds) == 0 {
+		var err error
+		seeds, err = tidb.GetPDAssistantURLs(ctx, conf)
+		if err != nil {
+			glog.Warningf("Failed to fetch seed PD Assistant URLs: %v", err)
+		}
+	}
+	s.addSeeds(seeds, time.Now())
+
+	s.heartbeat(conf, time.Now())
+	digest := s.digest()
+	var wg sync.WaitGroup
+	for _, target := range s.gossipTargets(conf, time.Now()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			remote, advertiseURL, err := api.ExchangeMembers(ctx, conf, target, digest)
+			if err != nil {
+				s.Metrics.GossipErrors.WithLabelValues(target).Inc()
+				glog.V(4).Infof("Failed to gossip with pd-assistant %s: %v", target, err)
+				return
+			}
+			if advertiseURL == conf.GossipConfig.AdvertiseURL {
+				glog.Infof("Seed %s reaches this pd-assistant, forgetting it", target)
+				s.addSelfAlias(target)
+			}
+			s.mergeMembers(conf, remote, time.Now())
+		}()
+	}
+	wg.Wait()
+
+	counts := map[string]int{MemberAlive: 0, MemberSuspect: 0, MemberDead: 0}
+	for _, m := range s.membersStatus(conf, time.Now()) {
+		counts[m.State]++
+	}
+	for state, count := range counts {
+		s.Metrics.Members.WithLabelValues(state).Set(float64(count))
+	}
+}
+
+// GossipLoop continuously exchanges pd-assistant membership with peers
+func (s *State) GossipLoop(ctx context.Context, conf cfg.AppConfig) {
+	if !conf.GossipConfig.Enabled {
+		glog.V(4).Info("Gossip membership is disabled")
+		return
+	}
+	for {
+		s.beat("gossip", time.Duration(conf.GossipConfig.Interval)*time.Second)
+		s.gossip(ctx, conf)
+
+		if !sleepContext(ctx, time.Duration(conf.GossipConfig.Interval)*time.Second) {
+			glog.V(4).Info("Gossip membership stopped")
+			return
+		}
+	}
+}
+
+// handlePeers returns known members on GET and exchanges membership with a peer on POST
+func (s *State) handlePeers(conf cfg.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		glog.V(10).Infof("Got HTTP request for %s", api.ApiPeersPath)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set(api.AdvertiseURLHeader, conf.GossipConfig.AdvertiseURL)
+
+		if !conf.GossipConfig.Enabled {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `{"error": "Gossip membership is disabled"}`)
+			return
+		}
+
+		var response any
+		if r.Method == http.MethodPost {
+			var remote []api.Member
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&remote); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, `{"error": "Failed to decode members"}`)
+				return
+			}
+			s.mergeMembers(conf, remote, time.Now())
+			response = s.digest()
+		} else {
+			response = s.membersStatus(conf, time.Now())
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			glog.Errorf("Failed to marshal members: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, `{"error": "Failed to encode members"}`)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
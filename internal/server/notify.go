@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/api"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/cfg"
+)
+
+// ReconcilePeerNotify is the reconcile trigger reason for notifications from peers
+const ReconcilePeerNotify = "peer-notify"
+
+// maxNotificationSenders caps the number of senders notifications are tracked for
+const maxNotificationSenders = 256
+
+// notification is the last accepted notification from a single authenticated sender
+type notification struct {
+	Hash     string
+	Received time.Time
+	// Deferred is set when a rate limited notification scheduled a reconcile for the end of the interval
+	Deferred bool
+}
+
+// knownContentHash checks if the hash matches local IPs last fetched from any peer,
+// such a change is already part of the IPs this assistant reconciles.
+func (s *State) knownContentHash(hash string) bool {
+	for _, peer := range s.getPeers() {
+		if peer.Error == "" && len(peer.IPs) > 0 && api.ContentHash(peer.IPs) == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptNotification deduplicates and rate limits notifications per sender, the sender is the authenticated
+// principal as the source in the request can be set to anything. Notifications arriving within the minimal interval
+// are deferred to its end instead of being refused, as senders don't retry.
+// It returns the result label and, for deferred notifications, how long the reconcile has to wait.
+// A zero delay for a deferred notification means a reconcile is already scheduled.
+func (s *State) acceptNotification(conf cfg.AppConfig, sender string, req api.NotifyRequest, now time.Time) (string, time.Duration) {
+	if req.Source == conf.NotifyConfig.Source && conf.NotifyConfig.Source != "" {
+		return "self", 0
+	}
+	if s.knownContentHash(req.Hash) {
+		return "known", 0
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.notifications == nil {
+		s.notifications = map[string]notification{}
+	}
+
+	prev, ok := s.notifications[sender]
+	if ok && prev.Hash == req.Hash {
+		return "duplicate", 0
+	}
+	minInterval := time.Duration(conf.NotifyConfig.MinInterval) * time.Second
+	if ok && now.Sub(prev.Received) < minInterval {
+		delay := minInterval - now.Sub(prev.Received)
+		if prev.Deferred {
+			delay = 0
+		}
+		prev.Hash = req.Hash
+		prev.Deferred = true
+		s.notifications[sender] = prev
+		return "deferred", delay
+	}
+	if !ok && len(s.notifications) >= maxNotificationSenders {
+		// Forget the sender heard from least recently
+		oldest := ""
+		for key, n := range s.notifications {
+			if oldest == "" || n.Received.Before(s.notifications[oldest].Received) {
+				oldest = key
+			}
+		}
+		delete(s.notifications, oldest)
+	}
+	s.notifications[sender] = notification{Hash: req.Hash, Received: now}
+	return "accepted", 0
+}
+
+// handleNotify triggers an early reconcile when a peer announces a changed local IP set
+func (s *State) handleNotify(conf cfg.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		glog.V(10).Infof("Got HTTP request for %s", api.ApiNotifyPath)
+		w.Header().Set("Content-Type", "application/json")
+
+		var req api.NotifyRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil || req.Source == "" || req.Hash == "" {
+			s.Metrics.NotificationsReceived.WithLabelValues("invalid").Inc()
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"error": "Notification requires a source and a hash"}`)
+			return
+		}
+
+		// Without authentication there is no principal, the source is all there is
+		sender := requestPrincipal(r).Name
+		if sender == "" {
+			sender = req.Source
+		}
+		result, delay := s.acceptNotification(conf, sender, req, time.Now())
+		s.Metrics.NotificationsReceived.WithLabelValues(result).Inc()
+		switch result {
+		case "self", "known", "duplicate":
+			glog.V(6).Infof("Ignoring %s notification from %s (%s) with hash %s", result, req.Source, sender, req.Hash)
+			w.WriteHeader(http.StatusOK)
+		case "deferred":
+			glog.V(4).Infof("Deferring notification from %s (%s) with hash %s", req.Source, sender, req.Hash)
+			if delay > 0 {
+				time.AfterFunc(delay, func() { s.TriggerReconcile(ReconcilePeerNotify) })
+			}
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			glog.V(4).Infof("Local IPs of %s (%s) changed to hash %s", req.Source, sender, req.Hash)
+			s.TriggerReconcile(ReconcilePeerNotify)
+			w.WriteHeader(http.StatusAccepted)
+		}
+		fmt.Fprintf(w, `{"result": %q}`, result)
+	}
+}
+
+// notifyPeers tells all other pd-assistants that the local IP set has changed.
+// It's run in the background, so slow peers don't hold back the IP watch.
+func (s *State) notifyPeers(ctx context.Context, conf cfg.AppConfig, localIPs []string) {
+	if !conf.NotifyConfig.Enabled {
+		return
+	}
+	pdaAddresses, _, err := s.pdAssistantAddresses(ctx, conf)
+	if err != nil {
+		glog.Errorf("Failed to notify pd-assistants about changed local IPs: %v", err)
+		return
+	}
+
+	req := api.NotifyRequest{Source: conf.NotifyConfig.Source, Hash: api.ContentHash(localIPs)}
+	var wg sync.WaitGroup
+	for _, pdaAddress := range pdaAddresses {
+		// This assistant already triggered its own reconcile
+		if pdaAddress == conf.GossipConfig.AdvertiseURL {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := api.Notify(ctx, conf, pdaAddress, req); err != nil {
+				s.Metrics.NotificationsSent.WithLabelValues(pdaAddress, "error").Inc()
+				glog.Warningf("Failed to notify pd-assistant %s: %v", pdaAddress, err)
+				return
+			}
+			s.Metrics.NotificationsSent.WithLabelValues(pdaAddress, "success").Inc()
+			glog.V(6).Infof("Notified pd-assistant %s about local IPs hash %s", pdaAddress, req.Hash)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/cfg"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/k8s"
+)
+
+// ReconcileIPOverridesChanged is the reason of reconciles triggered by loaded or expired IP overrides
+const ReconcileIPOverridesChanged = "ip-overrides-changed"
+
+// IPOverrideStatus is a loaded IP override, expired ones aren't active.
+type IPOverrideStatus struct {
+	cfg.IPOverride
+	Active bool `json:"active"`
+}
+
+// IPOverridesStatus is the state of manually pinned and excluded IPs.
+type IPOverridesStatus struct {
+	Source   string             `json:"source,omitempty"`
+	LoadedAt time.Time          `json:"loaded_at,omitempty"`
+	Error    string             `json:"error,omitempty"`
+	Pinned   []IPOverrideStatus `json:"pinned,omitempty"`
+	Excluded []IPOverrideStatus `json:"excluded,omitempty"`
+}
+
+// activeIPOverrides returns the loaded IP overrides which didn't expire yet.
+func (s *State) activeIPOverrides(now time.Time) cfg.IPOverrides {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ipOverrides.Active(now)
+}
+
+// getIPOverridesStatus returns the loaded IP overrides with their expiry state.
+func (s *State) getIPOverridesStatus(now time.Time) IPOverridesStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	status := s.ipOverridesStatus
+	status.Pinned, status.Excluded = nil, nil
+	for _, override := range s.ipOverrides.Pinned {
+		status.Pinned = append(status.Pinned, IPOverrideStatus{IPOverride: override, Active: !override.Expired(now)})
+	}
+	for _, override := range s.ipOverrides.Excluded {
+		status.Excluded = append(status.Excluded, IPOverrideStatus{IPOverride: override, Active: !override.Expired(now)})
+	}
+	return status
+}
+
+func (s *State) setIPOverridesMetrics(active cfg.IPOverrides) {
+	s.Metrics.IPOverrides.WithLabelValues("pinned").Set(float64(len(active.Pinned)))
+	s.Metrics.IPOverrides.WithLabelValues("excluded").Set(float64(len(active.Excluded)))
+}
+
+// ipOverridesConfigMap returns the namespace and name of the ConfigMap with the IP overrides file.
+func ipOverridesConfigMap(conf cfg.AppConfig) (string, string) {
+	namespace := conf.IPOverridesConfig.ConfigMapNamespace
+	if namespace == "" {
+		namespace = conf.Certificate.Namespace
+	}
+	return namespace, conf.IPOverridesConfig.ConfigMapName
+}
+
+// readIPOverrides reads the IP overrides file from disk or from its ConfigMap and returns it with a description of its source.
+func readIPOverrides(ctx context.Context, conf cfg.AppConfig, kc k8s.Client) ([]byte, string, error) {
+	if conf.IPOverridesConfig.FilePath != "" {
+		data, err := os.ReadFile(conf.IPOverridesConfig.FilePath)
+		if err != nil {
+			return nil, conf.IPOverridesConfig.FilePath, fmt.Errorf("failed to read IP overrides file: %v", err)
+		}
+		return data, conf.IPOverridesConfig.FilePath, nil
+	}
+
+	namespace, name := ipOverridesConfigMap(conf)
+	source := "ConfigMap " + namespace + "/" + name
+	configMap, err := kc.GetConfigMap(ctx, namespace, name)
+	if err != nil {
+		return nil, source, fmt.Errorf("failed to fetch IP overrides ConfigMap: %v", err)
+	}
+	data, ok := configMap.Data[cfg.IPOverridesKey]
+	if !ok {
+		return nil, source, fmt.Errorf("IP overrides ConfigMap has no %q key", cfg.IPOverridesKey)
+	}
+	return []byte(data), source, nil
+}
+
+// LoadIPOverrides loads pinned and excluded IPs from the IP overrides file or ConfigMap, if configured.
+// The previous overrides are kept when loading fails.
+func (s *State) LoadIPOverrides(ctx context.Context, conf cfg.AppConfig, kc k8s.Client) error {
+	if !conf.IPOverridesConfig.Enabled() {
+		return nil
+	}
+	data, source, err := readIPOverrides(ctx, conf, kc)
+	if err == nil {
+		var overrides cfg.IPOverrides
+		if overrides, err = cfg.ParseIPOverrides(data); err == nil {
+			s.mu.Lock()
+			changed := !reflect.DeepEqual(overrides, s.ipOverrides)
+			s.ipOverrides = overrides
+			s.ipOverridesStatus = IPOverridesStatus{Source: source, LoadedAt: time.Now()}
+			s.mu.Unlock()
+			if changed {
+				glog.Infof("Loaded %d pinned and %d excluded IPs from %s", len(overrides.Pinned), len(overrides.Excluded), source)
+			}
+			s.Metrics.IPOverridesReloads.WithLabelValues("success").Inc()
+			s.setIPOverridesMetrics(s.activeIPOverrides(time.Now()))
+			return nil
+		}
+		err = fmt.Errorf("failed to load IP overrides from %s: %v", source, err)
+	}
+
+	s.Metrics.IPOverridesReloads.WithLabelValues("error").Inc()
+	s.mu.Lock()
+	s.ipOverridesStatus.Source = source
+	s.ipOverridesStatus.Error = err.Error()
+	s.mu.Unlock()
+	return err
+}
+
+// IPOverridesWatchLoop continuously reloads IP overrides and triggers a reconcile when the active ones change,
+// either because they were edited or because they expired
+func (s *State) IPOverridesWatchLoop(ctx context.Context, conf cfg.AppConfig, kc k8s.Client) {
+	if !conf.IPOverridesConfig.Enabled() {
+		return
+	}
+	interval := time.Duration(conf.IPOverridesConfig.ReloadInterval) * time.Second
+	active := s.activeIPOverrides(time.Now())
+	for {
+		s.beat("ip-overrides-watch", interval)
+		if !sleepContext(ctx, interval) {
+			glog.V(4).Info("IP overrides watch stopped")
+			return
+		}
+		if err := s.LoadIPOverrides(ctx, conf, kc); err != nil {
+			glog.Errorf("Keeping the previous IP overrides: %v", err)
+		}
+
+		current := s.activeIPOverrides(time.Now())
+		if !reflect.DeepEqual(current, active) {
+			active = current
+			s.setIPOverridesMetrics(active)
+			s.TriggerReconcile(ReconcileIPOverridesChanged)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/impossiblecloud/pd-cert-assistant/internal/api"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/utils"
+)
+
+// Peer sources
+const (
+	PeerSourceStatic    = "static"
+	PeerSourceDiscovery = "discovery"
+	PeerSourceGossip    = "gossip"
+)
+
+// PeerStatus holds what this pd-assistant last saw fetching IPs from a peer.
+type PeerStatus struct {
+	URL       string    `json:"url"`
+	Source    string    `json:"source"`
+	LastFetch time.Time `json:"last_fetch,omitempty"`
+	LatencyMs int64     `json:"latency_ms"`
+	IPCount   int       `json:"ip_count"`
+	IPs       []string  `json:"ips"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Disagreement is a peer whose all IPs differ from the first peer's in the consensus check.
+type Disagreement struct {
+	Peer       string   `json:"peer"`
+	MissingIPs []string `json:"missing_ips"`
+	ExtraIPs   []string `json:"extra_ips"`
+}
+
+// ConsensusStatus holds the outcome of the last consensus check.
+type ConsensusStatus struct {
+	Enabled       bool           `json:"enabled"`
+	LastChecked   time.Time      `json:"last_checked,omitempty"`
+	Agreed        bool           `json:"agreed"`
+	SamplePeer    string         `json:"sample_peer,omitempty"`
+	Disagreements []Disagreement `json:"disagreements,omitempty"`
+	Error         string         `json:"error,omitempty"`
+}
+
+// ReconcileStatus holds the state of the reconcile queue.
+type ReconcileStatus struct {
+	LastRun    time.Time `json:"last_run,omitempty"`
+	LastReason string    `json:"last_reason,omitempty"`
+	NextRun    time.Time `json:"next_run,omitempty"`
+}
+
+// countFetchError counts a failed fetch, the reason is counted separately to keep the labels of fetch_errors_total.
+func (s *State) countFetchError(url, fetchType, reason string) {
+	s.Metrics.PDAssistantFetchErrors.WithLabelValues(url, fetchType).Inc()
+	s.Metrics.FetchErrorReasons.WithLabelValues(url, fetchType, reason).Inc()
+}
+
+// recordPeerRetry remembers until when a peer which refused to serve IPs asked not to be fetched from again.
+func (s *State) recordPeerRetry(url string, err error, now time.Time) {
+	var unavailable *api.UnavailableError
+	if !errors.As(err, &unavailable) || unavailable.RetryAfter <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.peerRetries == nil {
+		s.peerRetries = map[string]time.Time{}
+	}
+	s.peerRetries[url] = now.Add(unavailable.RetryAfter)
+}
+
+// checkPeerRetry returns an error without contacting the peer if it asked to retry later.
+func (s *State) checkPeerRetry(url string, now time.Time) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if retry, ok := s.peerRetries[url]; ok && now.Before(retry) {
+		return fmt.Errorf("pd-assistant %s is unavailable, not retrying before %s", url, retry.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// setPeers replaces the known peers, keeping the last fetch results of peers which are still known.
+func (s *State) setPeers(urls []string, source string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	peers := make(map[string]PeerStatus, len(urls))
+	for _, url := range urls {
+		peer, ok := s.peers[url]
+		if !ok {
+			peer = PeerStatus{URL: url}
+		}
+		peer.Source = source
+		peers[url] = peer
+	}
+	s.peers = peers
+}
+
+// recordPeerFetch stores the result of fetching local IPs from a peer.
+func (s *State) recordPeerFetch(url string, started time.Time, ips []string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	peer, ok := s.peers[url]
+	if !ok {
+		return
+	}
+	peer.LastFetch = started
+	peer.LatencyMs = time.Since(started).Milliseconds()
+	peer.IPCount = len(ips)
+	peer.IPs = slices.Clone(ips)
+	peer.Error = ""
+	if err != nil {
+		peer.Error = err.Error()
+	}
+	s.peers[url] = peer
+}
+
+// getPeers returns all known peers sorted by URL.
+func (s *State) getPeers() []PeerStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	peers := make([]PeerStatus, 0, len(s.peers))
+	for _, peer := range s.peers {
+		peers = append(peers, peer)
+	}
+	slices.SortFunc(peers, func(a, b PeerStatus) int { return strings.Compare(a.URL, b.URL) })
+	return peers
+}
+
+func (s *State) setConsensus(consensus ConsensusStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.consensus = consensus
+}
+
+func (s *State) getConsensus() ConsensusStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	consensus := s.consensus
+	consensus.Disagreements = slices.Clone(consensus.Disagreements)
+	return consensus
+}
+
+// disagreement compares IPs of a peer with the sample, it returns nil if they are equal.
+func disagreement(peer string, sampleIPs, ips []string) *Disagreement {
+	if utils.IPListsEqual(sampleIPs, ips) {
+		return nil
+	}
+	missing, extra := utils.DiffLists(sampleIPs, ips)
+	return &Disagreement{Peer: peer, MissingIPs: missing, ExtraIPs: extra}
+}
+
+func (s *State) setReconcile(reconcile ReconcileStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reconcile = reconcile
+}
+
+func (s *State) getReconcile() ReconcileStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.reconcile
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/cfg"
+)
+
+// Reasons for not being ready
+const (
+	NotReadyNotSynced      = "not_synced"
+	NotReadyStale          = "stale"
+	NotReadyK8sUnavailable = "k8s_unavailable"
+	NotReadyEmpty          = "empty"
+)
+
+// loopHeartbeat is the last sign of life of a background loop
+type loopHeartbeat struct {
+	Interval time.Duration
+	LastBeat time.Time
+}
+
+// beat records that a background loop is alive, the loop is expected to beat again within the interval.
+func (s *State) beat(loop string, interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.heartbeats == nil {
+		s.heartbeats = map[string]loopHeartbeat{}
+	}
+	s.heartbeats[loop] = loopHeartbeat{Interval: interval, LastBeat: time.Now()}
+}
+
+// stuckLoops returns loops which missed their heartbeats for more than the configured number of intervals.
+func (s *State) stuckLoops(conf cfg.AppConfig, now time.Time) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stuck := []string{}
+	for loop, hb := range s.heartbeats {
+		if now.Sub(hb.LastBeat) > time.Duration(conf.StaleIntervals)*hb.Interval {
+			stuck = append(stuck, loop)
+		}
+	}
+	slices.Sort(stuck)
+	return stuck
+}
+
+// recordLocalIPsSync records the result of listing CiliumNodes for readiness.
+func (s *State) recordLocalIPsSync(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.k8sError = err
+	if err == nil {
+		s.localIPsSynced = time.Now()
+	}
+}
+
+// readiness checks local IPs were listed successfully, recently, aren't empty and the Kubernetes API is reachable.
+// It returns an empty reason when ready.
+func (s *State) readiness(conf cfg.AppConfig, now time.Time) (string, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	switch {
+	case s.localIPsSynced.IsZero():
+		return NotReadyNotSynced, "CiliumNodes were not listed yet"
+	case s.k8sError != nil:
+		return NotReadyK8sUnavailable, fmt.Sprintf("failed to list CiliumNodes: %v", s.k8sError)
+	case now.Sub(s.localIPsSynced) > time.Duration(conf.StaleIntervals*conf.KubernetesPollInterval)*time.Second:
+		return NotReadyStale, fmt.Sprintf("CiliumNodes were last listed at %s", s.localIPsSynced.Format(time.RFC3339))
+	case len(s.ipAddresses) == 0:
+		return NotReadyEmpty, "no CiliumNode IPs found"
+	}
+	return "", ""
+}
+
+// handleHealth is the liveness check, it fails when a background loop is stuck
+func (s *State) handleHealth(conf cfg.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		glog.V(10).Info("Got HTTP request for /health")
+
+		if stuck := s.stuckLoops(conf, time.Now()); len(stuck) > 0 {
+			glog.Warningf("Liveness check failed, stuck loops: %v", stuck)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "Stuck loops: %s", strings.Join(stuck, ", "))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "Health is OK")
+	}
+}
+
+// handleReady is the readiness check, it fails until local IPs can be served to peers
+func (s *State) handleReady(conf cfg.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		glog.V(10).Info("Got HTTP request for /ready")
+
+		if reason, message := s.readiness(conf, time.Now()); reason != "" {
+			glog.V(4).Infof("Readiness check failed: %s", message)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "Not ready (%s): %s", reason, message)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "Ready")
+	}
+}
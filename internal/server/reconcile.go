@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"reflect"
+	"slices"
+	"time"
+
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	"github.com/golang/glog"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/cfg"
+)
+
+// Reconcile trigger reasons
+const (
+	ReconcileStartup         = "startup"
+	ReconcilePeriodic        = "periodic"
+	ReconcileLocalIPsChanged = "local-ips-changed"
+	ReconcileTemplateChanged = "template-reloaded"
+)
+
+// reconcileHistorySize is the number of recent reconcile runs kept for the status and dashboard
+const reconcileHistorySize = 20
+
+// ReconcileRun is a finished reconcile run.
+type ReconcileRun struct {
+	Started    time.Time `json:"started"`
+	Reason     string    `json:"reason"`
+	DurationMs int64     `json:"duration_ms"`
+	Result     string    `json:"result"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// recordReconcile adds a finished run to the reconcile history, dropping the oldest one when full.
+func (s *State) recordReconcile(run ReconcileRun) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.reconcileHistory) >= reconcileHistorySize {
+		s.reconcileHistory = slices.Delete(s.reconcileHistory, 0, len(s.reconcileHistory)-reconcileHistorySize+1)
+	}
+	s.reconcileHistory = append(s.reconcileHistory, run)
+}
+
+// getReconcileHistory returns recent reconcile runs, the latest first.
+func (s *State) getReconcileHistory() []ReconcileRun {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	history := slices.Clone(s.reconcileHistory)
+	slices.Reverse(history)
+	return history
+}
+
+// queue returns the reconcile queue, creating it on first use.
+// The queue holds at most one pending trigger, further triggers are coalesced into it.
+func (s *State) queue() chan string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reconcileQueue == nil {
+		s.reconcileQueue = make(chan string, 1)
+	}
+	return s.reconcileQueue
+}
+
+// TriggerReconcile asks the reconcile loop to run as soon as the rate limit allows.
+func (s *State) TriggerReconcile(reason string) {
+	select {
+	case s.queue() <- reason:
+		glog.V(4).Infof("Reconcile triggered: %s", reason)
+	default:
+		glog.V(6).Infof("Reconcile already pending, coalesced trigger: %s", reason)
+	}
+}
+
+// reconcileDelay returns how long a triggered run has to wait for the minimum interval and jitter.
+func reconcileDelay(conf cfg.AppConfig, lastRun time.Time, reason string, now time.Time) time.Duration {
+	// Operators forcing a reconcile through the admin API don't wait
+	if reason == ReconcileAdmin {
+		return 0
+	}
+	delay := lastRun.Add(time.Duration(conf.ReconcileConfig.MinInterval) * time.Second).Sub(now)
+	if delay < 0 {
+		delay = 0
+	}
+	// The first run isn't delayed, there is nothing to spread out yet
+	if reason != ReconcileStartup && conf.ReconcileConfig.Jitter > 0 {
+		delay += rand.N(time.Duration(conf.ReconcileConfig.Jitter) * time.Second)
+	}
+	return delay
+}
+
+// runReconcileQueue calls reconcile immediately, on every trigger and periodically as a safety net,
+// never more often than the configured minimum interval.
+func (s *State) runReconcileQueue(ctx context.Context, conf cfg.AppConfig, reconcile func(reason string) error) {
+	queue := s.queue()
+	s.TriggerReconcile(ReconcileStartup)
+
+	period := time.Duration(conf.PDAssistantPollInterval) * time.Second
+	periodic := time.NewTimer(period)
+	defer periodic.Stop()
+
+	var lastRun time.Time
+	s.setReconcile(ReconcileStatus{NextRun: time.Now()})
+	// The periodic timer guarantees a run at least every period, plus the rate limit delay
+	beatInterval := period + time.Duration(conf.ReconcileConfig.MinInterval+conf.ReconcileConfig.Jitter)*time.Second
+	for {
+		s.beat("reconcile", beatInterval)
+		var reason string
+		select {
+		case <-ctx.Done():
+			return
+		case reason = <-queue:
+		case <-periodic.C:
+			reason = ReconcilePeriodic
+		}
+
+		if delay := reconcileDelay(conf, lastRun, reason, time.Now()); delay > 0 {
+			glog.V(6).Infof("Delaying reconcile (%s) by %s", reason, delay)
+			if !sleepContext(ctx, delay) {
+				return
+			}
+		}
+		// Triggers received meanwhile are covered by this run
+		select {
+		case <-queue:
+		default:
+		}
+
+		lastRun = time.Now()
+		s.Metrics.Reconciles.WithLabelValues(reason).Inc()
+		glog.V(4).Infof("Running reconcile, reason: %s", reason)
+		run := ReconcileRun{Started: lastRun, Reason: reason, Result: "success"}
+		if err := reconcile(reason); errors.Is(err, errNotLeader) {
+			run.Result = "skipped"
+		} else if errors.Is(err, errPaused) {
+			run.Result = "paused"
+			run.Error = err.Error()
+		} else if errors.Is(err, errApprovalRequired) {
+			run.Result = "blocked"
+			run.Error = err.Error()
+		} else if err != nil {
+			run.Result = "error"
+			run.Error = err.Error()
+		}
+		run.DurationMs = time.Since(lastRun).Milliseconds()
+		s.recordReconcile(run)
+
+		periodic.Reset(period)
+		s.setReconcile(ReconcileStatus{LastRun: lastRun, LastReason: reason, NextRun: time.Now().Add(period)})
+	}
+}
+
+// certificateTemplate returns the last reloaded certificate template, or the one loaded on startup.
+func (s *State) certificateTemplate(conf cfg.AppConfig) cmapi.Certificate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.template == nil {
+		return conf.Certificate
+	}
+	return *s.template.DeepCopy()
+}
+
+// TemplateWatchLoop continuously checks the certificate template file and triggers a reconcile when it changes
+func (s *State) TemplateWatchLoop(ctx context.Context, conf cfg.AppConfig) {
+	if conf.ReconcileConfig.TemplateReloadInterval <= 0 || conf.CertificateFilePath == "" {
+		glog.V(4).Info("Certificate template reload is disabled")
+		return
+	}
+	for {
+		s.beat("template-watch", time.Duration(conf.ReconcileConfig.TemplateReloadInterval)*time.Second)
+		if !sleepContext(ctx, time.Duration(conf.ReconcileConfig.TemplateReloadInterval)*time.Second) {
+			glog.V(4).Info("Certificate template watch stopped")
+			return
+		}
+
+		template, err := cfg.LoadCertificateYaml(conf.CertificateFilePath)
+		if err != nil {
+			s.Metrics.TemplateReloads.WithLabelValues("error").Inc()
+			glog.Errorf("Failed to reload certificate template, keeping the previous one: %v", err)
+			continue
+		}
+		current := s.certificateTemplate(conf)
+		if reflect.DeepEqual(current, template) {
+			continue
+		}
+		if template.Name != current.Name || template.Namespace != current.Namespace {
+			s.Metrics.TemplateReloads.WithLabelValues("error").Inc()
+			glog.Errorf("Certificate template %s/%s was renamed to %s/%s, restart to apply it",
+				current.Namespace, current.Name, template.Namespace, template.Name)
+			continue
+		}
+
+		s.mu.Lock()
+		s.template = &template
+		s.mu.Unlock()
+		s.Metrics.TemplateReloads.WithLabelValues("success").Inc()
+		glog.Infof("Reloaded certificate template %q", conf.CertificateFilePath)
+		s.TriggerReconcile(ReconcileTemplateChanged)
+	}
+}
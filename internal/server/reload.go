@@ -0,0 +1,215 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/cfg"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/k8s"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/tidb"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/utils"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Annotations persisting the PD reload state on the certificate Secret, so a pending reload survives restarts and failovers
+const (
+	ReloadPendingAnnotation = "pd-assistant/reload-pending-hash"
+	ReloadedAnnotation      = "pd-assistant/reloaded-hash"
+)
+
+// ReloadStatus holds the state of the PD reload run after the certificate in the Secret changed.
+type ReloadStatus struct {
+	PendingHash string    `json:"pending_hash,omitempty"`
+	LastHash    string    `json:"last_hash,omitempty"`
+	LastReload  time.Time `json:"last_reload,omitempty"`
+	Message     string    `json:"message,omitempty"`
+}
+
+// secretDataHash returns a hex encoded SHA-256 hash of the Secret data, so any change of the certificate,
+// key or CA is detected, whoever issued it.
+func secretDataHash(secret *corev1.Secret) string {
+	hash := sha256.New()
+	for _, key := range slices.Sorted(maps.Keys(secret.Data)) {
+		fmt.Fprintf(hash, "%s=%x\n", key, secret.Data[key])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (s *State) getReload() ReloadStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.reload
+}
+
+func (s *State) setReload(reload ReloadStatus) {
+	if reload.PendingHash == "" {
+		s.Metrics.PDReloadPending.WithLabelValues().Set(0)
+	} else {
+		s.Metrics.PDReloadPending.WithLabelValues().Set(1)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reload = reload
+}
+
+// checkPDReload compares the Secret data hash with the one PD was last reloaded for, persists a pending
+// reload when it changed and runs the configured post-issuance action if a reload is pending,
+// the minimal interval since the previous reload has passed and the PD cluster is healthy.
+func (s *State) checkPDReload(ctx context.Context, conf cfg.AppConfig, kc k8s.Client, now time.Time) {
+	// Only the leader reloads PD, the pending reload is persisted for the next one.
+	// Writes stop when the leadership is lost, so they don't race the new leader.
+	leaderCtx := s.leaderContext()
+	if leaderCtx.Err() != nil {
+		return
+	}
+	reload := s.getReload()
+	namespace, secretName := conf.Certificate.Namespace, conf.Certificate.Spec.SecretName
+	secret, err := kc.GetSecret(ctx, namespace, secretName)
+	if k8serrors.IsNotFound(err) {
+		glog.V(4).Infof("Certificate secret %s/%s doesn't exist yet, nothing to reload", namespace, secretName)
+		return
+	}
+	if err != nil {
+		glog.Errorf("Failed to get certificate secret %s/%s, postponing PD reload check: %v", namespace, secretName, err)
+		reload.Message = fmt.Sprintf("failed to get certificate secret: %v", err)
+		s.setReload(reload)
+		return
+	}
+
+	hash := secretDataHash(secret)
+	reloaded := secret.Annotations[ReloadedAnnotation]
+	reload.PendingHash = secret.Annotations[ReloadPendingAnnotation]
+	switch {
+	case reloaded == "" && reload.PendingHash == "":
+		// PD already runs with the certificate found on the first check, it only needs reloads for later changes
+		if err := kc.AnnotateSecret(leaderCtx, namespace, secretName, ReloadedAnnotation, hash); err != nil {
+			glog.Errorf("Failed to record the reloaded certificate: %v", err)
+			return
+		}
+		glog.Infof("Recorded certificate %s in secret %s/%s as loaded by PD", hash, namespace, secretName)
+		reload.LastHash = hash
+	case hash == reloaded:
+		// The certificate changed back before PD was reloaded
+		if reload.PendingHash != "" {
+			if err := kc.AnnotateSecret(leaderCtx, namespace, secretName, ReloadPendingAnnotation, ""); err != nil {
+				glog.Errorf("Failed to clear the pending PD reload: %v", err)
+				return
+			}
+		}
+		reload.PendingHash = ""
+		reload.LastHash = hash
+	case hash != reload.PendingHash:
+		if err := kc.AnnotateSecret(leaderCtx, namespace, secretName, ReloadPendingAnnotation, hash); err != nil {
+			glog.Errorf("Failed to persist the pending PD reload: %v", err)
+			return
+		}
+		glog.Infof("Certificate in secret %s/%s changed to %s, PD reload pending", namespace, secretName, hash)
+		reload.PendingHash = hash
+		reload.Message = "waiting for PD reload"
+	}
+	s.setReload(reload)
+	if reload.PendingHash == "" {
+		return
+	}
+
+	minInterval := time.Duration(conf.PostIssuanceConfig.MinInterval) * time.Second
+	if since := now.Sub(reload.LastReload); since < minInterval {
+		glog.V(4).Infof("PD reload is rate limited, next reload possible in %s", minInterval-since)
+		return
+	}
+
+	unhealthy, err := tidb.PDGetUnhealthyMembers(ctx, conf.PDConfig)
+	if err != nil {
+		glog.Errorf("Failed to check PD health, postponing PD reload: %v", err)
+		s.setReloadResult(reload, "error", fmt.Sprintf("PD health check failed: %v", err))
+		return
+	}
+	if len(unhealthy) > 0 {
+		glog.Warningf("PD members %v are unhealthy, postponing PD reload", unhealthy)
+		s.setReloadResult(reload, "unhealthy", fmt.Sprintf("PD members %v are unhealthy", unhealthy))
+		return
+	}
+
+	pc := conf.PostIssuanceConfig
+	switch pc.Action {
+	case cfg.PostIssuanceActionAnnotateStatefulSet:
+		err = kc.AnnotateStatefulSetPodTemplate(leaderCtx, pc.TargetNamespace, pc.TargetName, k8s.CertificateHashAnnotation, reload.PendingHash)
+	case cfg.PostIssuanceActionAnnotateTidbCluster:
+		err = kc.AnnotateTidbClusterPDPods(leaderCtx, pc.TargetNamespace, pc.TargetName, k8s.CertificateHashAnnotation, reload.PendingHash)
+	case cfg.PostIssuanceActionReloadEndpoint:
+		err = callReloadEndpoint(leaderCtx, conf, reload.PendingHash)
+	}
+	if err == nil {
+		// The reloaded hash is written first, a failure in between only repeats the reload
+		if err = kc.AnnotateSecret(leaderCtx, namespace, secretName, ReloadedAnnotation, reload.PendingHash); err == nil {
+			err = kc.AnnotateSecret(leaderCtx, namespace, secretName, ReloadPendingAnnotation, "")
+		}
+	}
+	if err != nil {
+		glog.Errorf("Failed to run PD reload action %q: %v", pc.Action, err)
+		s.setReloadResult(reload, "error", err.Error())
+		return
+	}
+
+	glog.Infof("PD reload action %q done for certificate %s", pc.Action, reload.PendingHash)
+	reload.LastHash = reload.PendingHash
+	reload.PendingHash = ""
+	reload.LastReload = now
+	s.setReloadResult(reload, "success", fmt.Sprintf("%s done", pc.Action))
+}
+
+func (s *State) setReloadResult(reload ReloadStatus, result, message string) {
+	reload.Message = message
+	s.Metrics.PDReloads.WithLabelValues(result).Inc()
+	s.setReload(reload)
+}
+
+// PDReloadLoop continuously checks the certificate Secret and runs the post-issuance action when it changed,
+// independently of reconcile runs, so renewals by cert-manager and peer outages don't hold reloads back
+func (s *State) PDReloadLoop(ctx context.Context, conf cfg.AppConfig, kc k8s.Client) {
+	if conf.PostIssuanceConfig.Action == "" || conf.PostIssuanceConfig.Action == cfg.PostIssuanceActionNone {
+		glog.V(4).Info("PD reload is disabled")
+		return
+	}
+	for {
+		s.beat("pd-reload", time.Duration(conf.KubernetesPollInterval)*time.Second)
+		conf.Certificate = s.certificateTemplate(conf)
+		s.checkPDReload(ctx, conf, kc, time.Now())
+
+		// Sleep for a while before the next iteration
+		if !sleepContext(ctx, time.Duration(conf.KubernetesPollInterval)*time.Second) {
+			glog.V(4).Info("PD reload check stopped")
+			return
+		}
+	}
+}
+
+// callReloadEndpoint asks the configured endpoint to reload PD certificates.
+func callReloadEndpoint(ctx context.Context, conf cfg.AppConfig, hash string) error {
+	body, err := json.Marshal(map[string]string{"certificate_hash": hash})
+	if err != nil {
+		return err
+	}
+	tlsConf := conf.PDConfig.TLSConfig
+	resp, err := utils.MakeHTTPRequestWithMethod(ctx, "POST", conf.PostIssuanceConfig.ReloadURL, bytes.NewReader(body), tlsConf.CertPath, tlsConf.KeyPath, tlsConf.CAPath, tlsConf.Insecure, conf.PDConfig.HTTPRequestTimeout, "")
+	if err != nil {
+		return fmt.Errorf("failed to call reload endpoint %s: %v", conf.PostIssuanceConfig.ReloadURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("received non-OK HTTP status from reload endpoint %s: %s", conf.PostIssuanceConfig.ReloadURL, resp.Status)
+	}
+	return nil
+}
@@ -1,15 +1,21 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"strings"
+	"slices"
+	"strconv"
+	"sync"
 	"time"
 
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
 	"github.com/golang/glog"
 	"github.com/gorilla/mux"
 	"github.com/impossiblecloud/pd-cert-assistant/internal/api"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/auth"
 	"github.com/impossiblecloud/pd-cert-assistant/internal/cfg"
 	"github.com/impossiblecloud/pd-cert-assistant/internal/k8s"
 	"github.com/impossiblecloud/pd-cert-assistant/internal/metrics"
@@ -19,79 +25,182 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-// State holds the state of the application
+// State holds the state of the application.
+// It's shared between the loop goroutines and HTTP handlers, so all fields except Metrics
+// are guarded by the mutex and only accessed through methods handing out copies.
 type State struct {
-	// IPAddresses holds the list of Cilium node IP addresses
-	IPAddresses []string
-	// AllIPAddresses holds the list of all IP addresses from add pd-advisor instances
-	AllIPAddresses []string
 	// Metrics contains the application's metrics.
 	Metrics metrics.AppMetrics
+
+	// mu guards the fields below
+	mu sync.RWMutex
+	// ipAddresses holds the list of Cilium node IP addresses
+	ipAddresses []string
+	// allIPAddresses holds the list of all IP addresses from all pd-assistant instances
+	allIPAddresses []string
+	// certStatus holds the last observed state of the issued certificate
+	certStatus CertStatus
+	// issuance holds the state of the last certificate issuance
+	issuance IssuanceStatus
+	// reload holds the state of the PD reload after certificate issuance
+	reload ReloadStatus
+	// endpoints holds results of the last PD endpoint verification
+	endpoints []EndpointStatus
+	// leader holds the leader election state
+	leader LeaderStatus
+	// leaderCtx is cancelled when the current leadership term ends, nil without a term
+	leaderCtx context.Context
+	// template holds the reloaded certificate template, nil until the template file changed
+	template *cmapi.Certificate
+	// reconcileQueue holds a pending reconcile trigger
+	reconcileQueue chan string
+	// notifications holds the last accepted notification per authenticated sender
+	notifications map[string]notification
+	// members holds pd-assistants known in gossip membership
+	members map[string]member
+	// selfAliases holds seed URLs which turned out to reach this pd-assistant
+	selfAliases map[string]bool
+	// peerRetries holds the time until which pd-assistants asked not to be fetched from again
+	peerRetries map[string]time.Time
+	// peers holds the last fetch results per pd-assistant
+	peers map[string]PeerStatus
+	// consensus holds the outcome of the last consensus check
+	consensus ConsensusStatus
+	// reconcile holds the state of the reconcile queue
+	reconcile ReconcileStatus
+	// reconcileHistory holds recent reconcile runs, the oldest first
+	reconcileHistory []ReconcileRun
+	// heartbeats holds the last heartbeat per background loop
+	heartbeats map[string]loopHeartbeat
+	// localIPsSynced is the time CiliumNodes were last listed successfully
+	localIPsSynced time.Time
+	// k8sError holds the error of the last CiliumNodes list, if it failed
+	k8sError error
+	// admin holds the state changed through the admin API
+	admin AdminStatus
+	// ipOverrides holds the last loaded IP overrides, expired ones included
+	ipOverrides       cfg.IPOverrides
+	ipOverridesStatus IPOverridesStatus
+	// serving holds the certificate served by the web server, it has its own lock
+	serving servingCertificate
+	// localCAMu serializes local CA issuance between reconcile runs and renewals
+	localCAMu sync.Mutex
 }
 
-// Prometheus metrics handler
-func (s *State) handleMetrics(config cfg.AppConfig) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		glog.V(10).Info("Got HTTP request for /metrics")
+// Status is the response of the status endpoint
+type Status struct {
+	Peers       []PeerStatus      `json:"peers"`
+	Members     []MemberStatus    `json:"members,omitempty"`
+	Consensus   ConsensusStatus   `json:"consensus"`
+	Reconcile   ReconcileStatus   `json:"reconcile"`
+	History     []ReconcileRun    `json:"reconcile_history"`
+	Certificate CertStatus        `json:"certificate"`
+	Issuance    IssuanceStatus    `json:"issuance"`
+	Reload      ReloadStatus      `json:"reload"`
+	Endpoints   []EndpointStatus  `json:"endpoints"`
+	Leader      LeaderStatus      `json:"leader"`
+	Admin       AdminStatus       `json:"admin"`
+	IPOverrides IPOverridesStatus `json:"ip_overrides"`
+}
 
-		promhttp.HandlerFor(prometheus.Gatherer(s.Metrics.Registry), promhttp.HandlerOpts{}).ServeHTTP(w, r)
-	}
+// LocalIPs returns a copy of the local Cilium node IP addresses.
+func (s *State) LocalIPs() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.ipAddresses)
 }
 
-// Root handler
-func rootHandler(w http.ResponseWriter, r *http.Request) {
-	glog.V(10).Info("Got HTTP request for /")
+// AllIPs returns a copy of the IP addresses fetched from all pd-assistants.
+func (s *State) AllIPs() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.allIPAddresses)
+}
 
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, "Up and running")
+func (s *State) setLocalIPs(ips []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ipAddresses = slices.Clone(ips)
 }
 
-// Health handler
-func healthHandler(w http.ResponseWriter, r *http.Request) {
-	glog.V(10).Info("Got HTTP request for /health")
+func (s *State) setAllIPs(ips []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.allIPAddresses = slices.Clone(ips)
+}
 
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, "Health is OK")
+func (s *State) setCertStatus(status CertStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.certStatus = status
 }
 
-// Auth decorator for all endpoints that require authentication
-func authHandler(endpoint http.HandlerFunc, cfg cfg.AppConfig) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+func (s *State) getCertStatus() CertStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.certStatus
+}
+
+// Prometheus metrics handler
+func (s *State) handleMetrics(config cfg.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		glog.V(10).Info("Got HTTP request for /metrics")
+
+		promhttp.HandlerFor(prometheus.Gatherer(s.Metrics.Registry), promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	}
+}
 
-		// Extract the Authorization header
-		authHeader := strings.Split(r.Header.Get("Authorization"), " ")
-		if len(authHeader) != 2 || authHeader[0] != "Bearer" {
-			glog.Warning("Missing or invalid Authorization header")
+// Auth decorator for all endpoints that require authentication, the authenticated principal must have the scope
+func authHandler(endpoint http.HandlerFunc, conf cfg.AppConfig, scope string) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := authenticate(r, conf)
+		if !ok {
+			glog.Warningf("Unauthorized request to %s from %s", r.URL.Path, r.RemoteAddr)
 			w.WriteHeader(http.StatusUnauthorized)
 			fmt.Fprintf(w, `{"error": "Unauthorized"}`)
 			return
 		}
+		if !principal.Allows(scope) {
+			glog.Warningf("Forbidden request to %s by %s from %s, missing scope %s", r.URL.Path, principal.Name, r.RemoteAddr, scope)
+			if scope == auth.ScopeAdminWrite {
+				audit(r, principal, http.StatusForbidden)
+			}
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintf(w, `{"error": "Forbidden"}`)
+			return
+		}
 
-		// Validate the token (replace "your-secret-token" with your actual token)
-		if authHeader[1] != cfg.BearerToken {
-			glog.Warning("Invalid bearer token")
-			w.WriteHeader(http.StatusUnauthorized)
-			fmt.Fprintf(w, `{"error": "Unauthorized"}`)
+		// Call the original endpoint handler, admin actions are audited with their result
+		r = r.WithContext(context.WithValue(r.Context(), principalContextKey{}, principal))
+		if scope == auth.ScopeAdminWrite {
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			endpoint(recorder, r)
+			audit(r, principal, recorder.status)
 			return
 		}
-		// Call the original endpoint handler
 		endpoint(w, r)
 	})
 }
 
-func (s *State) getAllIPAddresses(conf cfg.AppConfig, pdaAddresses []string) ([]string, error) {
+func (s *State) getAllIPAddresses(ctx context.Context, conf cfg.AppConfig, pdaAddresses []string) ([]string, error) {
 	allIPAddresses := []string{}
 	// Iterate over pd-assistant addresses and fetch their local IPs
 	for _, pdaAddress := range pdaAddresses {
+		if err := s.checkPeerRetry(pdaAddress, time.Now()); err != nil {
+			return nil, err
+		}
 		glog.V(4).Infof("Fetching local IPs from pd-assistant: %s", pdaAddress)
-		ips, err := api.GetLocalIPs(conf, pdaAddress)
+		started := time.Now()
+		ips, err := api.GetLocalIPs(ctx, conf, pdaAddress)
+		s.recordPeerFetch(pdaAddress, started, ips, err)
 		if err != nil {
-			s.Metrics.PDAssistantFetchErrors.WithLabelValues(pdaAddress, "local").Inc()
-			return nil, fmt.Errorf("failed to fetch IPs from pd-assistant %s: %v", pdaAddress, err)
+			s.countFetchError(pdaAddress, "local", api.FetchErrorReason(err))
+			s.recordPeerRetry(pdaAddress, err, time.Now())
+			return nil, fmt.Errorf("failed to fetch IPs from pd-assistant %s: %w", pdaAddress, err)
 
 		}
 		if len(ips) == 0 {
-			s.Metrics.PDAssistantFetchErrors.WithLabelValues(pdaAddress, "local").Inc()
+			s.countFetchError(pdaAddress, "local", api.FetchErrorEmpty)
 			return nil, fmt.Errorf("no IPs found in pd-assistant %s", pdaAddress)
 		}
 
@@ -102,135 +211,250 @@ func (s *State) getAllIPAddresses(conf cfg.AppConfig, pdaAddresses []string) ([]
 	return allIPAddresses, nil
 }
 
-func (s *State) allIPsConsesusCheck(conf cfg.AppConfig, pdaAddresses []string) (bool, error) {
+func (s *State) allIPsConsesusCheck(ctx context.Context, conf cfg.AppConfig, pdaAddresses []string) (bool, error) {
 	var sampleIPs []string
+	status := ConsensusStatus{Enabled: true, LastChecked: time.Now()}
+	defer func() { s.setConsensus(status) }()
 
 	// Iterate over pd-assistant addresses, fetch all IPs they've found and compare them between each other
 	for id, pdaAddress := range pdaAddresses {
+		if err := s.checkPeerRetry(pdaAddress, time.Now()); err != nil {
+			status.Error = err.Error()
+			return false, err
+		}
 		glog.V(4).Infof("Fetching all IPs from pd-assistant for consensus check: %s", pdaAddress)
-		ips, err := api.GetAllIPs(conf, pdaAddress)
+		ips, err := api.GetAllIPs(ctx, conf, pdaAddress)
 		if err != nil {
-			s.Metrics.PDAssistantFetchErrors.WithLabelValues(pdaAddress, "all").Inc()
-			return false, fmt.Errorf("failed to fetch all IPs from pd-assistant %s: %v", pdaAddress, err)
+			s.countFetchError(pdaAddress, "all", api.FetchErrorReason(err))
+			s.recordPeerRetry(pdaAddress, err, time.Now())
+			status.Error = fmt.Sprintf("failed to fetch all IPs from pd-assistant %s: %v", pdaAddress, err)
+			return false, errors.New(status.Error)
 
 		}
 		if len(ips) == 0 {
-			s.Metrics.PDAssistantFetchErrors.WithLabelValues(pdaAddress, "all").Inc()
-			return false, fmt.Errorf("no all IPs found in pd-assistant %s", pdaAddress)
+			s.countFetchError(pdaAddress, "all", api.FetchErrorEmpty)
+			status.Error = fmt.Sprintf("no all IPs found in pd-assistant %s", pdaAddress)
+			return false, errors.New(status.Error)
 		}
 		if id == 0 {
 			// Snapshot sample IPs which will be used for comparison
 			sampleIPs = ips
-		} else {
-			// Compare the fetched IPs with the sample IPs
-			if !utils.IPListsEqual(sampleIPs, ips) {
-				glog.Errorf("Consensus error: all IPs are not equal between pd-assistants: %s and %s", pdaAddresses[0], pdaAddress)
-				glog.V(8).Infof("Sample all IPs from %s: %+v", pdaAddresses[0], sampleIPs)
-				glog.V(8).Infof("Fetched all IPs from %s: %+v", pdaAddress, ips)
-				return false, nil
-			}
+			status.SamplePeer = pdaAddress
+		} else if d := disagreement(pdaAddress, sampleIPs, ips); d != nil {
+			// Compare the fetched IPs with the sample IPs, all peers are checked to report every disagreement
+			glog.Errorf("Consensus error: all IPs are not equal between pd-assistants: %s and %s", pdaAddresses[0], pdaAddress)
+			glog.V(8).Infof("Sample all IPs from %s: %+v", pdaAddresses[0], sampleIPs)
+			glog.V(8).Infof("Fetched all IPs from %s: %+v", pdaAddress, ips)
+			status.Disagreements = append(status.Disagreements, *d)
 		}
 	}
-	return true, nil
+	status.Agreed = len(status.Disagreements) == 0
+	return status.Agreed, nil
 }
 
 // IPWatchLoop continuously fetches CiliumNode IPs and updates the state
-func (s *State) IPWatchLoop(conf cfg.AppConfig, kc k8s.Client) {
+func (s *State) IPWatchLoop(ctx context.Context, conf cfg.AppConfig, kc k8s.Client) {
 	for {
+		s.beat("ip-watch", time.Duration(conf.KubernetesPollInterval)*time.Second)
 		glog.V(4).Info("Fetching CiliumNode resources from Kubernetes API")
-		ciliumNodeIPs, err := kc.GetCiliumNodes()
+		ciliumNodeIPs, err := kc.GetCiliumNodes(ctx)
+		s.recordLocalIPsSync(err)
 		if err != nil {
 			s.Metrics.K8sPollErrors.WithLabelValues().Inc()
 			glog.Errorf("Failed to fetch CiliumNodes: %v", err)
 		} else {
-			s.IPAddresses = ciliumNodeIPs
+			changed := !utils.IPListsEqual(s.LocalIPs(), ciliumNodeIPs)
+			s.setLocalIPs(ciliumNodeIPs)
 			s.Metrics.LocalIPs.WithLabelValues().Set(float64(len(ciliumNodeIPs)))
 			glog.V(6).Infof("Updated state with local IPs to: %+v", ciliumNodeIPs)
+			if changed {
+				s.TriggerReconcile(ReconcileLocalIPsChanged)
+				go s.notifyPeers(ctx, conf, ciliumNodeIPs)
+			}
 		}
 
 		// Sleep for a while before the next iteration
-		time.Sleep(time.Duration(conf.KubernetesPollInterval) * time.Second)
+		if !sleepContext(ctx, time.Duration(conf.KubernetesPollInterval)*time.Second) {
+			glog.V(4).Info("CiliumNode IP watch stopped")
+			return
+		}
 	}
 }
 
-// AllIPsFetchLoop continuously fetches IPs from all pd-assistant instances and updates the state
-func (s *State) FetchIPsAndUpdateCertLoop(conf cfg.AppConfig, kc k8s.Client) {
-	for {
-		// Sleep before iteration
-		time.Sleep(time.Duration(conf.PDAssistantPollInterval) * time.Second)
-
-		// Do stuff
-		pdaAddresses := conf.PDAssistantURLs
-		if len(conf.PDAssistantURLs) == 0 {
-			// If no pd-assistant addresses are provided, fetch them from the PD Discovery service
-			var err error
-			pdaAddresses, err = tidb.GetPDAssistantURLs(conf)
-			if err != nil {
-				glog.Errorf("Failed to fetch PD Assistant URLs: %s", err.Error())
-				// It's unsafe to continue if we can't fetch IPs, so we log the error and skip this iteration
-				continue
-			}
+// pdAssistantAddresses returns members in gossip membership, the configured pd-assistant URLs,
+// or discovers them from the PD Discovery service, along with the source of the addresses
+func (s *State) pdAssistantAddresses(ctx context.Context, conf cfg.AppConfig) ([]string, string, error) {
+	if conf.GossipConfig.Enabled {
+		members := s.fetchMembers(conf, time.Now())
+		if len(members) == 0 {
+			return nil, PeerSourceGossip, fmt.Errorf("no pd-assistants to fetch IPs from in gossip membership")
 		}
-		allIPAddresses, err := s.getAllIPAddresses(conf, pdaAddresses)
-		if err != nil {
-			glog.Errorf("Failed to fetch IPs from pd-assistants: %v", err)
-			// It's unsafe to continue if we can't fetch IPs, so we log the error and skip this iteration
-			continue
+		return members, PeerSourceGossip, nil
+	}
+	if len(conf.PDAssistantURLs) > 0 {
+		return conf.PDAssistantURLs, PeerSourceStatic, nil
+	}
+	urls, err := tidb.GetPDAssistantURLs(ctx, conf)
+	return urls, PeerSourceDiscovery, err
+}
+
+// errNotLeader is returned by reconcile runs which skipped the certificate update on a follower
+var errNotLeader = errors.New("not the leader, skipping certificate update")
+
+// FetchIPsAndUpdateCertLoop fetches IPs from all pd-assistant instances and updates the certificate
+// on startup, on every reconcile trigger and periodically
+func (s *State) FetchIPsAndUpdateCertLoop(ctx context.Context, conf cfg.AppConfig, kc k8s.Client) {
+	s.runReconcileQueue(ctx, conf, func(reason string) error {
+		err := s.fetchIPsAndUpdateCert(ctx, conf, kc)
+		if errors.Is(err, errNotLeader) {
+			glog.V(4).Info(err.Error())
+		} else if errors.Is(err, errPaused) || errors.Is(err, errApprovalRequired) {
+			glog.Warning(err.Error())
+		} else if err != nil {
+			glog.Error(err.Error())
 		}
+		return err
+	})
+	glog.V(4).Info("pd-assistant IP fetch stopped")
+}
 
-		// Failsafe check for empty IPs, we should never have empty IPs
-		if len(allIPAddresses) == 0 {
-			glog.Errorf("No IPs found in pd-assistants")
-			// It's unsafe to continue if we found no IPs, so we log the error and skip this iteration
-			continue
+// fetchIPsAndUpdateCert runs a single reconcile: fetch all IPs and update the certificate if needed
+func (s *State) fetchIPsAndUpdateCert(ctx context.Context, conf cfg.AppConfig, kc k8s.Client) error {
+	conf.Certificate = s.certificateTemplate(conf)
+	conf.IPOverrides = s.activeIPOverrides(time.Now())
+
+	// It's unsafe to continue if we can't fetch IPs, so the error is returned and this iteration skipped
+	pdaAddresses, source, err := s.pdAssistantAddresses(ctx, conf)
+	if err != nil {
+		return fmt.Errorf("failed to fetch PD Assistant URLs: %v", err)
+	}
+	s.setPeers(pdaAddresses, source)
+	allIPAddresses, err := s.getAllIPAddresses(ctx, conf, pdaAddresses)
+	if err != nil {
+		return fmt.Errorf("failed to fetch IPs from pd-assistants: %v", err)
+	}
+
+	// Failsafe check for empty IPs, we should never have empty IPs
+	if len(allIPAddresses) == 0 {
+		return fmt.Errorf("no IPs found in pd-assistants")
+	}
+
+	// Atomic update of all IP addresses in the state, only if all IPs are fetched successfully
+	s.setAllIPs(allIPAddresses)
+	s.Metrics.AllIPs.WithLabelValues().Set(float64(len(allIPAddresses)))
+	glog.V(6).Infof("All IPs fetched from pd-assistants: %+v", allIPAddresses)
+	// Every replica serves all IPs to peers, but only the leader writes certificates.
+	// Writes use the leadership term, so a slow write stops when the leadership is lost instead of racing the new leader.
+	// A started update is not cancelled on shutdown though, a half-written certificate is worse than a late exit.
+	updateCtx := s.leaderContext()
+	if updateCtx.Err() != nil {
+		return errNotLeader
+	}
+	glog.V(4).Info("Checking for certificate updates")
+
+	// Check IP address consensus
+	if conf.PDAssistantConsensus {
+		if consensus, err := s.allIPsConsesusCheck(ctx, conf, pdaAddresses); err != nil {
+			s.Metrics.ConsensusErrors.WithLabelValues().Inc()
+			return fmt.Errorf("failed to check IP address consensus: %v", err)
+		} else if !consensus {
+			s.Metrics.ConsensusErrors.WithLabelValues().Inc()
+			return fmt.Errorf("IP address consensus check failed, skipping certificate update")
 		}
+		glog.V(4).Info("IP address consensus check passed")
+	}
 
-		// Atomic update of AllIPAddresses in the state, only if all IPs are fetched successfully
-		s.AllIPAddresses = allIPAddresses
-		s.Metrics.AllIPs.WithLabelValues().Set(float64(len(allIPAddresses)))
-		glog.V(6).Infof("All IPs fetched from pd-assistants: %+v", s.AllIPAddresses)
-		glog.V(4).Info("Checking for certificate updates")
-
-		// Check IP address consensus
-		if conf.PDAssistantConsensus {
-			if consensus, err := s.allIPsConsesusCheck(conf, pdaAddresses); err != nil {
-				s.Metrics.ConsensusErrors.WithLabelValues().Inc()
-				glog.Errorf("Failed to check IP address consensus: %v", err)
-				continue
-			} else if !consensus {
-				s.Metrics.ConsensusErrors.WithLabelValues().Inc()
-				glog.Errorf("IP address consensus check failed, skipping certificate update")
-				continue
-			}
-			glog.V(4).Info("IP address consensus check passed")
+	// Admin state is read on every reconcile, it may have been changed through another replica
+	if err := s.syncAdmin(ctx, conf, kc); err != nil {
+		return fmt.Errorf("failed to read admin state: %v", err)
+	}
+	if pause := s.getAdmin().Paused; pause != nil {
+		return fmt.Errorf("%w by %q: %s", errPaused, pause.By, pause.Reason)
+	}
+	if err := s.checkApproval(updateCtx, conf, kc, allIPAddresses); err != nil {
+		return err
+	}
+
+	// Issue or update the certificate with the new IPs if needed
+	switch conf.IssuerMode {
+	case cfg.IssuerModeLocalCA:
+		err = s.issueLocalCACertificate(updateCtx, conf, kc, allIPAddresses)
+	case cfg.IssuerModeCertificateRequest:
+		err = s.requestCertificate(updateCtx, conf, kc, allIPAddresses)
+	default:
+		err = s.updateCertManagerCertificate(updateCtx, conf, kc, allIPAddresses)
+	}
+	if err != nil {
+		s.Metrics.CertUpdateErrors.WithLabelValues().Inc()
+		err = fmt.Errorf("failed to update certificate: %v", err)
+	} else {
+		s.clearApproval(updateCtx, conf, kc)
+	}
+
+	return err
+}
+
+// refuseUntrustedIPs refuses to serve local IPs which aren't synced, are stale or empty with 503,
+// so peers never take them for an empty cluster. It returns true if the request was refused.
+func (s *State) refuseUntrustedIPs(w http.ResponseWriter, config cfg.AppConfig) bool {
+	reason, message := s.readiness(config, time.Now())
+	if reason == "" {
+		return false
+	}
+	glog.V(4).Infof("Refusing to serve local IPs (%s): %s", reason, message)
+	jsonResponse, _ := json.Marshal(api.ErrorResponse{Error: message, Reason: reason})
+	w.Header().Set("Retry-After", strconv.Itoa(config.KubernetesPollInterval))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write(jsonResponse)
+	return true
+}
+
+// handleIPs returns local IP addresses in JSON format.
+func (s *State) handleIPs(config cfg.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		glog.V(10).Infof("Got HTTP request for %s", api.ApiIPsPath)
+		w.Header().Set("Content-Type", "application/json")
+
+		if s.refuseUntrustedIPs(w, config) {
+			return
 		}
 
-		// Update the certificate with the new IPs if needed
-		err = kc.UpdateCertificate(conf, allIPAddresses)
+		// Marshal local IP addresses to JSON
+		jsonResponse, err := json.Marshal(s.LocalIPs())
 		if err != nil {
-			s.Metrics.CertUpdateErrors.WithLabelValues().Inc()
-			glog.Errorf("Failed to update certificate: %v", err)
+			glog.Errorf("Failed to marshal IP addresses: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, `{"error": "Failed to encode IP addresses"}`)
+			return
 		}
+
+		// Respond with the IP addresses
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
 	}
 }
 
-// GetIPs returns local IP addresses in JSON format
-func (s *State) GetIPs(w http.ResponseWriter, r *http.Request) {
-	glog.V(10).Infof("Got HTTP request for %s", api.ApiIPsPath)
+// handleSignedIPs returns local IP addresses with the cluster ID and timestamp, signed with the signing key if configured.
+func (s *State) handleSignedIPs(config cfg.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		glog.V(10).Infof("Got HTTP request for %s", api.ApiSignedIPsPath)
+		w.Header().Set("Content-Type", "application/json")
 
-	// Marshal local IP addresses to JSON
-	jsonResponse, err := json.Marshal(s.IPAddresses)
-	if err != nil {
-		glog.Errorf("Failed to marshal IP addresses: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, `{"error": "Failed to encode IP addresses"}`)
-		return
-	}
+		if s.refuseUntrustedIPs(w, config) {
+			return
+		}
 
-	// Respond with the IP addresses
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(jsonResponse)
+		signed, err := api.SignIPs(config.SigningConfig.Key, config.SigningConfig.ClusterID, s.LocalIPs(), time.Now())
+		if err != nil {
+			glog.Errorf("Failed to sign IP addresses: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, `{"error": "Failed to encode IP addresses"}`)
+			return
+		}
+		jsonResponse, _ := json.Marshal(signed)
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
 }
 
 // GetAllIPs returns all IP addresses in JSON format
@@ -238,7 +462,7 @@ func (s *State) GetAllIPs(w http.ResponseWriter, r *http.Request) {
 	glog.V(10).Infof("Got HTTP request for %s", api.ApiIPsPath)
 
 	// Marshal all IP addresses to JSON
-	jsonResponse, err := json.Marshal(s.AllIPAddresses)
+	jsonResponse, err := json.Marshal(s.AllIPs())
 	if err != nil {
 		glog.Errorf("Failed to marshal all IP addresses: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -247,23 +471,119 @@ func (s *State) GetAllIPs(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Respond with the IP addresses
-	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	w.Write(jsonResponse)
 }
 
-// Main web server
-func (s *State) RunMainWebServer(config cfg.AppConfig, listen string) {
+// status collects the assistant status from the state
+func (s *State) status(config cfg.AppConfig) Status {
+	return Status{
+		Peers:       s.getPeers(),
+		Members:     s.membersStatus(config, time.Now()),
+		Consensus:   s.getConsensus(),
+		Reconcile:   s.getReconcile(),
+		History:     s.getReconcileHistory(),
+		Certificate: s.getCertStatus(),
+		Issuance:    s.getIssuance(),
+		Reload:      s.getReload(),
+		Endpoints:   s.getEndpoints(),
+		Leader:      s.getLeader(),
+		Admin:       s.getAdmin(),
+		IPOverrides: s.getIPOverridesStatus(time.Now()),
+	}
+}
+
+// handleStatus returns the assistant status in JSON format
+func (s *State) handleStatus(config cfg.AppConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		glog.V(10).Infof("Got HTTP request for %s", api.ApiStatusPath)
+
+		jsonResponse, err := json.Marshal(s.status(config))
+		if err != nil {
+			glog.Errorf("Failed to marshal status: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, `{"error": "Failed to encode status"}`)
+			return
+		}
+
+		// Respond with the status
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
+
+// sleepContext sleeps for the given duration, it returns false if the context was cancelled meanwhile
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// RunMainWebServer serves HTTP until the context is cancelled, then drains in-flight requests
+func (s *State) RunMainWebServer(ctx context.Context, config cfg.AppConfig, kc k8s.Client, listen string) error {
 	// Setup http router
 	router := mux.NewRouter().StrictSlash(true)
 
 	// Routes
-	router.HandleFunc("/health", healthHandler).Methods("GET")
+	router.HandleFunc("/health", s.handleHealth(config)).Methods("GET")
+	router.HandleFunc("/ready", s.handleReady(config)).Methods("GET")
 	router.HandleFunc("/metrics", s.handleMetrics(config)).Methods("GET")
-	router.HandleFunc(api.ApiIPsPath, authHandler(s.GetIPs, config)).Methods("GET")
-	router.HandleFunc(api.ApiAllIPsPath, authHandler(s.GetAllIPs, config)).Methods("GET")
-	router.HandleFunc("/", rootHandler).Methods("GET")
+	router.HandleFunc(api.ApiIPsPath, authHandler(s.handleIPs(config), config, auth.ScopePeerRead)).Methods("GET")
+	router.HandleFunc(api.ApiAllIPsPath, authHandler(s.GetAllIPs, config, auth.ScopePeerRead)).Methods("GET")
+	router.HandleFunc(api.ApiSignedIPsPath, authHandler(s.handleSignedIPs(config), config, auth.ScopePeerRead)).Methods("GET")
+	router.HandleFunc(api.ApiStatusPath, authHandler(s.handleStatus(config), config, auth.ScopeStatusRead)).Methods("GET")
+	router.HandleFunc(api.ApiPeersPath, authHandler(s.handlePeers(config), config, auth.ScopePeerRead)).Methods("GET")
+	router.HandleFunc(api.ApiPeersPath, authHandler(s.handlePeers(config), config, auth.ScopePeerWrite)).Methods("POST")
+	router.HandleFunc(api.ApiNotifyPath, authHandler(s.handleNotify(config), config, auth.ScopePeerWrite)).Methods("POST")
+	router.HandleFunc(api.ApiAdminReconcilePath, authHandler(s.handleAdminReconcile(), config, auth.ScopeAdminWrite)).Methods("POST")
+	router.HandleFunc(api.ApiAdminPausePath, authHandler(s.handleAdminPause(config, kc), config, auth.ScopeAdminWrite)).Methods("POST")
+	router.HandleFunc(api.ApiAdminResumePath, authHandler(s.handleAdminResume(config, kc), config, auth.ScopeAdminWrite)).Methods("POST")
+	router.HandleFunc(api.ApiAdminApprovePath, authHandler(s.handleAdminApprove(config, kc), config, auth.ScopeAdminWrite)).Methods("POST")
+	router.HandleFunc("/", dashboardAuthHandler(s.handleDashboard(config), config)).Methods("GET")
 
 	// Run main http router
-	glog.Fatal(http.ListenAndServe(listen, router))
+	server := &http.Server{Addr: listen, Handler: router}
+	if config.TLSServerConfig.Enabled() {
+		tlsConfig, err := s.buildServerTLSConfig(config)
+		if err != nil {
+			return err
+		}
+		if config.TLSServerConfig.CertPath != "" {
+			// Fail early on a broken keypair rather than on the first handshake
+			if err := s.serving.reloadFiles(config.TLSServerConfig.CertPath, config.TLSServerConfig.KeyPath); err != nil {
+				return err
+			}
+		}
+		server.TLSConfig = tlsConfig
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		if server.TLSConfig != nil {
+			glog.Infof("Serving HTTPS on %s", listen)
+			errCh <- server.ListenAndServeTLS("", "")
+			return
+		}
+		errCh <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	glog.Info("Shutting down the web server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(config.ShutdownTimeout)*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shut down the web server: %v", err)
+	}
+	return nil
 }
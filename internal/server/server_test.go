@@ -1,9 +1,44 @@
 package server
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/api"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/auth"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/cfg"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/issuer"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/k8s"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/metrics"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/utils"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
 )
 
 func TestHealthHandler(t *testing.T) {
@@ -16,7 +51,8 @@ func TestHealthHandler(t *testing.T) {
 
 	// Create a ResponseRecorder
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(healthHandler)
+	s := State{Metrics: metrics.InitMetrics("test")}
+	handler := s.handleHealth(cfg.Create())
 
 	// Run the handler
 	handler.ServeHTTP(rr, req)
@@ -34,3 +70,1757 @@ func TestHealthHandler(t *testing.T) {
 			rr.Body.String(), expected)
 	}
 }
+
+func TestEndpointAddress(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected string
+		valid    bool
+	}{
+		{"https://pd-0.pd-peer.tidb.svc:2379", "pd-0.pd-peer.tidb.svc:2379", true},
+		{"https://pd-0.pd-peer.tidb.svc", "pd-0.pd-peer.tidb.svc:2380", true},
+		{"http://10.0.0.1:2380", "10.0.0.1:2380", true},
+		{"pd-0", "", false},
+	}
+
+	for _, test := range tests {
+		address, err := endpointAddress(test.url, pdPeerPort)
+		if test.valid && (err != nil || address != test.expected) {
+			t.Errorf("For URL %q expected %q, got %q (error: %v)", test.url, test.expected, address, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("For URL %q expected an error, got %q", test.url, address)
+		}
+	}
+}
+
+func TestVerifyEndpoint(t *testing.T) {
+	// httptest TLS server certificate contains 127.0.0.1 and ::1 IP SANs
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	conf := cfg.Create()
+	endpoint := pdEndpoint{Address: server.Listener.Addr().String(), Type: "client"}
+
+	status := verifyEndpoint(context.Background(), conf, endpoint, []string{"127.0.0.1"})
+	if status.Error != "" || !status.Covered {
+		t.Errorf("Expected endpoint to be covered without errors, got %+v", status)
+	}
+	if status.DaysToExpiry <= 0 {
+		t.Errorf("Expected positive days to expiry, got %f", status.DaysToExpiry)
+	}
+
+	status = verifyEndpoint(context.Background(), conf, endpoint, []string{"127.0.0.1", "10.0.0.1"})
+	if status.Covered || len(status.MissingIPs) != 1 || status.MissingIPs[0] != "10.0.0.1" {
+		t.Errorf("Expected endpoint to miss 10.0.0.1, got %+v", status)
+	}
+}
+
+func TestRunLeaderElectionDisabled(t *testing.T) {
+	s := State{Metrics: metrics.InitMetrics("test")}
+	conf := cfg.Create()
+	conf.LeaderElectionConfig.Identity = "pd-assistant-0"
+
+	s.RunLeaderElection(context.Background(), conf, k8s.Client{})
+
+	if !s.IsLeader() {
+		t.Errorf("Expected to be the leader without leader election")
+	}
+	leader := s.getLeader()
+	if leader.Enabled || leader.Leader != "pd-assistant-0" {
+		t.Errorf("Unexpected leader status: %+v", leader)
+	}
+}
+
+func TestLeaderContext(t *testing.T) {
+	s := State{Metrics: metrics.InitMetrics("test")}
+	if s.leaderContext().Err() == nil {
+		t.Error("Expected followers to get a cancelled context")
+	}
+
+	term, endTerm := context.WithCancel(context.Background())
+	s.startLeading(term)
+	ctx := s.leaderContext()
+	if !s.IsLeader() || ctx.Err() != nil {
+		t.Fatal("Expected a live context while leading")
+	}
+	endTerm()
+	if ctx.Err() == nil {
+		t.Error("Expected the context to be cancelled when the leadership is lost")
+	}
+	s.setLeading(false)
+	if s.leaderContext().Err() == nil {
+		t.Error("Expected a cancelled context after the leadership was lost")
+	}
+
+	// A term started after it already ended doesn't claim the leadership
+	s.startLeading(term)
+	if s.IsLeader() || s.leaderContext().Err() == nil || testutil.ToFloat64(s.Metrics.Leader.WithLabelValues()) != 0 {
+		t.Error("Expected an ended term to keep this assistant a follower")
+	}
+}
+
+func TestRunMainWebServerShutdown(t *testing.T) {
+	s := State{Metrics: metrics.InitMetrics("test")}
+	conf := cfg.Create()
+	conf.ShutdownTimeout = 5
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.RunMainWebServer(ctx, conf, k8s.Client{}, "127.0.0.1:0")
+	}()
+	cancel()
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Errorf("Expected a clean shutdown, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Web server didn't stop after the context was cancelled")
+	}
+}
+
+// TestStateConcurrentAccess hammers the handlers while the state is updated, run it with -race.
+func TestStateConcurrentAccess(t *testing.T) {
+	s := &State{Metrics: metrics.InitMetrics("test")}
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+
+	// Emulate IPWatchLoop and FetchIPsAndUpdateCertLoop updates
+	writer := func(set func([]string)) {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			set([]string{fmt.Sprintf("10.0.0.%d", i%250), "10.0.1.1", "10.0.0.1"})
+		}
+	}
+	wg.Add(2)
+	go writer(s.setLocalIPs)
+	go writer(s.setAllIPs)
+
+	conf := cfg.Create()
+	conf.KubernetesPollInterval = 60
+	conf.StaleIntervals = 3
+	s.setLocalIPs([]string{"10.0.0.1"})
+	s.recordLocalIPsSync(nil)
+	handlers := []http.HandlerFunc{s.handleIPs(conf), s.GetAllIPs, s.handleStatus(conf)}
+	for i := 0; i < 200; i++ {
+		for _, handler := range handlers {
+			req := httptest.NewRequest("GET", "/", nil)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+			}
+		}
+
+		// Comparisons must not mutate the state
+		ips := s.AllIPs()
+		utils.IPListsEqual(ips, s.LocalIPs())
+	}
+
+	close(stop)
+	wg.Wait()
+}
+
+func TestReconcileDelay(t *testing.T) {
+	conf := cfg.Create()
+	conf.ReconcileConfig.MinInterval = 10
+	now := time.Now()
+
+	if delay := reconcileDelay(conf, time.Time{}, ReconcileStartup, now); delay != 0 {
+		t.Errorf("Expected no delay for the first run, got %s", delay)
+	}
+	if delay := reconcileDelay(conf, now.Add(-4*time.Second), ReconcileLocalIPsChanged, now); delay != 6*time.Second {
+		t.Errorf("Expected the remaining minimum interval as delay, got %s", delay)
+	}
+
+	conf.ReconcileConfig.Jitter = 2
+	delay := reconcileDelay(conf, now.Add(-time.Minute), ReconcileLocalIPsChanged, now)
+	if delay < 0 || delay >= 2*time.Second {
+		t.Errorf("Expected a delay within the jitter, got %s", delay)
+	}
+	if delay := reconcileDelay(conf, now, ReconcileAdmin, now); delay != 0 {
+		t.Errorf("Expected no delay for reconciles forced by admins, got %s", delay)
+	}
+}
+
+func TestRunReconcileQueue(t *testing.T) {
+	s := &State{Metrics: metrics.InitMetrics("test")}
+	conf := cfg.Create()
+	conf.PDAssistantPollInterval = 3600
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runs := make(chan string, 10)
+	done := make(chan struct{})
+	go func() {
+		s.runReconcileQueue(ctx, conf, func(reason string) error { runs <- reason; return nil })
+		close(done)
+	}()
+
+	// The first run doesn't wait for the poll interval
+	select {
+	case reason := <-runs:
+		if reason != ReconcileStartup {
+			t.Errorf("Expected the startup run first, got %q", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected an immediate startup run")
+	}
+
+	s.TriggerReconcile(ReconcileLocalIPsChanged)
+	select {
+	case reason := <-runs:
+		if reason != ReconcileLocalIPsChanged {
+			t.Errorf("Expected a triggered run, got %q", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected a triggered run")
+	}
+
+	cancel()
+	<-done
+}
+
+func TestHandleNotify(t *testing.T) {
+	s := &State{Metrics: metrics.InitMetrics("test")}
+	conf := cfg.Create()
+	conf.NotifyConfig.MinInterval = 60
+	handler := s.handleNotify(conf)
+
+	notify := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", api.ApiNotifyPath, strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	hash := api.ContentHash([]string{"10.0.0.2", "10.0.0.1"})
+	if hash != api.ContentHash([]string{"10.0.0.1", "10.0.0.2"}) {
+		t.Errorf("Expected the content hash not to depend on the IP order")
+	}
+
+	if rr := notify(`{"source": "pd-assistant-0"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %v for a notification without hash, got %v", http.StatusBadRequest, rr.Code)
+	}
+	if rr := notify(`{"source": "pd-assistant-0", "hash": "` + hash + `"}`); rr.Code != http.StatusAccepted {
+		t.Errorf("Expected status %v for a new notification, got %v", http.StatusAccepted, rr.Code)
+	}
+	select {
+	case reason := <-s.queue():
+		if reason != ReconcilePeerNotify {
+			t.Errorf("Expected a peer notify reconcile, got %q", reason)
+		}
+	default:
+		t.Errorf("Expected the notification to trigger a reconcile")
+	}
+
+	if rr := notify(`{"source": "pd-assistant-0", "hash": "` + hash + `"}`); rr.Code != http.StatusOK {
+		t.Errorf("Expected status %v for a duplicate notification, got %v", http.StatusOK, rr.Code)
+	}
+	if rr := notify(`{"source": "pd-assistant-1", "hash": "other"}`); rr.Code != http.StatusAccepted {
+		t.Errorf("Expected notifications from other sources not to be rate limited, got %v", rr.Code)
+	}
+	<-s.queue()
+
+	// Notifications of this assistant itself and of IPs already fetched are ignored
+	conf.NotifyConfig.Source = "pd-assistant-2"
+	if result, _ := s.acceptNotification(conf, "pd-assistant-2", api.NotifyRequest{Source: "pd-assistant-2", Hash: "new"}, time.Now()); result != "self" {
+		t.Errorf("Expected the own notification to be ignored, got %s", result)
+	}
+	s.setPeers([]string{"https://pd-assistant-3"}, "static")
+	s.recordPeerFetch("https://pd-assistant-3", time.Now(), []string{"10.0.0.3"}, nil)
+	if result, _ := s.acceptNotification(conf, "pd-assistant-3", api.NotifyRequest{Source: "pd-assistant-3", Hash: api.ContentHash([]string{"10.0.0.3"})}, time.Now()); result != "known" {
+		t.Errorf("Expected a notification of fetched IPs to be ignored, got %s", result)
+	}
+
+	// Notifications within the minimal interval are deferred to its end, once per sender
+	now := time.Now()
+	if result, delay := s.acceptNotification(conf, "pd-assistant-0", api.NotifyRequest{Source: "pd-assistant-0", Hash: "other"}, now); result != "deferred" || delay <= 0 || delay > time.Minute {
+		t.Errorf("Expected a deferred notification, got %s after %v", result, delay)
+	}
+	if result, delay := s.acceptNotification(conf, "pd-assistant-0", api.NotifyRequest{Source: "pd-assistant-0", Hash: "third"}, now); result != "deferred" || delay != 0 {
+		t.Errorf("Expected the notification to be folded into the scheduled reconcile, got %s after %v", result, delay)
+	}
+	if result, _ := s.acceptNotification(conf, "pd-assistant-0", api.NotifyRequest{Source: "pd-assistant-0", Hash: "fourth"}, now.Add(time.Hour)); result != "accepted" {
+		t.Errorf("Expected a notification after the interval to be accepted, got %s", result)
+	}
+
+	// Senders are keyed by the authenticated principal, not the source they claim
+	if result, _ := s.acceptNotification(conf, "pd-assistant-0", api.NotifyRequest{Source: "spoofed", Hash: "fifth"}, now.Add(time.Hour)); result != "deferred" {
+		t.Errorf("Expected the spoofed source to share the rate limit of its principal, got %s", result)
+	}
+	for i := range maxNotificationSenders + 10 {
+		s.acceptNotification(conf, fmt.Sprintf("sender-%d", i), api.NotifyRequest{Source: "any", Hash: "sixth"}, now.Add(time.Duration(i)*time.Second))
+	}
+	s.mu.RLock()
+	senders := len(s.notifications)
+	s.mu.RUnlock()
+	if senders != maxNotificationSenders {
+		t.Errorf("Expected at most %d tracked senders, got %d", maxNotificationSenders, senders)
+	}
+}
+
+func TestGossipMembership(t *testing.T) {
+	newConf := func(advertiseURL string, seeds ...string) cfg.AppConfig {
+		conf := cfg.Create()
+		conf.PDAssistantURLs = seeds
+		conf.GossipConfig = cfg.GossipConfig{Enabled: true, AdvertiseURL: advertiseURL, Interval: 5, Fanout: 3, SuspectTimeout: 30, DeadTimeout: 120}
+		return conf
+	}
+	memberStates := func(s *State, conf cfg.AppConfig, now time.Time) map[string]string {
+		states := map[string]string{}
+		for _, m := range s.membersStatus(conf, now) {
+			states[m.URL] = m.State
+		}
+		return states
+	}
+
+	b := &State{Metrics: metrics.InitMetrics("test")}
+	var confB cfg.AppConfig
+	serverB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b.handlePeers(confB)(w, r)
+	}))
+	defer serverB.Close()
+	confB = newConf(serverB.URL)
+	b.heartbeat(confB, time.Now())
+
+	// A only knows B as a seed, C is a seed which never answers
+	a := &State{Metrics: metrics.InitMetrics("test")}
+	confA := newConf("http://pd-assistant-a", serverB.URL, "http://127.0.0.1:1")
+	a.gossip(context.Background(), confA)
+
+	if memberStates(b, confB, time.Now())["http://pd-assistant-a"] != MemberAlive {
+		t.Errorf("Expected B to learn about A from gossip")
+	}
+	if states := memberStates(a, confA, time.Now()); states[serverB.URL] != MemberAlive || states["http://pd-assistant-a"] != MemberAlive {
+		t.Errorf("Expected A and B to be alive members of A, got %v", states)
+	}
+
+	// The unanswering seed becomes suspect and then dead, but is still gossiped with and fetched from
+	if state := memberStates(a, confA, time.Now().Add(time.Minute))["http://127.0.0.1:1"]; state != MemberSuspect {
+		t.Errorf("Expected the silent seed to be suspect after a minute, got %q", state)
+	}
+	later := time.Now().Add(3 * time.Minute)
+	if state := memberStates(a, confA, later)["http://127.0.0.1:1"]; state != MemberDead {
+		t.Errorf("Expected the silent seed to be dead after the dead timeout, got %q", state)
+	}
+	if !slices.Contains(a.gossipTargets(confA, later), "http://127.0.0.1:1") {
+		t.Errorf("Expected the dead seed to stay a gossip target")
+	}
+	if !slices.Contains(a.fetchMembers(confA, later), "http://127.0.0.1:1") {
+		t.Errorf("Expected dead members to be fetched from unless they are dropped")
+	}
+	confA.GossipConfig.DropDeadMembers = true
+	if slices.Contains(a.fetchMembers(confA, later), "http://127.0.0.1:1") {
+		t.Errorf("Expected dead members to be dropped when configured")
+	}
+
+	// A stale heartbeat doesn't refresh a member
+	a.mergeMembers(confA, []api.Member{{URL: "http://127.0.0.1:1", Heartbeat: 0}}, later)
+	if state := memberStates(a, confA, later)["http://127.0.0.1:1"]; state != MemberDead {
+		t.Errorf("Expected a stale heartbeat not to resurrect a dead member, got %q", state)
+	}
+
+	// Invalid URLs and heartbeats jumping ahead are ignored
+	a.mergeMembers(confA, []api.Member{{URL: "ftp://pd-assistant-d", Heartbeat: 1}}, later)
+	if _, ok := memberStates(a, confA, later)["ftp://pd-assistant-d"]; ok {
+		t.Errorf("Expected a member with an invalid URL to be ignored")
+	}
+	memberB := func(now time.Time) MemberStatus {
+		for _, m := range a.membersStatus(confA, now) {
+			if m.URL == serverB.URL {
+				return m
+			}
+		}
+		return MemberStatus{}
+	}
+	incarnation := memberB(time.Now()).Incarnation
+	a.mergeMembers(confA, []api.Member{{URL: serverB.URL, Incarnation: incarnation, Heartbeat: math.MaxUint64}}, time.Now())
+	a.mergeMembers(confA, []api.Member{{URL: serverB.URL, Incarnation: incarnation, Heartbeat: 5}}, time.Now().Add(10*time.Second))
+	if m := memberB(time.Now()); m.Heartbeat != 5 {
+		t.Errorf("Expected the heartbeat jump to be ignored, got %d", m.Heartbeat)
+	}
+
+	// A restarted member counts heartbeats from 1 again with a new incarnation, it's alive right away
+	restarted := time.UnixMilli(int64(incarnation)).Add(time.Hour)
+	if state := memberB(restarted).State; state != MemberDead {
+		t.Fatalf("Expected B to be dead before it restarted, got %q", state)
+	}
+	a.mergeMembers(confA, []api.Member{{URL: serverB.URL, Incarnation: uint64(restarted.UnixMilli()), Heartbeat: 1}}, restarted)
+	if m := memberB(restarted); m.State != MemberAlive || m.Heartbeat != 1 {
+		t.Errorf("Expected the restarted member to be alive with its new heartbeat, got %+v", m)
+	}
+	// Gossip about the previous incarnation, bogus incarnations and heartbeats beyond the start time are ignored
+	for _, bogus := range []api.Member{
+		{URL: serverB.URL, Incarnation: incarnation, Heartbeat: 100},
+		{URL: serverB.URL, Incarnation: uint64(restarted.Add(time.Hour).UnixMilli()), Heartbeat: 1},
+		{URL: serverB.URL, Incarnation: uint64(restarted.UnixMilli()) + 1, Heartbeat: 1000},
+	} {
+		a.mergeMembers(confA, []api.Member{bogus}, restarted.Add(time.Second))
+		if m := memberB(restarted); m.Incarnation != uint64(restarted.UnixMilli()) || m.Heartbeat != 1 {
+			t.Errorf("Expected %+v to be ignored, got %+v", bogus, m)
+		}
+	}
+
+	// A seed reaching this pd-assistant itself is only listed once
+	c := &State{Metrics: metrics.InitMetrics("test")}
+	var confC cfg.AppConfig
+	serverC := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.handlePeers(confC)(w, r)
+	}))
+	defer serverC.Close()
+	confC = newConf("http://pd-assistant-c", serverC.URL)
+	c.gossip(context.Background(), confC)
+	c.gossip(context.Background(), confC)
+	if members := c.membersStatus(confC, time.Now()); len(members) != 1 || !members[0].Self {
+		t.Errorf("Expected only the advertise URL to be listed, got %+v", members)
+	}
+}
+
+func TestConsensusStatus(t *testing.T) {
+	peer := func(body string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, body)
+		}))
+	}
+	sample := peer(`["10.0.0.1", "10.0.0.2"]`)
+	defer sample.Close()
+	agreeing := peer(`["10.0.0.2", "10.0.0.1"]`)
+	defer agreeing.Close()
+	disagreeing := peer(`["10.0.0.1", "10.0.0.3"]`)
+	defer disagreeing.Close()
+
+	s := &State{Metrics: metrics.InitMetrics("test")}
+	conf := cfg.Create()
+	s.setPeers([]string{sample.URL, agreeing.URL, disagreeing.URL}, PeerSourceStatic)
+
+	if _, err := s.getAllIPAddresses(context.Background(), conf, []string{sample.URL, agreeing.URL}); err != nil {
+		t.Fatalf("Failed to fetch IPs: %v", err)
+	}
+	for _, p := range s.getPeers() {
+		if p.URL != sample.URL {
+			continue
+		}
+		if p.Source != PeerSourceStatic || p.IPCount != 2 || p.LastFetch.IsZero() || p.Error != "" {
+			t.Errorf("Unexpected peer status: %+v", p)
+		}
+	}
+
+	agreed, err := s.allIPsConsesusCheck(context.Background(), conf, []string{sample.URL, agreeing.URL, disagreeing.URL})
+	if err != nil || agreed {
+		t.Fatalf("Expected consensus to fail without error, got %v, %v", agreed, err)
+	}
+	consensus := s.getConsensus()
+	if consensus.Agreed || consensus.SamplePeer != sample.URL || len(consensus.Disagreements) != 1 {
+		t.Fatalf("Unexpected consensus status: %+v", consensus)
+	}
+	d := consensus.Disagreements[0]
+	if d.Peer != disagreeing.URL || !slices.Equal(d.MissingIPs, []string{"10.0.0.2"}) || !slices.Equal(d.ExtraIPs, []string{"10.0.0.3"}) {
+		t.Errorf("Unexpected disagreement: %+v", d)
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	s := &State{Metrics: metrics.InitMetrics("test")}
+	conf := cfg.Create()
+	conf.Tokens = auth.NewTokenSet("peer-token")
+	conf.DashboardToken = "read-only-token"
+	s.setPeers([]string{"https://pd-assistant.region-a.example:443"}, PeerSourceStatic)
+	s.recordPeerFetch("https://pd-assistant.region-a.example:443", time.Now(), []string{"10.0.0.1"}, nil)
+	for i := 0; i < reconcileHistorySize+5; i++ {
+		s.recordReconcile(ReconcileRun{Started: time.Now(), Reason: ReconcilePeriodic, Result: "success"})
+	}
+	s.recordReconcile(ReconcileRun{Started: time.Now(), Reason: ReconcilePeerNotify, Result: "error", Error: "boom"})
+	handler := dashboardAuthHandler(s.handleDashboard(conf), conf)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized || rr.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("Expected a basic auth challenge without credentials, got %v", rr.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.SetBasicAuth("oncall", "read-only-token")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected the dashboard with the read-only token, got %v", rr.Code)
+	}
+	for _, expected := range []string{"pd-assistant.region-a.example", "10.0.0.1", "boom"} {
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("Expected the dashboard to contain %q", expected)
+		}
+	}
+
+	history := s.getReconcileHistory()
+	if len(history) != reconcileHistorySize || history[0].Reason != ReconcilePeerNotify {
+		t.Errorf("Expected %d runs with the latest first, got %d starting with %+v", reconcileHistorySize, len(history), history[0])
+	}
+}
+
+func TestReadyAndLiveness(t *testing.T) {
+	s := &State{Metrics: metrics.InitMetrics("test")}
+	conf := cfg.Create()
+	conf.KubernetesPollInterval = 60
+	conf.StaleIntervals = 3
+
+	probe := func(handler http.HandlerFunc) int {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+		return rr.Code
+	}
+
+	if reason, _ := s.readiness(conf, time.Now()); reason != NotReadyNotSynced {
+		t.Errorf("Expected not to be ready before the first sync, got %q", reason)
+	}
+	if code := probe(s.handleReady(conf)); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /ready to fail before the first sync, got %v", code)
+	}
+
+	s.recordLocalIPsSync(nil)
+	if reason, _ := s.readiness(conf, time.Now()); reason != NotReadyEmpty {
+		t.Errorf("Expected not to be ready with no CiliumNode IPs, got %q", reason)
+	}
+	s.setLocalIPs([]string{"10.0.0.1"})
+	if code := probe(s.handleReady(conf)); code != http.StatusOK {
+		t.Errorf("Expected /ready to pass after a sync, got %v", code)
+	}
+	if reason, _ := s.readiness(conf, time.Now().Add(4*time.Minute)); reason != NotReadyStale {
+		t.Errorf("Expected stale local IPs after 3 intervals, got %q", reason)
+	}
+	s.recordLocalIPsSync(fmt.Errorf("connection refused"))
+	if reason, _ := s.readiness(conf, time.Now()); reason != NotReadyK8sUnavailable {
+		t.Errorf("Expected the Kubernetes API to be unavailable, got %q", reason)
+	}
+
+	s.beat("ip-watch", time.Minute)
+	if code := probe(s.handleHealth(conf)); code != http.StatusOK {
+		t.Errorf("Expected /health to pass with a fresh heartbeat, got %v", code)
+	}
+	if stuck := s.stuckLoops(conf, time.Now().Add(4*time.Minute)); !slices.Equal(stuck, []string{"ip-watch"}) {
+		t.Errorf("Expected the ip-watch loop to be stuck, got %v", stuck)
+	}
+}
+
+func TestUnavailablePeer(t *testing.T) {
+	// A peer which didn't list CiliumNodes yet refuses to serve its local IPs
+	unsynced := &State{Metrics: metrics.InitMetrics("test")}
+	conf := cfg.Create()
+	conf.KubernetesPollInterval = 60
+	conf.StaleIntervals = 3
+	peer := httptest.NewServer(unsynced.handleIPs(conf))
+	defer peer.Close()
+
+	rr := httptest.NewRecorder()
+	unsynced.handleIPs(conf).ServeHTTP(rr, httptest.NewRequest("GET", api.ApiIPsPath, nil))
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") != "60" {
+		t.Errorf("Expected 503 with Retry-After, got %v", rr.Code)
+	}
+
+	s := &State{Metrics: metrics.InitMetrics("test")}
+	_, err := s.getAllIPAddresses(context.Background(), conf, []string{peer.URL})
+	var unavailable *api.UnavailableError
+	if !errors.As(err, &unavailable) || unavailable.Reason != NotReadyNotSynced || unavailable.RetryAfter != time.Minute {
+		t.Fatalf("Expected an unavailable error for a peer not synced yet, got %v", err)
+	}
+	if count := testutil.ToFloat64(s.Metrics.FetchErrorReasons.WithLabelValues(peer.URL, "local", NotReadyNotSynced)); count != 1 {
+		t.Errorf("Expected a fetch error with the not synced reason, got %v", count)
+	}
+	if count := testutil.ToFloat64(s.Metrics.PDAssistantFetchErrors.WithLabelValues(peer.URL, "local")); count != 1 {
+		t.Errorf("Expected a fetch error without reason, got %v", count)
+	}
+
+	// The peer isn't asked again before Retry-After has passed
+	if _, err := s.getAllIPAddresses(context.Background(), conf, []string{peer.URL}); err == nil || errors.As(err, &unavailable) {
+		t.Errorf("Expected the peer to be skipped until it can be retried, got %v", err)
+	}
+	if count := testutil.ToFloat64(s.Metrics.PDAssistantFetchErrors.WithLabelValues(peer.URL, "local")); count != 1 {
+		t.Errorf("Expected no further fetch, got %v fetch errors", count)
+	}
+	if err := s.checkPeerRetry(peer.URL, time.Now().Add(61*time.Second)); err != nil {
+		t.Errorf("Expected the peer to be retried after a minute, got %v", err)
+	}
+}
+
+// selfSignedKeyPair returns a PEM encoded self-signed certificate and key for 127.0.0.1.
+func selfSignedKeyPair(t *testing.T, commonName string) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestServingCertificateReload(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeKeyPair := func(commonName string) {
+		certPEM, keyPEM := selfSignedKeyPair(t, commonName)
+		if err := os.WriteFile(certPath, certPEM, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	served := func(tlsConfig *tls.Config) string {
+		cert, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatalf("Failed to get serving certificate: %v", err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf.Subject.CommonName
+	}
+
+	s := &State{Metrics: metrics.InitMetrics("test")}
+	conf := cfg.Create()
+	conf.TLSServerConfig = cfg.TLSServerConfig{CertPath: certPath, KeyPath: keyPath, MinVersion: "1.3"}
+	tlsConfig, err := s.buildServerTLSConfig(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.MinVersion != tls.VersionTLS13 {
+		t.Errorf("Expected TLS 1.3 as minimal version")
+	}
+
+	writeKeyPair("first")
+	if name := served(tlsConfig); name != "first" {
+		t.Errorf("Expected the first certificate, got %q", name)
+	}
+
+	// A changed keypair is picked up after the check interval, a broken one keeps the previous certificate
+	writeKeyPair("second")
+	s.serving.lastCheck = time.Time{}
+	if name := served(tlsConfig); name != "second" {
+		t.Errorf("Expected the reloaded certificate, got %q", name)
+	}
+	os.WriteFile(keyPath, []byte("broken"), 0600)
+	s.serving.lastCheck = time.Time{}
+	if name := served(tlsConfig); name != "second" {
+		t.Errorf("Expected to keep the previous certificate, got %q", name)
+	}
+}
+
+func TestPeerMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name string, data ...[]byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, slices.Concat(data...), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	serverCert, serverKey := selfSignedKeyPair(t, "server")
+	euCert, euKey := selfSignedKeyPair(t, "peer-eu")
+	usCert, usKey := selfSignedKeyPair(t, "peer-us")
+	serverCA := writeFile("server-ca.crt", serverCert)
+	clientCA := writeFile("client-ca.crt", euCert, usCert)
+	eu := cfg.TLSConfig{CertPath: writeFile("eu.crt", euCert), KeyPath: writeFile("eu.key", euKey), CAPath: serverCA}
+	us := cfg.TLSConfig{CertPath: writeFile("us.crt", usCert), KeyPath: writeFile("us.key", usKey), CAPath: serverCA}
+	anonymous := cfg.TLSConfig{CAPath: serverCA}
+
+	tests := []struct {
+		mode   string
+		client cfg.TLSConfig
+		token  string
+		ok     bool
+	}{
+		{cfg.PeerAuthMTLS, eu, "", true},
+		{cfg.PeerAuthMTLS, us, "", false},
+		{cfg.PeerAuthMTLS, anonymous, "secret", false},
+		{cfg.PeerAuthBearerAndMTLS, eu, "secret", true},
+		{cfg.PeerAuthBearerAndMTLS, eu, "wrong", false},
+		{cfg.PeerAuthBearerOrMTLS, anonymous, "secret", true},
+		{cfg.PeerAuthBearerOrMTLS, eu, "", true},
+		{cfg.PeerAuthBearerOrMTLS, anonymous, "wrong", false},
+		{cfg.PeerAuthBearer, eu, "", false},
+	}
+
+	for _, test := range tests {
+		s := &State{Metrics: metrics.InitMetrics("test")}
+		conf := cfg.Create()
+		conf.Tokens = auth.NewTokenSet("secret")
+		conf.TLSServerConfig = cfg.TLSServerConfig{CertPath: writeFile("tls.crt", serverCert), KeyPath: writeFile("tls.key", serverKey), MinVersion: "1.2"}
+		conf.PeerAuthConfig = cfg.PeerAuthConfig{Mode: test.mode, ClientCAPath: clientCA, AllowedCNs: []string{"peer-eu"}}
+		if test.mode == cfg.PeerAuthBearer {
+			conf.PeerAuthConfig.ClientCAPath = ""
+		}
+		tlsConfig, err := s.buildServerTLSConfig(conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := httptest.NewUnstartedServer(authHandler(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `["10.0.0.1"]`)
+		}, conf, auth.ScopePeerRead))
+		// StartTLS would serve its own certificate, TLS is terminated by the listener instead
+		srv.Listener = tls.NewListener(srv.Listener, tlsConfig)
+		srv.Start()
+
+		clientConf := cfg.Create()
+		clientConf.Tokens = auth.NewTokenSet(test.token)
+		clientConf.PeerAuthConfig.TLSConfig = test.client
+		ips, err := api.GetLocalIPs(context.Background(), clientConf, "https://"+srv.Listener.Addr().String())
+		if test.ok && (err != nil || len(ips) != 1) {
+			t.Errorf("Expected %s with client %s and token %q to be authorized, got %v", test.mode, test.client.CertPath, test.token, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("Expected %s with client %s and token %q to be refused", test.mode, test.client.CertPath, test.token)
+		}
+		srv.Close()
+	}
+}
+
+func TestTokenRotation(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "tokens.yaml")
+	writeTokens := func(data string) {
+		if err := os.WriteFile(tokenFile, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &State{Metrics: metrics.InitMetrics("test")}
+	conf := cfg.Create()
+	conf.TokenConfig = cfg.TokenConfig{FilePath: tokenFile, ReloadInterval: 1}
+	handler := authHandler(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }, conf, auth.ScopePeerRead)
+	status := func(token string) int {
+		req := httptest.NewRequest("GET", api.ApiIPsPath, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr.Code
+	}
+
+	writeTokens("current: old\n")
+	if err := s.LoadTokens(context.Background(), conf, k8s.Client{}); err != nil {
+		t.Fatal(err)
+	}
+	if status("old") != http.StatusOK || status("new") != http.StatusUnauthorized {
+		t.Errorf("Expected only the old token to be accepted")
+	}
+
+	// Both tokens are accepted during the rotation, the config copy in the handler sees reloads
+	writeTokens("current: new\ntokens:\n- value: old\n")
+	if err := s.LoadTokens(context.Background(), conf, k8s.Client{}); err != nil {
+		t.Fatal(err)
+	}
+	if status("old") != http.StatusOK || status("new") != http.StatusOK {
+		t.Errorf("Expected both tokens to be accepted during rotation")
+	}
+	if conf.Tokens.Current() != "new" {
+		t.Errorf("Expected the new token to be sent to peers, got %s", auth.Fingerprint(conf.Tokens.Current()))
+	}
+
+	// A broken token file keeps the previous tokens
+	writeTokens("current: \"\"\n")
+	if err := s.LoadTokens(context.Background(), conf, k8s.Client{}); err == nil {
+		t.Error("Expected an error loading a token file without current token")
+	}
+	if status("new") != http.StatusOK {
+		t.Errorf("Expected the previous tokens to be kept")
+	}
+	if got := testutil.ToFloat64(s.Metrics.TokenReloads.WithLabelValues("error")); got != 1 {
+		t.Errorf("Expected 1 failed token reload, got %v", got)
+	}
+}
+
+func TestSignedIPs(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPublic, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	serve := func(key ed25519.PrivateKey, clusterID string) *httptest.Server {
+		s := &State{Metrics: metrics.InitMetrics("test")}
+		conf := cfg.Create()
+		conf.KubernetesPollInterval = 60
+		conf.StaleIntervals = 3
+		conf.SigningConfig = cfg.SigningConfig{ClusterID: clusterID, Key: key}
+		s.setLocalIPs([]string{"10.0.0.1"})
+		s.recordLocalIPsSync(nil)
+		peer := httptest.NewServer(s.handleSignedIPs(conf))
+		t.Cleanup(peer.Close)
+		return peer
+	}
+	signedPeer, unsignedPeer := serve(private, "eu"), serve(nil, "us")
+
+	signedClusters := cfg.PeerClusters{signedPeer.URL: "eu"}
+	tests := []struct {
+		peer     *httptest.Server
+		keyring  cfg.Keyring
+		clusters cfg.PeerClusters
+		strict   bool
+		reason   string
+	}{
+		{signedPeer, cfg.Keyring{"eu": {otherPublic, public}}, signedClusters, true, ""},
+		{signedPeer, cfg.Keyring{"eu": {otherPublic}}, signedClusters, false, api.FetchErrorInvalidSignature},
+		{signedPeer, cfg.Keyring{"us": {public}}, nil, false, ""},
+		{signedPeer, cfg.Keyring{"us": {public}}, nil, true, api.FetchErrorUnknownSigner},
+		{signedPeer, cfg.Keyring{"eu": {public}}, nil, false, ""},
+		{signedPeer, cfg.Keyring{"eu": {public}}, nil, true, api.FetchErrorUnknownPeer},
+		{signedPeer, cfg.Keyring{"eu": {public}, "us": {otherPublic}}, cfg.PeerClusters{signedPeer.URL: "us"}, false, api.FetchErrorClusterMismatch},
+		{unsignedPeer, cfg.Keyring{"us": {public}}, nil, false, ""},
+		{unsignedPeer, cfg.Keyring{"us": {public}}, nil, true, api.FetchErrorUnsigned},
+	}
+	for _, test := range tests {
+		conf := cfg.Create()
+		conf.SigningConfig = cfg.SigningConfig{Keyring: test.keyring, PeerClusters: test.clusters, Strict: test.strict, MaxAge: 300}
+		ips, err := api.GetLocalIPs(context.Background(), conf, test.peer.URL)
+		if test.reason == "" && (err != nil || !slices.Equal(ips, []string{"10.0.0.1"})) {
+			t.Errorf("Expected signed IPs to be accepted with keyring %v, clusters %v and strict %t, got %v, %v", test.keyring, test.clusters, test.strict, ips, err)
+		}
+		if test.reason != "" && api.FetchErrorReason(err) != test.reason {
+			t.Errorf("Expected signed IPs to be refused with %s, keyring %v, clusters %v and strict %t, got %v", test.reason, test.keyring, test.clusters, test.strict, err)
+		}
+	}
+
+	// Expired and tampered responses
+	signing := cfg.SigningConfig{Keyring: cfg.Keyring{"eu": {public}}, PeerClusters: cfg.PeerClusters{"peer": "eu"}, Strict: true, MaxAge: 300}
+	signed, err := api.SignIPs(private, "eu", []string{"10.0.0.1"}, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := api.VerifySignedIPs("peer", signed, signing, time.Now()); api.FetchErrorReason(err) != api.FetchErrorExpired {
+		t.Errorf("Expected an expired response to be refused, got %v", err)
+	}
+	signed.Payload = []byte(strings.Replace(string(signed.Payload), "10.0.0.1", "10.6.6.6", 1))
+	if _, err := api.VerifySignedIPs("peer", signed, signing, time.Now().Add(-time.Hour)); api.FetchErrorReason(err) != api.FetchErrorInvalidSignature {
+		t.Errorf("Expected a tampered response to be refused, got %v", err)
+	}
+}
+
+func TestAuthorizationScopes(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "tokens.yaml")
+	tokens := "current: peer\ntokens:\n- value: admin\n  name: ops\n  scopes: [status:read, admin:write]\n"
+	if err := os.WriteFile(tokenFile, []byte(tokens), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s := &State{Metrics: metrics.InitMetrics("test")}
+	conf := cfg.Create()
+	conf.TokenConfig = cfg.TokenConfig{FilePath: tokenFile, ReloadInterval: 1}
+	if err := s.LoadTokens(context.Background(), conf, k8s.Client{}); err != nil {
+		t.Fatal(err)
+	}
+	certPEM, _ := selfSignedKeyPair(t, "ops.example.com")
+	cert, err := utils.ParseCertificatePEM(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.PeerAuthConfig.IdentityScopes = map[string][]string{"ops.example.com": {auth.ScopeStatusRead}}
+
+	status := func(mode, scope, token string, withCert bool) int {
+		conf := conf
+		conf.PeerAuthConfig.Mode = mode
+		req := httptest.NewRequest("POST", "/api/v2/admin/test", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if withCert {
+			req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		}
+		rr := httptest.NewRecorder()
+		authHandler(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusAccepted) }, conf, scope)(rr, req)
+		return rr.Code
+	}
+
+	tests := []struct {
+		mode     string
+		scope    string
+		token    string
+		withCert bool
+		expected int
+	}{
+		{cfg.PeerAuthBearer, auth.ScopePeerRead, "peer", false, http.StatusAccepted},
+		{cfg.PeerAuthBearer, auth.ScopeAdminWrite, "peer", false, http.StatusForbidden},
+		{cfg.PeerAuthBearer, auth.ScopeAdminWrite, "admin", false, http.StatusAccepted},
+		{cfg.PeerAuthBearer, auth.ScopePeerRead, "admin", false, http.StatusForbidden},
+		{cfg.PeerAuthBearer, auth.ScopePeerRead, "", true, http.StatusUnauthorized},
+		{cfg.PeerAuthMTLS, auth.ScopeStatusRead, "", true, http.StatusAccepted},
+		{cfg.PeerAuthMTLS, auth.ScopePeerRead, "", true, http.StatusForbidden},
+		// Both credentials are required, only scopes granted to both are allowed
+		{cfg.PeerAuthBearerAndMTLS, auth.ScopeStatusRead, "admin", true, http.StatusAccepted},
+		{cfg.PeerAuthBearerAndMTLS, auth.ScopeAdminWrite, "admin", true, http.StatusForbidden},
+		// Either credential is enough, scopes granted to any are allowed
+		{cfg.PeerAuthBearerOrMTLS, auth.ScopeAdminWrite, "admin", true, http.StatusAccepted},
+		{cfg.PeerAuthBearerOrMTLS, auth.ScopePeerRead, "wrong", true, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		if code := status(test.mode, test.scope, test.token, test.withCert); code != test.expected {
+			t.Errorf("Expected %d for %s with scope %s, token %q and certificate %t, got %d", test.expected, test.mode, test.scope, test.token, test.withCert, code)
+		}
+	}
+
+	// The dashboard requires status:read from credentials of the peer authentication mode, or the dashboard token
+	conf.DashboardToken = "dashboard"
+	dashboardStatus := func(mode, token string, basic, withCert bool) int {
+		conf := conf
+		conf.PeerAuthConfig.Mode = mode
+		req := httptest.NewRequest("GET", "/", nil)
+		if basic {
+			req.SetBasicAuth("", token)
+		} else if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if withCert {
+			req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		}
+		rr := httptest.NewRecorder()
+		dashboardAuthHandler(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }, conf)(rr, req)
+		return rr.Code
+	}
+	dashboardTests := []struct {
+		mode     string
+		token    string
+		basic    bool
+		withCert bool
+		expected int
+	}{
+		{cfg.PeerAuthBearer, "peer", true, false, http.StatusOK},
+		{cfg.PeerAuthBearer, "admin", false, false, http.StatusOK},
+		{cfg.PeerAuthBearer, "dashboard", true, false, http.StatusOK},
+		{cfg.PeerAuthBearer, "wrong", true, false, http.StatusUnauthorized},
+		{cfg.PeerAuthBearer, "", false, true, http.StatusUnauthorized},
+		// Bearer tokens don't authenticate without mTLS, the certificate identity has status:read
+		{cfg.PeerAuthMTLS, "admin", true, false, http.StatusUnauthorized},
+		{cfg.PeerAuthMTLS, "", false, true, http.StatusOK},
+		{cfg.PeerAuthMTLS, "dashboard", false, false, http.StatusOK},
+		{cfg.PeerAuthBearerAndMTLS, "admin", false, false, http.StatusUnauthorized},
+		{cfg.PeerAuthBearerAndMTLS, "admin", true, true, http.StatusOK},
+		{cfg.PeerAuthBearerOrMTLS, "", false, true, http.StatusOK},
+	}
+	for _, test := range dashboardTests {
+		if code := dashboardStatus(test.mode, test.token, test.basic, test.withCert); code != test.expected {
+			t.Errorf("Expected %d for the dashboard with %s, token %q (basic auth %t) and certificate %t, got %d",
+				test.expected, test.mode, test.token, test.basic, test.withCert, code)
+		}
+	}
+}
+
+func TestAdminAPI(t *testing.T) {
+	fake, kc := newFakeKubernetes(t)
+	s := &State{Metrics: metrics.InitMetrics("test")}
+	conf := testCertificateConfig()
+	if _, err := conf.Tokens.Load([]byte("current: peer\ntokens:\n- value: admin\n  name: ops\n  scopes: [admin:write]\n")); err != nil {
+		t.Fatal(err)
+	}
+	post := func(handler http.HandlerFunc, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", api.ApiAdminApprovePath, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		authHandler(handler, conf, auth.ScopeAdminWrite)(rr, req)
+		return rr
+	}
+
+	// Peers can't use the admin API
+	if rr := post(s.handleAdminReconcile(), "peer", ""); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a peer token, got %d", rr.Code)
+	}
+	// Only the leader reconciles
+	s.setLeaderIdentity("pd-assistant-0")
+	if rr := post(s.handleAdminReconcile(), "admin", ""); rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), "pd-assistant-0") {
+		t.Errorf("Expected 409 naming the leader on a follower, got %d: %s", rr.Code, rr.Body.String())
+	}
+	s.setLeading(true)
+	if rr := post(s.handleAdminReconcile(), "admin", ""); rr.Code != http.StatusAccepted {
+		t.Errorf("Expected 202 for a forced reconcile, got %d", rr.Code)
+	}
+	select {
+	case reason := <-s.queue():
+		if reason != ReconcileAdmin {
+			t.Errorf("Expected an admin reconcile, got %s", reason)
+		}
+	default:
+		t.Error("Expected a forced reconcile to be triggered")
+	}
+
+	// The certificate doesn't exist yet
+	if rr := post(s.handleAdminPause(conf, kc), "admin", `{"reason": "maintenance"}`); rr.Code != http.StatusConflict {
+		t.Errorf("Expected 409 pausing a missing certificate, got %d", rr.Code)
+	}
+	if rr := post(s.handleAdminResume(conf, kc), "admin", ""); rr.Code != http.StatusOK {
+		t.Errorf("Expected resuming a missing certificate to succeed, got %d", rr.Code)
+	}
+
+	certificate := conf.Certificate.DeepCopy()
+	certificate.Spec.IPAddresses = []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+	fake.put(t, testCertificatePath, certificate)
+
+	// Nothing to approve
+	if rr := post(s.handleAdminApprove(conf, kc), "admin", ""); rr.Code != http.StatusConflict {
+		t.Errorf("Expected 409 without a blocked change, got %d", rr.Code)
+	}
+
+	// Changes under the limit aren't blocked
+	conf.ReconcileConfig.MaxRemovedIPs = 1
+	if err := s.checkApproval(context.Background(), conf, kc, []string{"10.0.0.1", "10.0.0.2"}); err != nil {
+		t.Errorf("Expected no approval for a change under the limit, got %v", err)
+	}
+	if err := s.checkApproval(context.Background(), conf, kc, []string{"10.0.0.1"}); !errors.Is(err, errApprovalRequired) {
+		t.Fatalf("Expected the change to require an approval, got %v", err)
+	}
+	if blocked := testutil.ToFloat64(s.Metrics.CertChangeBlocked.WithLabelValues()); blocked != 1 {
+		t.Errorf("Expected the blocked change gauge to be set, got %v", blocked)
+	}
+	blocked := s.getAdmin().Blocked
+	if status := s.status(conf); status.Admin.Blocked == nil || status.Admin.Blocked.Hash != blocked.Hash {
+		t.Errorf("Expected the blocked change in the status, got %+v", status.Admin)
+	}
+
+	// Followers approve the persisted blocked change
+	follower := &State{Metrics: metrics.InitMetrics("test")}
+	if rr := post(follower.handleAdminApprove(conf, kc), "admin", `{"hash": "v2:other"}`); rr.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for another change, got %d", rr.Code)
+	}
+	if rr := post(follower.handleAdminApprove(conf, kc), "admin", `{"hash": `); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid request, got %d", rr.Code)
+	}
+	if rr := post(follower.handleAdminApprove(conf, kc), "admin", `{"hash": "`+blocked.Hash+`"}`); rr.Code != http.StatusAccepted {
+		t.Errorf("Expected a follower to approve the blocked change, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if err := s.syncAdmin(context.Background(), conf, kc); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.checkApproval(context.Background(), conf, kc, []string{"10.0.0.1"}); err != nil {
+		t.Errorf("Expected the approved change to be applied, got %v", err)
+	}
+	fake.get(t, testCertificatePath, certificate)
+	if _, ok := certificate.Annotations[BlockedAnnotation]; ok || s.getAdmin().Blocked != nil {
+		t.Errorf("Expected the applied change to be unblocked, got %v", certificate.Annotations)
+	}
+
+	// An update postponed by an issuance in flight keeps the approval
+	s.startIssuance(certificate, "", time.Now())
+	if err := s.updateCertManagerCertificate(context.Background(), conf, kc, []string{"10.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	s.clearApproval(context.Background(), conf, kc)
+	fake.get(t, testCertificatePath, certificate)
+	if certificate.Annotations[ApprovedAnnotation] != blocked.Hash || s.getAdmin().ApprovedHash != blocked.Hash {
+		t.Errorf("Expected the approval to be kept while the issuance is in flight, got %v", certificate.Annotations)
+	}
+	s.setIssuance(IssuanceStatus{})
+	if err := s.updateCertManagerCertificate(context.Background(), conf, kc, []string{"10.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	s.clearApproval(context.Background(), conf, kc)
+	var applied cmapi.Certificate
+	fake.get(t, testCertificatePath, &applied)
+	if _, ok := applied.Annotations[ApprovedAnnotation]; ok || s.getAdmin().ApprovedHash != "" {
+		t.Errorf("Expected the approval to be removed once applied, got %v", applied.Annotations)
+	}
+
+	// Pausing works once the certificate exists
+	if rr := post(s.handleAdminPause(conf, kc), "admin", `{"reason": "maintenance"}`); rr.Code != http.StatusOK {
+		t.Errorf("Expected the certificate updates to be paused, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestIPOverrides(t *testing.T) {
+	overridesFile := filepath.Join(t.TempDir(), "overrides.yaml")
+	expired := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	overrides := "pinned:\n- ip: 10.0.0.5\n  reason: migration\n- ip: 10.0.0.6\n  expires: " + expired + "\nexcluded:\n- ip: 10.0.0.2\n  reason: flapping\n"
+	if err := os.WriteFile(overridesFile, []byte(overrides), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s := &State{Metrics: metrics.InitMetrics("test")}
+	conf := cfg.Create()
+	conf.IPOverridesConfig = cfg.IPOverridesConfig{FilePath: overridesFile, ReloadInterval: 1}
+	if err := s.LoadIPOverrides(context.Background(), conf, k8s.Client{}); err != nil {
+		t.Fatal(err)
+	}
+
+	conf.IPOverrides = s.activeIPOverrides(time.Now())
+	desired := k8s.DesiredIPAddresses(conf, []string{"10.0.0.1", "10.0.0.2"})
+	if !slices.Equal(desired, []string{"10.0.0.1", "10.0.0.5"}) {
+		t.Errorf("Expected the excluded IP removed and only the active pinned IP added, got %v", desired)
+	}
+	if pinned := testutil.ToFloat64(s.Metrics.IPOverrides.WithLabelValues("pinned")); pinned != 1 {
+		t.Errorf("Expected 1 active pinned IP, got %v", pinned)
+	}
+
+	status := s.status(conf).IPOverrides
+	if status.Source != overridesFile || len(status.Pinned) != 2 || !status.Pinned[0].Active || status.Pinned[1].Active {
+		t.Errorf("Expected the active and the expired pinned IP in the status, got %+v", status)
+	}
+	rr := httptest.NewRecorder()
+	s.handleDashboard(conf)(rr, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(rr.Body.String(), "flapping") {
+		t.Errorf("Expected the dashboard to show IP overrides, got %d", rr.Code)
+	}
+
+	// Invalid files keep the previous overrides
+	if err := os.WriteFile(overridesFile, []byte("excluded:\n- ip: invalid\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.LoadIPOverrides(context.Background(), conf, k8s.Client{}); err == nil {
+		t.Error("Expected an invalid IP overrides file to be refused")
+	}
+	status = s.getIPOverridesStatus(time.Now())
+	if status.Error == "" || len(status.Excluded) != 1 || status.Excluded[0].IP != "10.0.0.2" {
+		t.Errorf("Expected the previous overrides with the error, got %+v", status)
+	}
+}
+
+// fakeKubernetes is a minimal in-memory Kubernetes API server for namespaced resources.
+// It supports get, list with label selectors, create with generated names, update, merge patch and delete.
+type fakeKubernetes struct {
+	*httptest.Server
+	mu      sync.Mutex
+	objects map[string]map[string]any
+	names   int
+}
+
+// newFakeKubernetes starts a fake Kubernetes API server, objects are keyed by their API path.
+func newFakeKubernetes(t *testing.T) (*fakeKubernetes, k8s.Client) {
+	t.Helper()
+	f := &fakeKubernetes{objects: map[string]map[string]any{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f, k8s.Client{Config: &rest.Config{Host: f.URL, ContentConfig: rest.ContentConfig{ContentType: "application/json"}}}
+}
+
+// put stores an object at its API path, e.g. /apis/cert-manager.io/v1/namespaces/tidb/certificates/pd.
+func (f *fakeKubernetes) put(t *testing.T, path string, object any) {
+	t.Helper()
+	data, err := json.Marshal(object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored map[string]any
+	if err := json.Unmarshal(data, &stored); err != nil {
+		t.Fatal(err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[path] = stored
+}
+
+// get decodes the object stored at the API path, it returns false if there is none.
+func (f *fakeKubernetes) get(t *testing.T, path string, object any) bool {
+	t.Helper()
+	f.mu.Lock()
+	stored, ok := f.objects[path]
+	data, err := json.Marshal(stored)
+	f.mu.Unlock()
+	if !ok {
+		return false
+	}
+	if err == nil {
+		err = json.Unmarshal(data, object)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return true
+}
+
+// list returns API paths of stored objects under the collection path.
+func (f *fakeKubernetes) list(collection string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	paths := []string{}
+	for path := range f.objects {
+		if strings.HasPrefix(path, collection+"/") && !strings.Contains(strings.TrimPrefix(path, collection+"/"), "/") {
+			paths = append(paths, path)
+		}
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+// matchesLabels checks an object against an equality label selector.
+func matchesLabels(object map[string]any, selector string) bool {
+	metadata, _ := object["metadata"].(map[string]any)
+	labels, _ := metadata["labels"].(map[string]any)
+	for _, requirement := range strings.Split(selector, ",") {
+		key, value, ok := strings.Cut(requirement, "=")
+		if requirement != "" && (!ok || labels[key] != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// mergePatch applies a JSON merge patch to an object.
+func mergePatch(object, patch map[string]any) {
+	for key, value := range patch {
+		patchMap, isMap := value.(map[string]any)
+		objectMap, hasMap := object[key].(map[string]any)
+		switch {
+		case value == nil:
+			delete(object, key)
+		case isMap && hasMap:
+			mergePatch(objectMap, patchMap)
+		case isMap:
+			object[key] = map[string]any{}
+			mergePatch(object[key].(map[string]any), patchMap)
+		default:
+			object[key] = value
+		}
+	}
+}
+
+func (f *fakeKubernetes) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	respond := func(status int, object any) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(object)
+	}
+	notFound := func() {
+		respond(http.StatusNotFound, map[string]any{"kind": "Status", "apiVersion": "v1", "status": "Failure", "reason": "NotFound", "code": http.StatusNotFound})
+	}
+
+	// Collections are namespaces/<namespace>/<resource>, objects have a name on top
+	path := r.URL.Path
+	_, rest, _ := strings.Cut(path, "/namespaces/")
+	isCollection := strings.Count(rest, "/") == 1
+	var body map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && r.Method != "GET" && r.Method != "DELETE" {
+		respond(http.StatusBadRequest, map[string]any{"kind": "Status", "apiVersion": "v1", "status": "Failure", "message": err.Error(), "code": http.StatusBadRequest})
+		return
+	}
+
+	switch {
+	case r.Method == "GET" && isCollection:
+		items := []any{}
+		for stored, object := range f.objects {
+			if strings.HasPrefix(stored, path+"/") && matchesLabels(object, r.URL.Query().Get("labelSelector")) {
+				items = append(items, object)
+			}
+		}
+		// Typed clients of API groups only decode their own list kinds
+		kind, apiVersion := "List", "v1"
+		if group, ok := strings.CutPrefix(path, "/apis/"); ok {
+			groupVersion, _, _ := strings.Cut(group, "/namespaces/")
+			kind, apiVersion = map[string]string{"certificaterequests": "CertificateRequestList", "certificates": "CertificateList"}[rest[strings.LastIndex(rest, "/")+1:]], groupVersion
+		}
+		respond(http.StatusOK, map[string]any{"kind": kind, "apiVersion": apiVersion, "metadata": map[string]any{}, "items": items})
+	case r.Method == "GET":
+		if object, ok := f.objects[path]; ok {
+			respond(http.StatusOK, object)
+			return
+		}
+		notFound()
+	case r.Method == "POST" && isCollection:
+		metadata, _ := body["metadata"].(map[string]any)
+		if metadata == nil {
+			metadata = map[string]any{}
+			body["metadata"] = metadata
+		}
+		if name, _ := metadata["name"].(string); name == "" {
+			f.names++
+			generateName, _ := metadata["generateName"].(string)
+			metadata["name"] = fmt.Sprintf("%s%d", generateName, f.names)
+		}
+		metadata["resourceVersion"] = "1"
+		metadata["creationTimestamp"] = time.Now().UTC().Format(time.RFC3339)
+		f.objects[path+"/"+metadata["name"].(string)] = body
+		respond(http.StatusCreated, body)
+	case r.Method == "PUT":
+		if _, ok := f.objects[path]; !ok {
+			notFound()
+			return
+		}
+		f.objects[path] = body
+		respond(http.StatusOK, body)
+	case r.Method == "PATCH":
+		object, ok := f.objects[path]
+		if !ok {
+			notFound()
+			return
+		}
+		mergePatch(object, body)
+		respond(http.StatusOK, object)
+	case r.Method == "DELETE" && isCollection:
+		for stored, object := range f.objects {
+			if strings.HasPrefix(stored, path+"/") && matchesLabels(object, r.URL.Query().Get("labelSelector")) {
+				delete(f.objects, stored)
+			}
+		}
+		respond(http.StatusOK, map[string]any{"kind": "Status", "apiVersion": "v1", "status": "Success"})
+	case r.Method == "DELETE":
+		delete(f.objects, path)
+		respond(http.StatusOK, map[string]any{"kind": "Status", "apiVersion": "v1", "status": "Success"})
+	default:
+		respond(http.StatusMethodNotAllowed, map[string]any{"kind": "Status", "apiVersion": "v1", "status": "Failure", "code": http.StatusMethodNotAllowed})
+	}
+}
+
+// Paths of objects used by the certificate tests
+const (
+	testCertificatePath  = "/apis/cert-manager.io/v1/namespaces/tidb/certificates/pd"
+	testSecretPath       = "/api/v1/namespaces/tidb/secrets/pd-tls"
+	testEventsPath       = "/api/v1/namespaces/tidb/events"
+	testCertificateName  = "pd"
+	testCertificateSpace = "tidb"
+)
+
+// testCertificateConfig returns a config with the certificate template used with fakeKubernetes.
+func testCertificateConfig() cfg.AppConfig {
+	conf := cfg.Create()
+	conf.Certificate = cmapi.Certificate{
+		ObjectMeta: metav1.ObjectMeta{Name: testCertificateName, Namespace: testCertificateSpace},
+		Spec: cmapi.CertificateSpec{
+			SecretName: "pd-tls",
+			IssuerRef:  cmmeta.ObjectReference{Name: "pd-issuer", Kind: "Issuer"},
+			DNSNames:   []string{"pd.tidb.svc"},
+		},
+	}
+	conf.CertIssuanceTimeout = 600
+	return conf
+}
+
+// tlsSecret returns a kubernetes.io/tls Secret holding the certificate.
+func tlsSecret(certPEM, keyPEM []byte) corev1.Secret {
+	return corev1.Secret{
+		TypeMeta:   metav1.TypeMeta{Kind: "Secret", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: "pd-tls", Namespace: testCertificateSpace},
+		Type:       corev1.SecretTypeTLS,
+		Data:       map[string][]byte{corev1.TLSCertKey: certPEM, corev1.TLSPrivateKeyKey: keyPEM},
+	}
+}
+
+func TestCheckIssuedCertificate(t *testing.T) {
+	fake, kc := newFakeKubernetes(t)
+	s := &State{Metrics: metrics.InitMetrics("test")}
+	conf := testCertificateConfig()
+
+	// Nothing to compare with before all pd-assistants were polled
+	s.checkIssuedCertificate(context.Background(), conf, kc)
+	if status := s.getCertStatus(); status.Error == "" || status.Drift {
+		t.Errorf("Expected an unknown desired IP set to be reported, got %+v", status)
+	}
+
+	// The issued certificate has only 127.0.0.1 and no DNS names
+	certPEM, keyPEM := selfSignedKeyPair(t, "pd")
+	issued, err := utils.ParseCertificatePEM(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate := conf.Certificate.DeepCopy()
+	certificate.Status.Conditions = []cmapi.CertificateCondition{{Type: cmapi.CertificateConditionReady, Status: cmmeta.ConditionTrue}}
+	fake.put(t, testCertificatePath, certificate)
+	fake.put(t, testSecretPath, tlsSecret(certPEM, keyPEM))
+	s.setAllIPs([]string{"10.0.0.2", "127.0.0.1"})
+	s.checkIssuedCertificate(context.Background(), conf, kc)
+
+	status := s.status(conf).Certificate
+	if status.Error != "" || !status.Ready || !status.Drift || !status.NotAfter.Equal(issued.NotAfter) {
+		t.Errorf("Expected a ready certificate with drift, got %+v", status)
+	}
+	if !slices.Equal(status.MissingIPs, []string{"10.0.0.2"}) || len(status.ExtraIPs) != 0 ||
+		!slices.Equal(status.MissingDNSNames, []string{"pd.tidb.svc"}) || len(status.ExtraDNSNames) != 0 {
+		t.Errorf("Expected the missing IP and DNS name in the status, got %+v", status)
+	}
+	if !slices.Equal(status.DesiredIPs, []string{"10.0.0.2", "127.0.0.1"}) || !slices.Equal(status.AppliedIPs, []string{"127.0.0.1"}) {
+		t.Errorf("Expected the desired and applied IPs in the status, got %+v", status)
+	}
+	for san, expected := range map[string]float64{"ip": 1, "dns": 1} {
+		if drift := testutil.ToFloat64(s.Metrics.CertSANDrift.WithLabelValues(san)); drift != expected {
+			t.Errorf("Expected %s drift %v, got %v", san, expected, drift)
+		}
+	}
+	if ready := testutil.ToFloat64(s.Metrics.CertReady.WithLabelValues()); ready != 1 {
+		t.Errorf("Expected the certificate to be ready, got %v", ready)
+	}
+	if notAfter := testutil.ToFloat64(s.Metrics.CertNotAfter.WithLabelValues()); notAfter != float64(issued.NotAfter.Unix()) {
+		t.Errorf("Expected the not after timestamp %d, got %v", issued.NotAfter.Unix(), notAfter)
+	}
+
+	// Extra SANs drift as well, matching SANs don't
+	conf.Certificate.Spec.DNSNames = nil
+	s.setAllIPs([]string{"10.0.0.2"})
+	s.checkIssuedCertificate(context.Background(), conf, kc)
+	if status := s.getCertStatus(); !slices.Equal(status.ExtraIPs, []string{"127.0.0.1"}) || len(status.MissingDNSNames) != 0 {
+		t.Errorf("Expected the extra IP in the status, got %+v", status)
+	}
+	s.setAllIPs([]string{"127.0.0.1"})
+	s.checkIssuedCertificate(context.Background(), conf, kc)
+	if status := s.getCertStatus(); status.Drift || testutil.ToFloat64(s.Metrics.CertSANDrift.WithLabelValues("ip")) != 0 {
+		t.Errorf("Expected no drift for matching SANs, got %+v", status)
+	}
+}
+
+func TestIssuance(t *testing.T) {
+	started := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	oldCert, _ := selfSignedKeyPair(t, "old")
+	newCert, newKey := selfSignedKeyPair(t, "new")
+	oldParsed, err := utils.ParseCertificatePEM(oldCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ready := func(generation int64) cmapi.CertificateCondition {
+		return cmapi.CertificateCondition{Type: cmapi.CertificateConditionReady, Status: cmmeta.ConditionTrue, ObservedGeneration: generation}
+	}
+
+	tests := []struct {
+		name        string
+		conditions  []cmapi.CertificateCondition
+		lastFailure time.Time
+		secretCert  []byte
+		missing     bool
+		now         time.Time
+		done        bool
+		state       string
+		result      string
+	}{
+		{"issued", []cmapi.CertificateCondition{ready(2)}, time.Time{}, newCert, false, started.Add(time.Minute), true, IssuanceSucceeded, IssuanceSucceeded},
+		{"ready for the previous generation", []cmapi.CertificateCondition{ready(1)}, time.Time{}, newCert, false, started.Add(time.Minute), false, IssuanceInFlight, ""},
+		{"secret not renewed yet", []cmapi.CertificateCondition{ready(2)}, time.Time{}, oldCert, false, started.Add(time.Minute), false, IssuanceInFlight, ""},
+		{"failed", nil, started.Add(time.Second), oldCert, false, started.Add(time.Minute), true, IssuanceFailed, IssuanceFailed},
+		{"failed before the spec update", nil, started.Add(-time.Second), oldCert, false, started.Add(time.Minute), false, IssuanceInFlight, ""},
+		{"timed out", nil, time.Time{}, oldCert, false, started.Add(11 * time.Minute), true, IssuanceFailed, IssuanceTimedOut},
+		{"certificate missing", nil, time.Time{}, oldCert, true, started.Add(time.Minute), false, IssuanceInFlight, ""},
+		{"certificate missing and timed out", nil, time.Time{}, oldCert, true, started.Add(11 * time.Minute), true, IssuanceFailed, IssuanceTimedOut},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, kc := newFakeKubernetes(t)
+			s := &State{Metrics: metrics.InitMetrics("test")}
+			conf := testCertificateConfig()
+
+			certificate := conf.Certificate.DeepCopy()
+			certificate.Generation = 2
+			certificate.Status.Conditions = test.conditions
+			if !test.lastFailure.IsZero() {
+				certificate.Status.LastFailureTime = &metav1.Time{Time: test.lastFailure}
+			}
+			if !test.missing {
+				fake.put(t, testCertificatePath, certificate)
+			}
+			fake.put(t, testSecretPath, tlsSecret(test.secretCert, newKey))
+
+			s.startIssuance(certificate, oldParsed.SerialNumber.String(), started)
+			if done := s.checkIssuance(context.Background(), conf, kc, test.now); done != test.done {
+				t.Errorf("Expected done %v, got %v", test.done, done)
+			}
+			if issuance := s.getIssuance(); issuance.State != test.state {
+				t.Errorf("Expected state %s, got %+v", test.state, issuance)
+			}
+			if test.result == "" {
+				if inFlight := testutil.ToFloat64(s.Metrics.CertIssuanceInFlight.WithLabelValues()); inFlight != 1 {
+					t.Errorf("Expected the issuance to be in flight, got %v", inFlight)
+				}
+				return
+			}
+			if count := testutil.ToFloat64(s.Metrics.CertIssuances.WithLabelValues(test.result)); count != 1 {
+				t.Errorf("Expected one %s issuance, got %v", test.result, count)
+			}
+			if duration := testutil.ToFloat64(s.Metrics.CertIssuanceDuration.WithLabelValues()); duration != test.now.Sub(started).Seconds() {
+				t.Errorf("Expected the issuance duration from the clock, got %v", duration)
+			}
+			if events := fake.list(testEventsPath); len(events) != 1 {
+				t.Errorf("Expected an issuance event, got %v", events)
+			}
+		})
+	}
+
+	// A spec update starts an issuance, further updates wait until it's done
+	fake, kc := newFakeKubernetes(t)
+	s := &State{Metrics: metrics.InitMetrics("test")}
+	conf := testCertificateConfig()
+	fake.put(t, testSecretPath, tlsSecret(oldCert, newKey))
+	if err := s.updateCertManagerCertificate(context.Background(), conf, kc, []string{"10.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if issuance := s.getIssuance(); issuance.State != IssuanceInFlight || issuance.previousSerial != oldParsed.SerialNumber.String() {
+		t.Errorf("Expected an issuance in flight from the old serial, got %+v", issuance)
+	}
+	if err := s.updateCertManagerCertificate(context.Background(), conf, kc, []string{"10.0.0.2"}); err != nil {
+		t.Fatal(err)
+	}
+	var certificate cmapi.Certificate
+	if !fake.get(t, testCertificatePath, &certificate) || !slices.Equal(certificate.Spec.IPAddresses, []string{"10.0.0.1"}) {
+		t.Errorf("Expected the second update to wait for the issuance, got %v", certificate.Spec.IPAddresses)
+	}
+}
+
+func TestPDReload(t *testing.T) {
+	fake, kc := newFakeKubernetes(t)
+	healthy := true
+	pd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `[{"name": "pd-0", "health": %v}]`, healthy)
+	}))
+	defer pd.Close()
+	var reloads []string
+	reloader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		reloads = append(reloads, body["certificate_hash"])
+	}))
+	defer reloader.Close()
+
+	conf := testCertificateConfig()
+	conf.PDConfig.Address = strings.TrimPrefix(pd.URL, "http://")
+	conf.PostIssuanceConfig = cfg.PostIssuanceConfig{Action: cfg.PostIssuanceActionReloadEndpoint, ReloadURL: reloader.URL, MinInterval: 60}
+	s := &State{Metrics: metrics.InitMetrics("test")}
+	s.setLeading(true)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// changeSecret replaces the Secret data, keeping its annotations
+	changeSecret := func(commonName string) string {
+		var secret corev1.Secret
+		fake.get(t, testSecretPath, &secret)
+		certPEM, keyPEM := selfSignedKeyPair(t, commonName)
+		changed := tlsSecret(certPEM, keyPEM)
+		changed.Annotations = secret.Annotations
+		fake.put(t, testSecretPath, changed)
+		return secretDataHash(&changed)
+	}
+	annotations := func() map[string]string {
+		var secret corev1.Secret
+		fake.get(t, testSecretPath, &secret)
+		return secret.Annotations
+	}
+
+	// The certificate found first is the one PD runs with
+	first := changeSecret("first")
+	s.checkPDReload(context.Background(), conf, kc, now)
+	if len(reloads) != 0 || annotations()[ReloadedAnnotation] != first {
+		t.Errorf("Expected the first certificate to be recorded without a reload, got %v and %v", reloads, annotations())
+	}
+
+	// Changes are only picked up by the leader, and wait for a healthy PD cluster
+	second := changeSecret("second")
+	s.setLeading(false)
+	s.checkPDReload(context.Background(), conf, kc, now)
+	if annotations()[ReloadPendingAnnotation] != "" {
+		t.Errorf("Expected followers to leave the reload alone, got %v", annotations())
+	}
+	s.setLeading(true)
+	healthy = false
+	s.checkPDReload(context.Background(), conf, kc, now)
+	if len(reloads) != 0 || annotations()[ReloadPendingAnnotation] != second || s.getReload().PendingHash != second {
+		t.Errorf("Expected a pending reload while PD is unhealthy, got %v and %v", reloads, annotations())
+	}
+
+	// The pending reload survives a restart
+	healthy = true
+	s = &State{Metrics: metrics.InitMetrics("test")}
+	s.setLeading(true)
+	s.checkPDReload(context.Background(), conf, kc, now)
+	if !slices.Equal(reloads, []string{second}) || annotations()[ReloadedAnnotation] != second || annotations()[ReloadPendingAnnotation] != "" {
+		t.Errorf("Expected PD to be reloaded after the restart, got %v and %v", reloads, annotations())
+	}
+	if pending := testutil.ToFloat64(s.Metrics.PDReloadPending.WithLabelValues()); pending != 0 {
+		t.Errorf("Expected no pending reload, got %v", pending)
+	}
+
+	// Reloads are rate limited
+	third := changeSecret("third")
+	s.checkPDReload(context.Background(), conf, kc, now.Add(30*time.Second))
+	if len(reloads) != 1 || s.getReload().PendingHash != third {
+		t.Errorf("Expected the reload to be rate limited, got %v", reloads)
+	}
+	s.checkPDReload(context.Background(), conf, kc, now.Add(61*time.Second))
+	if !slices.Equal(reloads, []string{second, third}) {
+		t.Errorf("Expected PD to be reloaded after the minimal interval, got %v", reloads)
+	}
+	if count := testutil.ToFloat64(s.Metrics.PDReloads.WithLabelValues("success")); count != 2 {
+		t.Errorf("Expected two successful reloads since the restart, got %v", count)
+	}
+}
+
+func TestLocalCARenewal(t *testing.T) {
+	fake, kc := newFakeKubernetes(t)
+	caCert, caKey := selfSignedKeyPair(t, "pd-ca")
+	ca, err := utils.ParseCertificatePEM(caCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.put(t, "/api/v1/namespaces/tidb/secrets/pd-ca", corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "pd-ca", Namespace: testCertificateSpace},
+		Type:       corev1.SecretTypeTLS,
+		Data:       map[string][]byte{corev1.TLSCertKey: caCert, corev1.TLSPrivateKeyKey: caKey},
+	})
+	conf := testCertificateConfig()
+	conf.IssuerMode = cfg.IssuerModeLocalCA
+	conf.LocalCAConfig.SecretName = "pd-ca"
+	conf.Certificate.Spec.Duration = &metav1.Duration{Duration: 90 * 24 * time.Hour}
+	s := &State{Metrics: metrics.InitMetrics("test")}
+
+	// Nothing to renew before the first certificate was issued
+	now := time.Now()
+	if err := s.renewLocalCACertificate(context.Background(), conf, kc, now); err != nil || len(fake.list("/api/v1/namespaces/tidb/secrets")) != 1 {
+		t.Fatalf("Expected no certificate without peers, got %v", err)
+	}
+
+	ips := []string{"10.0.0.1", "10.0.0.2"}
+	if err := s.issueLocalCACertificate(context.Background(), conf, kc, ips); err != nil {
+		t.Fatal(err)
+	}
+	issued := func() *x509.Certificate {
+		var secret corev1.Secret
+		fake.get(t, testSecretPath, &secret)
+		cert, err := utils.ParseCertificatePEM(secret.Data[corev1.TLSCertKey])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	first := issued()
+	if err := first.CheckSignatureFrom(ca); err != nil {
+		t.Errorf("Expected the certificate to be signed by the local CA, got %v", err)
+	}
+
+	// The renewal keeps the IPs of the current certificate, whatever peers report
+	if err := s.renewLocalCACertificate(context.Background(), conf, kc, now.Add(30*24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if issued().SerialNumber.Cmp(first.SerialNumber) != 0 {
+		t.Error("Expected no renewal before the renewal time")
+	}
+	if err := s.renewLocalCACertificate(context.Background(), conf, kc, now.Add(61*24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	renewed := issued()
+	renewedIPs := []string{}
+	for _, ip := range renewed.IPAddresses {
+		renewedIPs = append(renewedIPs, ip.String())
+	}
+	if renewed.SerialNumber.Cmp(first.SerialNumber) == 0 || !slices.Equal(renewedIPs, ips) {
+		t.Errorf("Expected the certificate to be renewed with IPs %v, got %v", ips, renewedIPs)
+	}
+}
+
+func TestCertificateRequest(t *testing.T) {
+	const requestsPath = "/apis/cert-manager.io/v1/namespaces/tidb/certificaterequests"
+	fake, kc := newFakeKubernetes(t)
+	conf := testCertificateConfig()
+	conf.IssuerMode = cfg.IssuerModeCertificateRequest
+	s := &State{Metrics: metrics.InitMetrics("test")}
+	ips := []string{"10.0.0.1"}
+
+	request := func() cmapi.CertificateRequest {
+		t.Helper()
+		paths := fake.list(requestsPath)
+		if len(paths) != 1 {
+			t.Fatalf("Expected one certificate request, got %v", paths)
+		}
+		var request cmapi.CertificateRequest
+		fake.get(t, paths[0], &request)
+		return request
+	}
+	setCondition := func(request cmapi.CertificateRequest, condition cmapi.CertificateRequestCondition) {
+		request.Status.Conditions = append(request.Status.Conditions, condition)
+		fake.put(t, requestsPath+"/"+request.Name, request)
+	}
+
+	// A missing Secret creates a request, it isn't repeated while pending
+	if err := s.requestCertificate(context.Background(), conf, kc, ips); err != nil {
+		t.Fatal(err)
+	}
+	created := request()
+	if created.Annotations[k8s.CertificateRequestHashAnnotation] == "" || s.getIssuance().Request != created.Name {
+		t.Errorf("Expected an annotated request in flight, got %+v and %+v", created.ObjectMeta, s.getIssuance())
+	}
+	if err := s.requestCertificate(context.Background(), conf, kc, ips); err != nil {
+		t.Fatal(err)
+	}
+	if pending := request(); pending.Name != created.Name {
+		t.Errorf("Expected the pending request to be kept, got %s", pending.Name)
+	}
+
+	// After a restart the pending request is found by its label
+	s = &State{Metrics: metrics.InitMetrics("test")}
+	if err := s.requestCertificate(context.Background(), conf, kc, ips); err != nil {
+		t.Fatal(err)
+	}
+	if issuance := s.getIssuance(); issuance.State != IssuanceInFlight || issuance.Request != created.Name || request().Name != created.Name {
+		t.Errorf("Expected the pending request to be adopted, got %+v", issuance)
+	}
+
+	// A denied request isn't repeated until the IPs change
+	setCondition(created, cmapi.CertificateRequestCondition{Type: cmapi.CertificateRequestConditionDenied, Status: cmmeta.ConditionTrue, Message: "not allowed"})
+	for range 2 {
+		if err := s.requestCertificate(context.Background(), conf, kc, ips); !errors.Is(err, errRequestRefused) {
+			t.Errorf("Expected the denied request to be surfaced, got %v", err)
+		}
+		if denied := request(); denied.Name != created.Name {
+			t.Errorf("Expected the denied request not to be recreated, got %s", denied.Name)
+		}
+	}
+	if issuance := s.getIssuance(); issuance.State != IssuanceFailed {
+		t.Errorf("Expected a failed issuance, got %+v", issuance)
+	}
+	ips = []string{"10.0.0.1", "10.0.0.2"}
+	if err := s.requestCertificate(context.Background(), conf, kc, ips); err != nil {
+		t.Fatal(err)
+	}
+	renewed := request()
+	if renewed.Name == created.Name {
+		t.Error("Expected a new request for the new IPs")
+	}
+
+	// The issued certificate is stored in the Secret, with the key the request was created with
+	caCert, caKey := selfSignedKeyPair(t, "pd-ca")
+	ca, err := issuer.LoadCA(caCert, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(renewed.Spec.Request)
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template, err := issuer.CertificateTemplate(conf.Certificate.Spec, ips)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, err := ca.Sign(template, csr.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renewed.Status.Certificate = certPEM
+	renewed.Status.CA = caCert
+	setCondition(renewed, cmapi.CertificateRequestCondition{Type: cmapi.CertificateRequestConditionReady, Status: cmmeta.ConditionTrue})
+	if err := s.requestCertificate(context.Background(), conf, kc, ips); err != nil {
+		t.Fatal(err)
+	}
+	var secret corev1.Secret
+	if !fake.get(t, testSecretPath, &secret) || string(secret.Data[corev1.TLSCertKey]) != string(certPEM) {
+		t.Fatal("Expected the issued certificate in the secret")
+	}
+	if _, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey]); err != nil {
+		t.Errorf("Expected the certificate to match the private key, got %v", err)
+	}
+	if issuance := s.getIssuance(); issuance.State != IssuanceSucceeded {
+		t.Errorf("Expected a successful issuance, got %+v", issuance)
+	}
+	if issued := request(); issued.Name != renewed.Name {
+		t.Errorf("Expected no further request for an up to date certificate, got %s", issued.Name)
+	}
+}
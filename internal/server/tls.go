@@ -0,0 +1,191 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/cfg"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/k8s"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/utils"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// servingCertFileCheckInterval throttles checking certificate files for changes on TLS handshakes
+const servingCertFileCheckInterval = 5 * time.Second
+
+// servingCertificate holds the certificate the web server serves, it's reloaded when its source changes.
+type servingCertificate struct {
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	// certPEM and keyPEM are the source of cert, to detect changes
+	certPEM   []byte
+	keyPEM    []byte
+	lastCheck time.Time
+}
+
+// update parses the keypair and replaces the served certificate if the keypair changed.
+func (c *servingCertificate) update(certPEM, keyPEM []byte, source string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if bytes.Equal(certPEM, c.certPEM) && bytes.Equal(keyPEM, c.keyPEM) {
+		return nil
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("failed to parse serving certificate from %s: %v", source, err)
+	}
+	c.cert, c.certPEM, c.keyPEM = &cert, certPEM, keyPEM
+	glog.Infof("Loaded serving certificate from %s", source)
+	return nil
+}
+
+// reloadFiles reloads the keypair from disk, at most once per check interval.
+func (c *servingCertificate) reloadFiles(certPath, keyPath string) error {
+	c.mu.Lock()
+	if time.Since(c.lastCheck) < servingCertFileCheckInterval && c.cert != nil {
+		c.mu.Unlock()
+		return nil
+	}
+	c.lastCheck = time.Now()
+	c.mu.Unlock()
+
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return fmt.Errorf("failed to read serving certificate: %v", err)
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to read serving certificate key: %v", err)
+	}
+	return c.update(certPEM, keyPEM, certPath)
+}
+
+// get returns the served certificate, or an error until one was loaded.
+func (c *servingCertificate) get() (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.cert == nil {
+		return nil, errors.New("no serving certificate loaded yet")
+	}
+	return c.cert, nil
+}
+
+// servingSecret returns the namespace and name of the Secret with the serving certificate.
+func servingSecret(conf cfg.AppConfig) (string, string) {
+	t := conf.TLSServerConfig
+	if t.ManagedSecret {
+		return conf.Certificate.Namespace, conf.Certificate.Spec.SecretName
+	}
+	namespace := t.SecretNamespace
+	if namespace == "" {
+		namespace = conf.Certificate.Namespace
+	}
+	return namespace, t.SecretName
+}
+
+// buildServerTLSConfig returns the TLS configuration of the web server with the configured TLS policy.
+func (s *State) buildServerTLSConfig(conf cfg.AppConfig) (*tls.Config, error) {
+	t := conf.TLSServerConfig
+	minVersion, err := utils.ParseTLSVersion(t.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	cipherSuites, err := utils.ParseCipherSuites(t.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	if len(cipherSuites) == 0 {
+		cipherSuites = nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:   minVersion,
+		CipherSuites: cipherSuites,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if t.CertPath != "" {
+				if err := s.serving.reloadFiles(t.CertPath, t.KeyPath); err != nil {
+					glog.Errorf("Failed to reload serving certificate, keeping the previous one: %v", err)
+				}
+			}
+			return s.serving.get()
+		},
+	}
+
+	if caPath := conf.PeerAuthConfig.ClientCAPath; caPath != "" {
+		caPEM, err := os.ReadFile(caPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %v", err)
+		}
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("failed to parse client CA %s", caPath)
+		}
+		// Client certificates are optional on TLS level, so probes and metrics don't need one, authHandler enforces them
+		tlsConfig.ClientCAs = clientCAs
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsConfig, nil
+}
+
+// authorizedPeerCertificate checks the request carries a verified client certificate matching the SAN or CN allowlists.
+// It returns the name the peer was authorized by.
+func authorizedPeerCertificate(r *http.Request, p cfg.PeerAuthConfig) (string, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	if len(p.AllowedSANs) == 0 && len(p.AllowedCNs) == 0 {
+		return cert.Subject.CommonName, true
+	}
+
+	sans := slices.Concat(cert.DNSNames, cert.EmailAddresses)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	for _, san := range sans {
+		if slices.Contains(p.AllowedSANs, san) {
+			return san, true
+		}
+	}
+	if cert.Subject.CommonName != "" && slices.Contains(p.AllowedCNs, cert.Subject.CommonName) {
+		return cert.Subject.CommonName, true
+	}
+	return "", false
+}
+
+// ServingCertificateLoop continuously reloads the serving certificate from its Secret
+func (s *State) ServingCertificateLoop(ctx context.Context, conf cfg.AppConfig, kc k8s.Client) {
+	t := conf.TLSServerConfig
+	if t.SecretName == "" && !t.ManagedSecret {
+		return
+	}
+	namespace, name := servingSecret(conf)
+	for {
+		s.beat("serving-certificate", time.Duration(conf.KubernetesPollInterval)*time.Second)
+		secret, err := kc.GetSecret(ctx, namespace, name)
+		if err != nil {
+			s.Metrics.K8sPollErrors.WithLabelValues().Inc()
+			glog.Errorf("Failed to fetch serving certificate Secret %s/%s: %v", namespace, name, err)
+		} else if err := s.serving.update(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey], "Secret "+namespace+"/"+name); err != nil {
+			glog.Errorf("Keeping the previous serving certificate: %v", err)
+		}
+
+		if !sleepContext(ctx, time.Duration(conf.KubernetesPollInterval)*time.Second) {
+			glog.V(4).Info("Serving certificate reload stopped")
+			return
+		}
+	}
+}
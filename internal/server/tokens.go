@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/auth"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/cfg"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/k8s"
+)
+
+// tokenSecret returns the namespace and name of the Secret with the token file.
+func tokenSecret(conf cfg.AppConfig) (string, string) {
+	namespace := conf.TokenConfig.SecretNamespace
+	if namespace == "" {
+		namespace = conf.Certificate.Namespace
+	}
+	return namespace, conf.TokenConfig.SecretName
+}
+
+// readTokenFile reads the token file from disk or from its Secret and returns it with a description of its source.
+func readTokenFile(ctx context.Context, conf cfg.AppConfig, kc k8s.Client) ([]byte, string, error) {
+	if conf.TokenConfig.FilePath != "" {
+		data, err := os.ReadFile(conf.TokenConfig.FilePath)
+		if err != nil {
+			return nil, conf.TokenConfig.FilePath, fmt.Errorf("failed to read token file: %v", err)
+		}
+		return data, conf.TokenConfig.FilePath, nil
+	}
+
+	namespace, name := tokenSecret(conf)
+	source := "Secret " + namespace + "/" + name
+	secret, err := kc.GetSecret(ctx, namespace, name)
+	if err != nil {
+		return nil, source, fmt.Errorf("failed to fetch token Secret: %v", err)
+	}
+	data, ok := secret.Data[auth.SecretKey]
+	if !ok {
+		return nil, source, fmt.Errorf("token Secret has no %q key", auth.SecretKey)
+	}
+	return data, source, nil
+}
+
+// LoadTokens loads bearer tokens from the token file or Secret, if configured.
+// Only token fingerprints are logged.
+func (s *State) LoadTokens(ctx context.Context, conf cfg.AppConfig, kc k8s.Client) error {
+	if !conf.TokenConfig.Enabled() {
+		return nil
+	}
+	data, source, err := readTokenFile(ctx, conf, kc)
+	if err != nil {
+		s.Metrics.TokenReloads.WithLabelValues("error").Inc()
+		return err
+	}
+	changed, err := conf.Tokens.Load(data)
+	if err != nil {
+		s.Metrics.TokenReloads.WithLabelValues("error").Inc()
+		return fmt.Errorf("failed to load tokens from %s: %v", source, err)
+	}
+	if changed {
+		s.Metrics.TokenReloads.WithLabelValues("success").Inc()
+		current, accepted := conf.Tokens.Fingerprints()
+		glog.Infof("Loaded bearer tokens from %s: current %s, accepted %v", source, current, accepted)
+	}
+	return nil
+}
+
+// TokenWatchLoop continuously reloads bearer tokens, so they can be rotated without restarts
+func (s *State) TokenWatchLoop(ctx context.Context, conf cfg.AppConfig, kc k8s.Client) {
+	if !conf.TokenConfig.Enabled() {
+		return
+	}
+	interval := time.Duration(conf.TokenConfig.ReloadInterval) * time.Second
+	for {
+		s.beat("token-watch", interval)
+		if !sleepContext(ctx, interval) {
+			glog.V(4).Info("Token watch stopped")
+			return
+		}
+		if err := s.LoadTokens(ctx, conf, kc); err != nil {
+			glog.Errorf("Keeping the previous bearer tokens: %v", err)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/cfg"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/k8s"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/tidb"
+	"github.com/impossiblecloud/pd-cert-assistant/internal/utils"
+)
+
+// Default PD ports used when discovery doesn't report them
+const (
+	pdClientPort = "2379"
+	pdPeerPort   = "2380"
+)
+
+// EndpointStatus holds the result of verifying the certificate served by a PD endpoint.
+type EndpointStatus struct {
+	Endpoint     string    `json:"endpoint"`
+	Type         string    `json:"type"`
+	Covered      bool      `json:"covered"`
+	MissingIPs   []string  `json:"missing_ips"`
+	NotAfter     time.Time `json:"not_after"`
+	DaysToExpiry float64   `json:"days_to_expiry"`
+	LastChecked  time.Time `json:"last_checked"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// pdEndpoint is a PD host:port address and its type (client or peer).
+type pdEndpoint struct {
+	Address string
+	Type    string
+}
+
+// endpointAddress returns host:port from a PD URL, using the default port if it's missing.
+func endpointAddress(rawURL, defaultPort string) (string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if parsed.Host == "" {
+		return "", fmt.Errorf("no host in URL %q", rawURL)
+	}
+	if parsed.Port() == "" {
+		return net.JoinHostPort(parsed.Hostname(), defaultPort), nil
+	}
+	return parsed.Host, nil
+}
+
+// getPDEndpoints returns PD client and peer endpoints reported by PD members or PD discovery.
+func getPDEndpoints(ctx context.Context, conf cfg.AppConfig) ([]pdEndpoint, error) {
+	endpoints := []pdEndpoint{}
+
+	if conf.PDConfig.Address != "" {
+		clientURLs, peerURLs, err := tidb.PDGetMemberURLs(ctx, conf.PDConfig)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range clientURLs {
+			address, err := endpointAddress(u, pdClientPort)
+			if err != nil {
+				glog.Warningf("Skipping invalid PD client URL %q: %v", u, err)
+				continue
+			}
+			endpoints = append(endpoints, pdEndpoint{Address: address, Type: "client"})
+		}
+		for _, u := range peerURLs {
+			address, err := endpointAddress(u, pdPeerPort)
+			if err != nil {
+				glog.Warningf("Skipping invalid PD peer URL %q: %v", u, err)
+				continue
+			}
+			endpoints = append(endpoints, pdEndpoint{Address: address, Type: "peer"})
+		}
+		return endpoints, nil
+	}
+
+	if conf.PDDiscoveryConfig.URL != "" {
+		hosts, err := tidb.PDDiscoveryGetMemberNames(ctx, conf.PDDiscoveryConfig)
+		if err != nil {
+			return nil, err
+		}
+		for _, host := range hosts {
+			endpoints = append(endpoints, pdEndpoint{Address: net.JoinHostPort(host, pdClientPort), Type: "client"})
+			endpoints = append(endpoints, pdEndpoint{Address: net.JoinHostPort(host, pdPeerPort), Type: "peer"})
+		}
+		return endpoints, nil
+	}
+
+	return nil, fmt.Errorf("neither PD address nor PD discovery URL is configured")
+}
+
+// verifyEndpoint TLS-dials the endpoint and checks the presented leaf certificate covers the expected IPs.
+func verifyEndpoint(ctx context.Context, conf cfg.AppConfig, endpoint pdEndpoint, expectedIPs []string) EndpointStatus {
+	status := EndpointStatus{
+		Endpoint:    endpoint.Address,
+		Type:        endpoint.Type,
+		LastChecked: time.Now(),
+	}
+
+	tlsConf := conf.PDConfig.TLSConfig
+	tlsConfig, err := utils.BuildTLSConfig(tlsConf.CertPath, tlsConf.KeyPath, tlsConf.CAPath, tlsConf.Insecure)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	// Hostname verification would hide the SAN mismatch we are looking for, so the chain is verified below
+	roots := tlsConfig.RootCAs
+	tlsConfig.InsecureSkipVerify = true
+
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: time.Duration(conf.PDConfig.HTTPRequestTimeout) * time.Second},
+		Config:    tlsConfig,
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", endpoint.Address)
+	if err != nil {
+		status.Error = fmt.Sprintf("TLS dial failed: %v", err)
+		return status
+	}
+	defer conn.Close()
+
+	peerCerts := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(peerCerts) == 0 {
+		status.Error = "no certificate presented"
+		return status
+	}
+	leaf := peerCerts[0]
+
+	if roots != nil && !tlsConf.Insecure {
+		intermediates := x509.NewCertPool()
+		for _, cert := range peerCerts[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := leaf.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if err != nil {
+			status.Error = fmt.Sprintf("certificate verification failed: %v", err)
+		}
+	}
+
+	servedIPs := []string{}
+	for _, ip := range leaf.IPAddresses {
+		servedIPs = append(servedIPs, ip.String())
+	}
+	status.MissingIPs, _ = utils.DiffLists(expectedIPs, servedIPs)
+	status.Covered = len(status.MissingIPs) == 0
+	status.NotAfter = leaf.NotAfter
+	status.DaysToExpiry = time.Until(leaf.NotAfter).Hours() / 24
+	return status
+}
+
+func (s *State) getEndpoints() []EndpointStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.endpoints
+}
+
+// verifyEndpoints checks certificates served by all PD endpoints and updates metrics and status.
+func (s *State) verifyEndpoints(ctx context.Context, conf cfg.AppConfig) {
+	allIPAddresses := s.AllIPs()
+	if len(allIPAddresses) == 0 {
+		glog.V(4).Info("No IPs fetched from pd-assistants yet, skipping PD endpoint verification")
+		return
+	}
+	expectedIPs := utils.NormalizeIPs(k8s.DesiredIPAddresses(conf, allIPAddresses))
+
+	endpoints, err := getPDEndpoints(ctx, conf)
+	if err != nil {
+		s.Metrics.EndpointVerifyErrors.WithLabelValues("", "").Inc()
+		glog.Errorf("Failed to get PD endpoints for verification: %v", err)
+		return
+	}
+
+	results := []EndpointStatus{}
+	s.Metrics.EndpointCertCovered.Reset()
+	s.Metrics.EndpointCertMissingIPs.Reset()
+	s.Metrics.EndpointCertExpiryDays.Reset()
+	for _, endpoint := range endpoints {
+		status := verifyEndpoint(ctx, conf, endpoint, expectedIPs)
+		results = append(results, status)
+
+		if status.Error != "" {
+			s.Metrics.EndpointVerifyErrors.WithLabelValues(status.Endpoint, status.Type).Inc()
+			glog.Errorf("Failed to verify PD %s endpoint %s: %s", status.Type, status.Endpoint, status.Error)
+		}
+		if status.NotAfter.IsZero() {
+			continue
+		}
+		covered := 0.0
+		if status.Covered {
+			covered = 1
+		} else {
+			glog.Warningf("PD %s endpoint %s serves a certificate missing IPs: %v", status.Type, status.Endpoint, status.MissingIPs)
+		}
+		s.Metrics.EndpointCertCovered.WithLabelValues(status.Endpoint, status.Type).Set(covered)
+		s.Metrics.EndpointCertMissingIPs.WithLabelValues(status.Endpoint, status.Type).Set(float64(len(status.MissingIPs)))
+		s.Metrics.EndpointCertExpiryDays.WithLabelValues(status.Endpoint, status.Type).Set(status.DaysToExpiry)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.endpoints = results
+}
+
+// EndpointVerifyLoop continuously verifies certificates served by PD endpoints
+func (s *State) EndpointVerifyLoop(ctx context.Context, conf cfg.AppConfig) {
+	if conf.EndpointVerifyInterval <= 0 {
+		glog.V(4).Info("PD endpoint verification is disabled")
+		return
+	}
+	for {
+		s.beat("endpoint-verify", time.Duration(conf.EndpointVerifyInterval)*time.Second)
+		// Sleep before iteration, there is nothing to compare with before pd-assistants were polled
+		if !sleepContext(ctx, time.Duration(conf.EndpointVerifyInterval)*time.Second) {
+			glog.V(4).Info("PD endpoint verification stopped")
+			return
+		}
+
+		conf.IPOverrides = s.activeIPOverrides(time.Now())
+		s.verifyEndpoints(ctx, conf)
+	}
+}
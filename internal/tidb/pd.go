@@ -1,6 +1,7 @@
 package tidb
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -21,14 +22,19 @@ func encodePDDiscoveryPath(conf cfg.PDDiscoveryConfig) string {
 	return strings.ReplaceAll(encoded, "\n", "")
 }
 
-// PDGetMemberNames fetches a list of members from a PD server and returns their names.
-func PDGetMemberNames(conf cfg.PDConfig) ([]string, error) {
+// pdURL builds a PD API URL for the given path, using https if a CA is configured.
+func pdURL(conf cfg.PDConfig, path string) string {
 	pdScheme := "http://"
 	if conf.TLSConfig.CAPath != "" {
 		pdScheme = "https://"
 	}
-	pdAddress := pdScheme + conf.Address + "/pd/api/v1/members"
-	resp, err := utils.MakeHTTPRequest(pdAddress, conf.TLSConfig.CertPath, conf.TLSConfig.KeyPath, conf.TLSConfig.CAPath, conf.TLSConfig.Insecure, conf.HTTPRequestTimeout, "")
+	return pdScheme + conf.Address + path
+}
+
+// PDGetMemberNames fetches a list of members from a PD server and returns their names.
+func PDGetMemberNames(ctx context.Context, conf cfg.PDConfig) ([]string, error) {
+	pdAddress := pdURL(conf, "/pd/api/v1/members")
+	resp, err := utils.MakeHTTPRequest(ctx, pdAddress, conf.TLSConfig.CertPath, conf.TLSConfig.KeyPath, conf.TLSConfig.CAPath, conf.TLSConfig.Insecure, conf.HTTPRequestTimeout, "")
 	// Check if the request was successful
 	if err != nil {
 		return nil, fmt.Errorf("failed to make HTTP request to %q: %v", pdAddress, err)
@@ -66,11 +72,82 @@ func PDGetMemberNames(conf cfg.PDConfig) ([]string, error) {
 	return names, nil
 }
 
+// PDGetMemberURLs fetches a list of members from a PD server and returns their client and peer URLs.
+func PDGetMemberURLs(ctx context.Context, conf cfg.PDConfig) ([]string, []string, error) {
+	pdAddress := pdURL(conf, "/pd/api/v1/members")
+	resp, err := utils.MakeHTTPRequest(ctx, pdAddress, conf.TLSConfig.CertPath, conf.TLSConfig.KeyPath, conf.TLSConfig.CAPath, conf.TLSConfig.Insecure, conf.HTTPRequestTimeout, "")
+	// Check if the request was successful
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to make HTTP request to %q: %v", pdAddress, err)
+	}
+	defer resp.Body.Close()
+
+	// Check if the response status is OK
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("received non-OK HTTP status: %s", resp.Status)
+	}
+
+	// Parse the JSON response
+	var data struct {
+		Members []struct {
+			ClientURLs []string `json:"client_urls"`
+			PeerURLs   []string `json:"peer_urls"`
+		} `json:"members"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode JSON response: %v", err)
+	}
+
+	clientURLs := []string{}
+	peerURLs := []string{}
+	for _, member := range data.Members {
+		clientURLs = append(clientURLs, member.ClientURLs...)
+		peerURLs = append(peerURLs, member.PeerURLs...)
+	}
+	return clientURLs, peerURLs, nil
+}
+
+// PDGetUnhealthyMembers fetches PD cluster health and returns the names of unhealthy members.
+func PDGetUnhealthyMembers(ctx context.Context, conf cfg.PDConfig) ([]string, error) {
+	pdAddress := pdURL(conf, "/pd/api/v1/health")
+	resp, err := utils.MakeHTTPRequest(ctx, pdAddress, conf.TLSConfig.CertPath, conf.TLSConfig.KeyPath, conf.TLSConfig.CAPath, conf.TLSConfig.Insecure, conf.HTTPRequestTimeout, "")
+	// Check if the request was successful
+	if err != nil {
+		return nil, fmt.Errorf("failed to make HTTP request to %q: %v", pdAddress, err)
+	}
+	defer resp.Body.Close()
+
+	// Check if the response status is OK
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("received non-OK HTTP status: %s", resp.Status)
+	}
+
+	// Parse the JSON response
+	var members []struct {
+		Name   string `json:"name"`
+		Health bool   `json:"health"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&members); err != nil {
+		return nil, fmt.Errorf("failed to decode JSON response: %v", err)
+	}
+	if len(members) == 0 {
+		return nil, errors.New("PD health response contains no members")
+	}
+
+	unhealthy := []string{}
+	for _, member := range members {
+		if !member.Health {
+			unhealthy = append(unhealthy, member.Name)
+		}
+	}
+	return unhealthy, nil
+}
+
 // PDDiscoveryGetMemberNames fetches a list of members from a PD discovery service and returns their names.
-func PDDiscoveryGetMemberNames(conf cfg.PDDiscoveryConfig) ([]string, error) {
+func PDDiscoveryGetMemberNames(ctx context.Context, conf cfg.PDDiscoveryConfig) ([]string, error) {
 	pdDiscoveryPath := encodePDDiscoveryPath(conf)
 	pdDiscoveryURL := fmt.Sprintf("%s/new/%s", conf.URL, pdDiscoveryPath)
-	resp, err := utils.MakeHTTPRequest(pdDiscoveryURL, conf.TLSConfig.CertPath, conf.TLSConfig.KeyPath, conf.TLSConfig.CAPath, conf.TLSConfig.Insecure, conf.HTTPRequestTimeout, "")
+	resp, err := utils.MakeHTTPRequest(ctx, pdDiscoveryURL, conf.TLSConfig.CertPath, conf.TLSConfig.KeyPath, conf.TLSConfig.CAPath, conf.TLSConfig.Insecure, conf.HTTPRequestTimeout, "")
 	// Check if the request was successful
 	if err != nil {
 		return nil, fmt.Errorf("failed to make HTTP request to %q: %v", pdDiscoveryURL, err)
@@ -130,8 +207,8 @@ func BuildPDAssistantHostnames(conf cfg.AppConfig, hosts []string) []string {
 }
 
 // GetPDAssistantURLs retrieves PD Assistant hostnames based on the PD member names and generates a list of URLs.
-func GetPDAssistantURLs(conf cfg.AppConfig) ([]string, error) {
-	pdNames, err := PDDiscoveryGetMemberNames(conf.PDDiscoveryConfig)
+func GetPDAssistantURLs(ctx context.Context, conf cfg.AppConfig) ([]string, error) {
+	pdNames, err := PDDiscoveryGetMemberNames(ctx, conf.PDDiscoveryConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get PD member names: %v", err)
 	}
@@ -150,8 +227,8 @@ func GetPDAssistantURLs(conf cfg.AppConfig) ([]string, error) {
 }
 
 // GetPDDAssistantURLs retrieves PD Assistant hostnames based on the PD member names from discovery service and generates a list of URLs.
-func GetPDDiscoveryAssistantURLs(conf cfg.AppConfig) ([]string, error) {
-	pdNames, err := PDDiscoveryGetMemberNames(conf.PDDiscoveryConfig)
+func GetPDDiscoveryAssistantURLs(ctx context.Context, conf cfg.AppConfig) ([]string, error) {
+	pdNames, err := PDDiscoveryGetMemberNames(ctx, conf.PDDiscoveryConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get PD member names: %v", err)
 	}
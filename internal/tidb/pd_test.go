@@ -1,6 +1,7 @@
 package tidb
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -39,7 +40,7 @@ func TestPDGetMemberNames(t *testing.T) {
 	}
 
 	// Call the function
-	names, err := PDGetMemberNames(conf)
+	names, err := PDGetMemberNames(context.Background(), conf)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -90,3 +91,71 @@ func TestBuildPDAssistantHostnames(t *testing.T) {
 		}
 	}
 }
+
+// TestPDGetUnhealthyMembers tests the PDGetUnhealthyMembers function.
+func TestPDGetUnhealthyMembers(t *testing.T) {
+	// Mock PD health response
+	mockResponse := `[
+        {"name": "pd-0", "member_id": 1, "client_urls": ["http://pd-0:2379"], "health": true},
+        {"name": "pd-1", "member_id": 2, "client_urls": ["http://pd-1:2379"], "health": false},
+        {"name": "pd-2", "member_id": 3, "client_urls": ["http://pd-2:2379"], "health": true}
+    ]`
+
+	// Create a mock HTTP server
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/pd/api/v1/health" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(mockResponse))
+	}))
+	defer server.Close()
+
+	conf := cfg.PDConfig{
+		Address:            server.URL[len("http://"):], // Remove "http://" prefix
+		HTTPRequestTimeout: 5,
+	}
+
+	unhealthy, err := PDGetUnhealthyMembers(context.Background(), conf)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(unhealthy) != 1 || unhealthy[0] != "pd-1" {
+		t.Errorf("Expected unhealthy members [pd-1], got %v", unhealthy)
+	}
+}
+
+// TestPDGetMemberURLs tests the PDGetMemberURLs function.
+func TestPDGetMemberURLs(t *testing.T) {
+	// Mock PD server response
+	mockResponse := `{
+        "members": [
+            {"name": "pd-0", "client_urls": ["https://pd-0.pd-peer:2379"], "peer_urls": ["https://pd-0.pd-peer:2380"]},
+            {"name": "pd-1", "client_urls": ["https://pd-1.pd-peer:2379"], "peer_urls": ["https://pd-1.pd-peer:2380"]}
+        ]
+    }`
+
+	// Create a mock HTTP server
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(mockResponse))
+	}))
+	defer server.Close()
+
+	conf := cfg.PDConfig{
+		Address:            server.URL[len("http://"):], // Remove "http://" prefix
+		HTTPRequestTimeout: 5,
+	}
+
+	clientURLs, peerURLs, err := PDGetMemberURLs(context.Background(), conf)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(clientURLs) != 2 || clientURLs[1] != "https://pd-1.pd-peer:2379" {
+		t.Errorf("Unexpected client URLs: %v", clientURLs)
+	}
+	if len(peerURLs) != 2 || peerURLs[0] != "https://pd-0.pd-peer:2380" {
+		t.Errorf("Unexpected peer URLs: %v", peerURLs)
+	}
+}
@@ -1,11 +1,15 @@
 package utils
 
 import (
+	"context"
+	"crypto/ed25519"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"regexp"
@@ -14,43 +18,55 @@ import (
 	"time"
 )
 
-// MakeHTTPRequest makes an HTTP(S) request to the specified URL.
+// MakeHTTPRequest makes an HTTP(S) GET request to the specified URL.
 // It returns the HTTP response or an error if the request fails.
-func MakeHTTPRequest(url, certPath, keyPath, caPath string, insecure bool, timeout int, bearerToken string) (*http.Response, error) {
-	var client *http.Client
-	var tlsConfig *tls.Config
-	var cert tls.Certificate
-	var caCertPool *x509.CertPool
-	var caCert []byte
-	var err error
+func MakeHTTPRequest(ctx context.Context, url, certPath, keyPath, caPath string, insecure bool, timeout int, bearerToken string) (*http.Response, error) {
+	return MakeHTTPRequestWithMethod(ctx, "GET", url, nil, certPath, keyPath, caPath, insecure, timeout, bearerToken)
+}
 
-	if strings.HasPrefix(url, "https:") {
-		if certPath != "" && keyPath != "" {
-			// Load the client certificate
-			cert, err = tls.LoadX509KeyPair(certPath, keyPath)
-			if err != nil {
-				return nil, fmt.Errorf("could not load client certificate: %v", err)
-			}
+// BuildTLSConfig creates a TLS client configuration from the provided certificate, key and CA paths.
+// The client certificate is only loaded if both certificate and key paths are set.
+// The CA verifies the server as RootCAs, ClientCAs would only be used by a server and clients would fall back to system roots.
+func BuildTLSConfig(certPath, keyPath, caPath string, insecure bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: insecure, // Set to true to skip server verification (not recommended)
+	}
+
+	if certPath != "" && keyPath != "" {
+		// Load the client certificate
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if caPath != "" {
+		// Create a CA certificate pool and add the CA certificate
+		caCert, err := os.ReadFile(caPath)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA certificate: %v", err)
 		}
 
-		if caPath != "" {
-			// Create a CA certificate pool and add the CA certificate
-			caCert, err = os.ReadFile(caPath)
-			if err != nil {
-				return nil, fmt.Errorf("could not read CA certificate: %v", err)
-			}
-
-			caCertPool = x509.NewCertPool()
-			if ok := caCertPool.AppendCertsFromPEM(caCert); !ok {
-				return nil, fmt.Errorf("could not append CA certificate to pool")
-			}
-
-			// Create the TLS configuration with the client certificate and CA pool
-			tlsConfig = &tls.Config{
-				Certificates:       []tls.Certificate{cert},
-				ClientCAs:          caCertPool,
-				InsecureSkipVerify: insecure, // Set to true to skip server verification (not recommended)
-			}
+		caCertPool := x509.NewCertPool()
+		if ok := caCertPool.AppendCertsFromPEM(caCert); !ok {
+			return nil, fmt.Errorf("could not append CA certificate to pool")
+		}
+		tlsConfig.RootCAs = caCertPool
+	}
+
+	return tlsConfig, nil
+}
+
+// MakeHTTPRequestWithMethod makes an HTTP(S) request with the given method and body to the specified URL.
+// It returns the HTTP response or an error if the request fails.
+func MakeHTTPRequestWithMethod(ctx context.Context, method, url string, body io.Reader, certPath, keyPath, caPath string, insecure bool, timeout int, bearerToken string) (*http.Response, error) {
+	var client *http.Client
+
+	if strings.HasPrefix(url, "https:") {
+		tlsConfig, err := BuildTLSConfig(certPath, keyPath, caPath, insecure)
+		if err != nil {
+			return nil, err
 		}
 
 		// Create the custom transport
@@ -64,14 +80,14 @@ func MakeHTTPRequest(url, certPath, keyPath, caPath string, insecure bool, timeo
 			Timeout:   time.Duration(timeout) * time.Second,
 		}
 	} else {
-		// Use a default HTTP client if no CA path is provided
+		// Use a default HTTP client for plain HTTP
 		client = &http.Client{
 			Timeout: time.Duration(timeout) * time.Second,
 		}
 	}
 
 	// Create the HTTP request
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
 		return nil, fmt.Errorf("could not create HTTP request: %v", err)
 	}
@@ -80,6 +96,9 @@ func MakeHTTPRequest(url, certPath, keyPath, caPath string, insecure bool, timeo
 	if bearerToken != "" {
 		req.Header.Set("Authorization", "Bearer "+bearerToken)
 	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
 	// Make the HTTP or HTTPS request
 	resp, err := client.Do(req)
@@ -139,11 +158,14 @@ func ParseJSONResponse(body io.Reader, target interface{}) error {
 	return decoder.Decode(target)
 }
 
-// IPListsEqual checks if two slices of IPs are equal (uses sort to ensure order doesn't matter).
+// IPListsEqual checks if two slices of IPs are equal (sorts copies to ensure order doesn't matter).
+// The provided slices are not modified.
 func IPListsEqual(a, b []string) bool {
 	if len(a) != len(b) {
 		return false
 	}
+	a = slices.Clone(a)
+	b = slices.Clone(b)
 	slices.Sort(a)
 	slices.Sort(b)
 	for i := range a {
@@ -175,3 +197,105 @@ func FindUniqueURLs(text string) []string {
 
 	return result
 }
+
+// ParseCertificatePEM parses the first PEM encoded certificate (the leaf) from the provided data.
+func ParseCertificatePEM(data []byte) (*x509.Certificate, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no PEM encoded certificate found")
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
+
+// ParseEd25519PrivateKeyPEM parses a PEM encoded PKCS #8 Ed25519 private key, as written by `openssl genpkey -algorithm ed25519`.
+func ParseEd25519PrivateKeyPEM(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("no PEM encoded private key found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %v", err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is %T, not Ed25519", key)
+	}
+	return edKey, nil
+}
+
+// ParseEd25519PublicKeyPEM parses a PEM encoded PKIX Ed25519 public key, as written by `openssl pkey -pubout`.
+func ParseEd25519PublicKeyPEM(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("no PEM encoded public key found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %v", err)
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is %T, not Ed25519", key)
+	}
+	return edKey, nil
+}
+
+// DiffLists compares the desired and actual lists and returns the items missing from actual and the extra items in actual.
+func DiffLists(desired, actual []string) (missing, extra []string) {
+	missing = []string{}
+	extra = []string{}
+	for _, item := range desired {
+		if !Contains(actual, item) && !Contains(missing, item) {
+			missing = append(missing, item)
+		}
+	}
+	for _, item := range actual {
+		if !Contains(desired, item) && !Contains(extra, item) {
+			extra = append(extra, item)
+		}
+	}
+	return missing, extra
+}
+
+// NormalizeIPs returns a copy of the IP list with every parseable IP in its canonical form.
+func NormalizeIPs(ips []string) []string {
+	result := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		if parsed := net.ParseIP(ip); parsed != nil {
+			ip = parsed.String()
+		}
+		result = append(result, ip)
+	}
+	return result
+}
+
+// ParseTLSVersion parses a TLS version like "1.2" or "1.3".
+func ParseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version %q, use 1.2 or 1.3", version)
+}
+
+// ParseCipherSuites parses cipher suite names as returned by tls.CipherSuiteName.
+// Only cipher suites without known security issues are accepted.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	ids := []uint16{}
+	for _, name := range names {
+		idx := slices.IndexFunc(tls.CipherSuites(), func(suite *tls.CipherSuite) bool { return suite.Name == name })
+		if idx < 0 {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, tls.CipherSuites()[idx].ID)
+	}
+	return ids, nil
+}
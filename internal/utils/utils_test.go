@@ -1,7 +1,24 @@
 package utils
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
 	"testing"
+	"time"
 )
 
 // TestParseCommaSeparatedLine tests the ParseCommaSeparatedLine function.
@@ -59,7 +76,7 @@ func TestParseCommaSeparatedLineWithSpecialCharacters(t *testing.T) {
 
 // TestMakeHTTPSRequest tests the MakeHTTPSRequest function.
 func TestMakeHTTPRequestWithInvalidURL(t *testing.T) {
-	_, err := MakeHTTPRequest(":", "", "", "", false, 2, "")
+	_, err := MakeHTTPRequest(context.Background(), ":", "", "", "", false, 2, "")
 	if err == nil {
 		t.Errorf("Expected error due to invalid URL, got nil")
 	}
@@ -67,7 +84,7 @@ func TestMakeHTTPRequestWithInvalidURL(t *testing.T) {
 
 func TestMakeHTTPRequestWithoutCerts(t *testing.T) {
 	// Test with a valid URL but without certificates
-	_, err := MakeHTTPRequest("https://example.com", "", "", "", false, 2, "")
+	_, err := MakeHTTPRequest(context.Background(), "https://example.com", "", "", "", false, 2, "")
 	if err != nil {
 		t.Errorf("Expected no error for request without certificates, got: %v", err)
 	}
@@ -75,12 +92,36 @@ func TestMakeHTTPRequestWithoutCerts(t *testing.T) {
 
 func TestMakeHTTPRequestWithInsecureSkipVerify(t *testing.T) {
 	// Test with insecure skip verify set to true
-	_, err := MakeHTTPRequest("https://example.com", "", "", "", true, 2, "")
+	_, err := MakeHTTPRequest(context.Background(), "https://example.com", "", "", "", true, 2, "")
 	if err != nil {
 		t.Errorf("Expected no error for insecure request, got: %v", err)
 	}
 }
 
+func TestMakeHTTPRequestWithCA(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	caPath := filepath.Join(t.TempDir(), "ca.crt")
+	if err := os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The CA verifies the server, without it the system roots don't know the server certificate
+	resp, err := MakeHTTPRequest(context.Background(), server.URL, "", "", caPath, false, 2, "")
+	if err != nil {
+		t.Fatalf("Expected the server to be verified with the CA, got: %v", err)
+	}
+	resp.Body.Close()
+	if _, err := MakeHTTPRequest(context.Background(), server.URL, "", "", "", false, 2, ""); err == nil {
+		t.Error("Expected the server to be refused without the CA")
+	}
+	resp, err = MakeHTTPRequest(context.Background(), server.URL, "", "", "", true, 2, "")
+	if err != nil {
+		t.Fatalf("Expected an insecure request without the CA to succeed, got: %v", err)
+	}
+	resp.Body.Close()
+}
+
 // TestContains tests the Contains function.
 func TestContains(t *testing.T) {
 	tests := []struct {
@@ -247,3 +288,126 @@ func TestGetHostFromURL(t *testing.T) {
 		}
 	}
 }
+
+// TestDiffLists tests the DiffLists function.
+func TestDiffLists(t *testing.T) {
+	tests := []struct {
+		desired, actual []string
+		missing, extra  []string
+	}{
+		{[]string{"10.0.0.1", "10.0.0.2"}, []string{"10.0.0.2", "10.0.0.1"}, []string{}, []string{}},
+		{[]string{"10.0.0.1", "10.0.0.2"}, []string{"10.0.0.1"}, []string{"10.0.0.2"}, []string{}},
+		{[]string{"10.0.0.1"}, []string{"10.0.0.1", "10.0.0.3"}, []string{}, []string{"10.0.0.3"}},
+		{[]string{"10.0.0.1", "10.0.0.1"}, []string{}, []string{"10.0.0.1"}, []string{}},
+		{[]string{}, []string{}, []string{}, []string{}},
+	}
+
+	for _, test := range tests {
+		missing, extra := DiffLists(test.desired, test.actual)
+		if !slices.Equal(missing, test.missing) || !slices.Equal(extra, test.extra) {
+			t.Errorf("For lists %v and %v, expected missing %v and extra %v, got %v and %v", test.desired, test.actual, test.missing, test.extra, missing, extra)
+		}
+	}
+}
+
+// TestParseCertificatePEM tests the ParseCertificatePEM function.
+func TestParseCertificatePEM(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "tidb-cluster"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("10.0.0.1")},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: []byte("junk")})
+	data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+
+	cert, err := ParseCertificatePEM(data)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cert.Subject.CommonName != "tidb-cluster" {
+		t.Errorf("Expected common name tidb-cluster, got %s", cert.Subject.CommonName)
+	}
+	if len(cert.IPAddresses) != 1 || cert.IPAddresses[0].String() != "10.0.0.1" {
+		t.Errorf("Expected IP addresses [10.0.0.1], got %v", cert.IPAddresses)
+	}
+
+	if _, err := ParseCertificatePEM([]byte("not a certificate")); err == nil {
+		t.Errorf("Expected error for invalid PEM data, got nil")
+	}
+}
+
+// TestNormalizeIPs tests the NormalizeIPs function.
+func TestNormalizeIPs(t *testing.T) {
+	result := NormalizeIPs([]string{"10.0.0.1", "2001:DB8::1", "not-an-ip"})
+	expected := []string{"10.0.0.1", "2001:db8::1", "not-an-ip"}
+	if !slices.Equal(result, expected) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+}
+
+func TestIPListsEqualDoesNotModifyArguments(t *testing.T) {
+	a := []string{"10.0.0.2", "10.0.0.1"}
+	b := []string{"10.0.0.1", "10.0.0.2"}
+	if !IPListsEqual(a, b) {
+		t.Errorf("Expected lists %v and %v to be equal", a, b)
+	}
+	if a[0] != "10.0.0.2" || a[1] != "10.0.0.1" {
+		t.Errorf("Expected list to stay unsorted, got %v", a)
+	}
+}
+
+func TestParseTLSPolicy(t *testing.T) {
+	if version, err := ParseTLSVersion("1.3"); err != nil || version != tls.VersionTLS13 {
+		t.Errorf("Expected TLS 1.3, got %v, %v", version, err)
+	}
+	if _, err := ParseTLSVersion("1.0"); err == nil {
+		t.Errorf("Expected TLS 1.0 to be rejected")
+	}
+
+	suites, err := ParseCipherSuites([]string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"})
+	if err != nil || len(suites) != 1 || suites[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("Expected a single cipher suite, got %v, %v", suites, err)
+	}
+	if _, err := ParseCipherSuites([]string{"TLS_RSA_WITH_RC4_128_SHA"}); err == nil {
+		t.Errorf("Expected an insecure cipher suite to be rejected")
+	}
+}
+
+func TestParseEd25519KeysPEM(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateDER, _ := x509.MarshalPKCS8PrivateKey(private)
+	publicDER, _ := x509.MarshalPKIXPublicKey(public)
+
+	parsedPrivate, err := ParseEd25519PrivateKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}))
+	if err != nil || !parsedPrivate.Equal(private) {
+		t.Errorf("Expected to parse the private key, got %v", err)
+	}
+	parsedPublic, err := ParseEd25519PublicKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))
+	if err != nil || !parsedPublic.Equal(public) {
+		t.Errorf("Expected to parse the public key, got %v", err)
+	}
+
+	// Other key types and PEM blocks are refused
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecDER, _ := x509.MarshalPKCS8PrivateKey(ecKey)
+	if _, err := ParseEd25519PrivateKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: ecDER})); err == nil {
+		t.Error("Expected an ECDSA private key to be refused")
+	}
+	if _, err := ParseEd25519PublicKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER})); err == nil {
+		t.Error("Expected a private key PEM block to be refused as public key")
+	}
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 
 	"github.com/golang/glog"
 
@@ -17,8 +23,9 @@ import (
 var Version string
 
 func main() {
-	var listen, kubeconfig, pdAssistantURLs, certFilePath string
+	var configFile string
 	var showVersion bool
+	var validateSkipSecrets bool
 
 	if Version == "" {
 		Version = "unknown"
@@ -32,27 +39,107 @@ func main() {
 	// Init metric
 	srv.Metrics = metrics.InitMetrics(Version)
 
+	hostname, _ := os.Hostname()
+
 	// General parameters
-	flag.StringVar(&listen, "listen", ":8765", "Address:port to listen on")
+	flag.StringVar(&configFile, "config", "", "Path to a YAML config file, PDCA_* environment variables override it and flags override both. PDCA_CONFIG if empty")
+	flag.StringVar(&config.Listen, "listen", ":8765", "Address:port to listen on")
 	flag.BoolVar(&showVersion, "version", false, "Show version and exit")
+	flag.BoolVar(&validateSkipSecrets, "validate-skip-secrets", false, "Skip checks of secrets like the bearer token in the validate command, e.g. when validating manifests in CI")
+	flag.StringVar(&config.TLSServerConfig.CertPath, "tls-cert", "", "Path to the certificate for serving the API over TLS, reloaded when it changes")
+	flag.StringVar(&config.TLSServerConfig.KeyPath, "tls-key", "", "Path to the private key for serving the API over TLS, reloaded when it changes")
+	flag.StringVar(&config.TLSServerConfig.SecretName, "tls-secret-name", "", "Name of a kubernetes.io/tls Secret with the certificate for serving the API over TLS, alternatively to --tls-cert")
+	flag.StringVar(&config.TLSServerConfig.SecretNamespace, "tls-secret-namespace", "", "Namespace of the serving certificate Secret, defaults to the certificate namespace")
+	flag.BoolVar(&config.TLSServerConfig.ManagedSecret, "tls-managed-secret", false, "Serve the API over TLS with the certificate Secret managed by this assistant, TLS handshakes fail until the Secret exists")
+	flag.StringVar(&config.TLSServerConfig.MinVersion, "tls-min-version", "1.2", "Minimal TLS version for serving the API: 1.2 or 1.3")
+	flag.Var(cfg.ListValue{List: &config.TLSServerConfig.CipherSuites}, "tls-cipher-suites", "Allowed TLS 1.2 cipher suites for serving the API (comma-separated), Go defaults if empty")
+	flag.IntVar(&config.StaleIntervals, "stale-intervals", 3, "Number of missed poll intervals after which local IPs are stale for readiness and loops are stuck for liveness")
+	flag.IntVar(&config.ShutdownTimeout, "shutdown-timeout", 30, "Time to wait for in-flight HTTP requests on shutdown, in seconds")
+	flag.IntVar(&config.HTTPRequestTimeout, "http-request-timeout", 5, "Timeout of HTTP requests to PD Assistant instances, in seconds")
 	// Kubernetes parameters
-	flag.StringVar(&kubeconfig, "kubeconfig", "", "Path to the kubeconfig file (optional)")
+	flag.StringVar(&config.KubeConfigPath, "kubeconfig", "", "Path to the kubeconfig file (optional)")
 	flag.IntVar(&config.KubernetesPollInterval, "k8s-poll-interval", 60, "Interval for polling Kubernetes in seconds")
 	// PD assistant parameters
-	flag.IntVar(&config.PDAssistantPollInterval, "pd-assistant-poll-interval", 120, "Interval for polling all pd-assistants and checking/updating certificate, in seconds")
+	flag.IntVar(&config.PDAssistantPollInterval, "pd-assistant-poll-interval", 120, "Interval for polling all pd-assistants and checking/updating certificate when nothing triggered it earlier, in seconds")
+	flag.IntVar(&config.ReconcileConfig.MinInterval, "reconcile-min-interval", 10, "Minimum interval between two certificate reconcile runs, in seconds")
+	flag.IntVar(&config.ReconcileConfig.Jitter, "reconcile-jitter", 5, "Maximum random delay added to triggered certificate reconcile runs, in seconds")
+	flag.IntVar(&config.ReconcileConfig.MaxRemovedIPs, "max-removed-ips", 0, "Maximum number of IPs a certificate update may remove without approval through the admin API, 0 disables approvals")
+	flag.BoolVar(&config.NotifyConfig.Enabled, "notify-peers", false, "Notify all pd-assistants when the local IP set changes so they reconcile early, enable once all pd-assistants support notifications")
+	flag.StringVar(&config.NotifyConfig.Source, "notify-source", hostname, "Identity of this pd-assistant in notifications sent to peers")
+	flag.IntVar(&config.NotifyConfig.MinInterval, "notify-min-interval", 5, "Minimum interval between two accepted notifications from the same pd-assistant, later ones are deferred to its end, in seconds")
+	flag.BoolVar(&config.GossipConfig.Enabled, "gossip", false, "Discover pd-assistants by gossiping membership, --pd-assistant-urls or --pd-discovery-url only provide seeds")
+	flag.StringVar(&config.GossipConfig.AdvertiseURL, "pd-assistant-advertise-url", "", "URL other pd-assistants use to reach this one in gossip membership")
+	flag.IntVar(&config.GossipConfig.Interval, "gossip-interval", 5, "Interval between two gossip rounds, in seconds")
+	flag.IntVar(&config.GossipConfig.Fanout, "gossip-fanout", 3, "Number of pd-assistants contacted in every gossip round")
+	flag.IntVar(&config.GossipConfig.SuspectTimeout, "gossip-suspect-timeout", 30, "Time without heartbeat after which a pd-assistant is suspect, in seconds")
+	flag.IntVar(&config.GossipConfig.DeadTimeout, "gossip-dead-timeout", 120, "Time without heartbeat after which a pd-assistant is dead, in seconds")
+	flag.BoolVar(&config.GossipConfig.DropDeadMembers, "gossip-drop-dead-members", false, "Leave dead pd-assistants out, dropping their IPs from the certificate, instead of failing reconciles until they are back")
 	flag.StringVar(&config.PDAssistantHostPrefix, "pd-assistant-host-prefix", "pd-assistant", "Host prefix for PD Assistant instances")
 	flag.StringVar(&config.PDAssistantScheme, "pd-assistant-scheme", "https", "Scheme for PD Assistant instances (http or https)")
 	flag.StringVar(&config.PDAssistantPort, "pd-assistant-port", "443", "Port for PD Assistant instances")
 	flag.BoolVar(&config.PDAssistantTLSInsecure, "pd-assistant-tls-insecure", false, "Skip TLS verification for PD Assistant instances (not recommended)")
-	flag.StringVar(&pdAssistantURLs, "pd-assistant-urls", "", "List of PD Assistant URLs (comma-separated). Overrides --pd-assistant-host-prefix and ignores --pd-address auto-discovery if provided")
+	flag.StringVar(&config.PeerAuthConfig.TLSConfig.CertPath, "pd-assistant-tls-cert", "", "Path to the client certificate presented to PD Assistant instances")
+	flag.StringVar(&config.PeerAuthConfig.TLSConfig.KeyPath, "pd-assistant-tls-key", "", "Path to the client key presented to PD Assistant instances")
+	flag.StringVar(&config.PeerAuthConfig.TLSConfig.CAPath, "pd-assistant-tls-ca", "", "Path to the CA certificate verifying PD Assistant instances, system CAs if empty")
+	flag.StringVar(&config.SigningConfig.ClusterID, "cluster-id", "", "Identity of this cluster in signed local IPs served to PD Assistant instances")
+	flag.StringVar(&config.SigningConfig.KeyPath, "signing-key", "", "Path to a PEM encoded Ed25519 private key signing local IPs served on /api/v2/ips")
+	flag.StringVar(&config.SigningConfig.KeyringPath, "peer-keyring", "", "Path to a YAML keyring with Ed25519 public keys of PD Assistant clusters and the cluster of every PD Assistant URL, local IPs of PD Assistant instances are fetched signed and verified if set")
+	flag.BoolVar(&config.SigningConfig.Strict, "peer-signatures-strict", false, "Refuse unsigned, unknown or expired local IPs of PD Assistant instances, otherwise they are accepted with a warning")
+	flag.IntVar(&config.SigningConfig.MaxAge, "peer-signature-max-age", 300, "Maximal age of signed local IPs of PD Assistant instances, in seconds")
+	flag.StringVar(&config.TokenConfig.FilePath, "token-file", "", "Path to a YAML file with the current bearer token and accepted tokens, watched for changes. Replaces BEARER_TOKEN")
+	flag.StringVar(&config.TokenConfig.SecretName, "token-secret-name", "", "Name of a Secret with the bearer token file in the tokens.yaml key, watched for changes, alternatively to --token-file")
+	flag.StringVar(&config.TokenConfig.SecretNamespace, "token-secret-namespace", "", "Namespace of the bearer token Secret, defaults to the certificate namespace")
+	flag.IntVar(&config.TokenConfig.ReloadInterval, "token-reload-interval", 30, "Interval for checking the bearer token file or Secret for changes, in seconds")
+	flag.StringVar(&config.IPOverridesConfig.FilePath, "ip-overrides-file", "", "Path to a YAML file with manually pinned and excluded certificate IPs, watched for changes")
+	flag.StringVar(&config.IPOverridesConfig.ConfigMapName, "ip-overrides-configmap-name", "", "Name of a ConfigMap with the IP overrides file in the overrides.yaml key, watched for changes, alternatively to --ip-overrides-file")
+	flag.StringVar(&config.IPOverridesConfig.ConfigMapNamespace, "ip-overrides-configmap-namespace", "", "Namespace of the IP overrides ConfigMap, defaults to the certificate namespace")
+	flag.IntVar(&config.IPOverridesConfig.ReloadInterval, "ip-overrides-reload-interval", 30, "Interval for checking the IP overrides file or ConfigMap for changes and expired overrides, in seconds")
+	flag.StringVar(&config.PeerAuthConfig.Mode, "peer-auth", cfg.PeerAuthBearer, "How PD Assistant instances authenticate to this one: bearer, mtls, bearer-and-mtls or bearer-or-mtls")
+	flag.StringVar(&config.PeerAuthConfig.ClientCAPath, "peer-client-ca", "", "Path to the CA bundle verifying client certificates of PD Assistant instances, requires TLS serving")
+	flag.Var(cfg.ListValue{List: &config.PeerAuthConfig.AllowedSANs}, "peer-allowed-sans", "DNS, IP, URI or email SANs of authorized PD Assistant client certificates (comma-separated)")
+	flag.Var(cfg.ListValue{List: &config.PeerAuthConfig.AllowedCNs}, "peer-allowed-cns", "Subject common names of authorized PD Assistant client certificates (comma-separated), any certificate signed by the client CA if both allowlists are empty")
+	flag.Var(cfg.IdentityScopesValue{IdentityScopes: &config.PeerAuthConfig.IdentityScopes}, "peer-identity-scopes", "Scopes of client certificates by authorized SAN or CN, e.g. ops.example.com=status:read|admin:write,pd-assistant.eu=peer:read|peer:write. Identities without scopes get peer:read, peer:write and status:read")
+	flag.Var(cfg.ListValue{List: &config.PDAssistantURLs}, "pd-assistant-urls", "List of PD Assistant URLs (comma-separated). Overrides --pd-assistant-host-prefix and ignores --pd-address auto-discovery if provided")
 	flag.BoolVar(&config.PDAssistantConsensus, "pd-assistant-consensus", false, "Require consensus from all PD Assistant instances before updating the certificate")
 	// Certificate parameters
-	flag.StringVar(&certFilePath, "certificate-file", "/app/conf/", "Path to a Certificate YAML file to be used as a template")
+	flag.StringVar(&config.CertificateFilePath, "certificate-file", "/app/conf/", "Path to a Certificate YAML file to be used as a template")
+	flag.StringVar(&config.IssuerMode, "issuer-mode", cfg.IssuerModeCertManager, "How the certificate is issued: cert-manager, certificate-request or local-ca")
+	flag.StringVar(&config.LocalCAConfig.SecretName, "local-ca-secret-name", "", "Name of the Secret with the CA keypair for local-ca issuer mode")
+	flag.StringVar(&config.LocalCAConfig.SecretNamespace, "local-ca-secret-namespace", "", "Namespace of the Secret with the CA keypair, defaults to the certificate namespace")
+	flag.StringVar(&config.LocalCAConfig.CertPath, "local-ca-cert", "", "Path to the CA certificate for local-ca issuer mode, alternatively to --local-ca-secret-name")
+	flag.StringVar(&config.LocalCAConfig.KeyPath, "local-ca-key", "", "Path to the CA private key for local-ca issuer mode, alternatively to --local-ca-secret-name")
+	flag.IntVar(&config.ReconcileConfig.TemplateReloadInterval, "certificate-file-reload-interval", 30, "Interval for checking the certificate template file for changes in seconds, 0 disables it")
+	flag.IntVar(&config.CertIssuanceTimeout, "cert-issuance-timeout", 600, "Time to wait for cert-manager to issue an updated certificate, in seconds")
+	// Leader election parameters
+	flag.BoolVar(&config.LeaderElectionConfig.Enabled, "leader-elect", false, "Enable Lease based leader election, only the leader writes certificates")
+	flag.StringVar(&config.LeaderElectionConfig.LeaseName, "leader-elect-lease-name", "pd-assistant", "Name of the Lease used for leader election")
+	flag.StringVar(&config.LeaderElectionConfig.LeaseNamespace, "leader-elect-lease-namespace", "", "Namespace of the Lease used for leader election, defaults to the certificate namespace")
+	flag.StringVar(&config.LeaderElectionConfig.Identity, "leader-elect-identity", hostname, "Identity of this replica in leader election")
+	flag.IntVar(&config.LeaderElectionConfig.LeaseDuration, "leader-elect-lease-duration", 15, "Leader election lease duration in seconds")
+	flag.IntVar(&config.LeaderElectionConfig.RenewDeadline, "leader-elect-renew-deadline", 10, "Leader election renew deadline in seconds")
+	flag.IntVar(&config.LeaderElectionConfig.RetryPeriod, "leader-elect-retry-period", 2, "Leader election retry period in seconds")
+	// PD parameters
+	flag.StringVar(&config.PDConfig.Address, "pd-address", "", "PD address (host:port) used for PD API calls such as health checks")
+	flag.StringVar(&config.PDConfig.TLSConfig.CertPath, "pd-tls-cert", "", "Path to the client certificate for PD API calls")
+	flag.StringVar(&config.PDConfig.TLSConfig.KeyPath, "pd-tls-key", "", "Path to the client key for PD API calls")
+	flag.StringVar(&config.PDConfig.TLSConfig.CAPath, "pd-tls-ca", "", "Path to the CA certificate for PD API calls, enables https")
+	flag.BoolVar(&config.PDConfig.TLSConfig.Insecure, "pd-tls-insecure", false, "Skip TLS verification for PD API calls (not recommended)")
+	flag.IntVar(&config.PDConfig.HTTPRequestTimeout, "pd-http-request-timeout", 5, "Timeout of PD API calls and PD endpoint verification, in seconds")
+	flag.IntVar(&config.EndpointVerifyInterval, "pd-endpoint-verify-interval", 300, "Interval for verifying certificates served by PD client and peer endpoints in seconds, 0 disables it")
+	// Post-issuance parameters
+	flag.StringVar(&config.PostIssuanceConfig.Action, "post-issuance-action", cfg.PostIssuanceActionNone, "Action to make PD pick up a newly issued certificate: none, annotate-statefulset, annotate-tidbcluster or reload-endpoint")
+	flag.StringVar(&config.PostIssuanceConfig.TargetName, "post-issuance-target-name", "", "Name of the PD StatefulSet or TidbCluster to annotate")
+	flag.StringVar(&config.PostIssuanceConfig.TargetNamespace, "post-issuance-target-namespace", "", "Namespace of the PD StatefulSet or TidbCluster to annotate")
+	flag.StringVar(&config.PostIssuanceConfig.ReloadURL, "post-issuance-reload-url", "", "URL called with POST to reload PD certificates")
+	flag.IntVar(&config.PostIssuanceConfig.MinInterval, "post-issuance-min-interval", 1800, "Minimum interval between two PD reloads, in seconds")
 	// PD discovery parameters
 	flag.StringVar(&config.PDDiscoveryConfig.URL, "pd-discovery-url", "", "PD Discovery service URL")
 	flag.StringVar(&config.PDDiscoveryConfig.TiDBCLusterName, "pd-discovery-tidb-cluster-name", "", "TiDB cluster name for PD Discovery service")
 	flag.StringVar(&config.PDDiscoveryConfig.TiDBCLusterNameSpace, "pd-discovery-tidb-cluster-namespace", "", "TiDB cluster namespace for PD Discovery service")
-	flag.Parse()
+	flag.IntVar(&config.PDDiscoveryConfig.HTTPRequestTimeout, "pd-discovery-http-request-timeout", 5, "Timeout of PD Discovery service calls, in seconds")
+
+	// Subcommands may come before or after flags, e.g. "config dump --config pd-assistant.yaml"
+	command := parseCommandLine(flag.CommandLine, os.Args[1:])
 
 	// Show and exit functions
 	if showVersion {
@@ -60,8 +147,36 @@ func main() {
 		os.Exit(0)
 	}
 
+	// Merge the config file and environment variables, flags take precedence
+	if err := mergeConfig(&config, configFile); err != nil {
+		glog.Fatalf("Failed to load config: %v", err)
+	}
+	switch command {
+	case "":
+	case "validate":
+		// Report all errors at once, files referenced by the config are loaded too.
+		// Template checks would only repeat that the template failed to load.
+		updateErr := config.Update()
+		opts := cfg.ValidateOptions{SkipTemplate: errors.Is(updateErr, cfg.ErrCertificateTemplate), SkipSecrets: validateSkipSecrets}
+		if err := errors.Join(updateErr, config.ValidateWith(opts)); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("Configuration is valid")
+		os.Exit(0)
+	case "config dump":
+		dump, err := config.Dump()
+		if err != nil {
+			glog.Fatalf("Failed to dump config: %v", err)
+		}
+		fmt.Print(string(dump))
+		os.Exit(0)
+	default:
+		glog.Fatalf("Unknown command %q, available commands: validate, config dump", command)
+	}
+
 	// Update config
-	if err := config.Update(pdAssistantURLs, certFilePath); err != nil {
+	if err := config.Update(); err != nil {
 		glog.Fatalf("Failed to update config: %v", err)
 	}
 
@@ -72,7 +187,7 @@ func main() {
 
 	// Init k8s client
 	kubeClient := k8s.Client{}
-	err := kubeClient.Init(kubeconfig)
+	err := kubeClient.Init(config.KubeConfigPath)
 	if err != nil {
 		glog.Fatalf("Failed to initialize Kubernetes client: %v", err)
 	}
@@ -85,20 +200,143 @@ func main() {
 	if len(config.PDDiscoveryConfig.URL) > 0 {
 		glog.V(4).Infof("PD Discovery URL: %s", config.PDDiscoveryConfig.URL)
 	}
-	glog.V(4).Infof("Loaded certificate YAML file %q: name=%s, namespace=%s", certFilePath, config.Certificate.Name, config.Certificate.Namespace)
+	glog.V(4).Infof("Loaded certificate YAML file %q: name=%s, namespace=%s", config.CertificateFilePath, config.Certificate.Name, config.Certificate.Namespace)
 	if config.PDAssistantConsensus {
 		glog.V(4).Infof("PD Assistant consensus check is enabled")
 	} else {
 		glog.V(4).Infof("PD Assistant consensus check is disabled")
 	}
 
+	// Stop everything on SIGTERM or SIGINT
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	// Load bearer tokens before serving or calling peers
+	if err := srv.LoadTokens(ctx, config, kubeClient); err != nil {
+		glog.Fatalf("Failed to load bearer tokens: %v", err)
+	}
+	// Load IP overrides before the first reconcile, so excluded IPs never make it into the certificate.
+	// A broken overrides file must not crash-loop the pod, start without overrides and report it in the status.
+	if err := srv.LoadIPOverrides(ctx, config, kubeClient); err != nil {
+		glog.Errorf("Starting without IP overrides: %v", err)
+	}
+
 	// Let's rock and roll!
+	// Elect the leader allowed to write certificates.
+	// The Lease is only released after all loops stopped, so no certificate write races a new leader.
+	leaderCtx, stopLeaderElection := context.WithCancel(context.Background())
+	leaderDone := srv.RunLeaderElection(leaderCtx, config, kubeClient)
+
+	var loops sync.WaitGroup
+	runLoop := func(loop func()) {
+		loops.Add(1)
+		go func() {
+			defer loops.Done()
+			loop()
+		}()
+	}
+
 	// Watch CliliumNode IPs and update the state
-	go srv.IPWatchLoop(config, kubeClient)
+	runLoop(func() { srv.IPWatchLoop(ctx, config, kubeClient) })
 
 	// Watch all pd-assistant IPs and update the certificate if needed
-	go srv.FetchIPsAndUpdateCertLoop(config, kubeClient)
+	runLoop(func() { srv.FetchIPsAndUpdateCertLoop(ctx, config, kubeClient) })
+
+	// Exchange pd-assistant membership with peers
+	runLoop(func() { srv.GossipLoop(ctx, config) })
+
+	// Reload the certificate template when the file changes
+	runLoop(func() { srv.TemplateWatchLoop(ctx, config) })
+
+	// Watch the issued certificate and check it matches the desired SANs
+	runLoop(func() { srv.CertWatchLoop(ctx, config, kubeClient) })
+
+	// Renew the local CA certificate before it expires, even while peers are unreachable
+	runLoop(func() { srv.LocalCARenewalLoop(ctx, config, kubeClient) })
+
+	// Reload PD when the certificate in the Secret changed
+	runLoop(func() { srv.PDReloadLoop(ctx, config, kubeClient) })
+
+	// Verify certificates served by PD endpoints
+	runLoop(func() { srv.EndpointVerifyLoop(ctx, config) })
 
-	// Start the main web server
-	srv.RunMainWebServer(config, listen)
+	// Reload the serving certificate from its Secret
+	runLoop(func() { srv.ServingCertificateLoop(ctx, config, kubeClient) })
+
+	// Reload bearer tokens from their file or Secret
+	runLoop(func() { srv.TokenWatchLoop(ctx, config, kubeClient) })
+	runLoop(func() { srv.IPOverridesWatchLoop(ctx, config, kubeClient) })
+
+	// Start the main web server, it returns after draining requests on shutdown
+	if err := srv.RunMainWebServer(ctx, config, kubeClient, config.Listen); err != nil {
+		glog.Fatalf("Web server failed: %v", err)
+	}
+
+	// Let an in-flight certificate update finish before giving up the leadership
+	glog.Info("Waiting for background loops to stop")
+	loops.Wait()
+	stopLeaderElection()
+	<-leaderDone
+	glog.Info("Shutdown complete")
+	glog.Flush()
+}
+
+// subcommand splits the words before the first flag off the command line arguments, a lone "-" is a word.
+func subcommand(args []string) (string, []string) {
+	i := 0
+	for i < len(args) && (args[i] == "-" || !strings.HasPrefix(args[i], "-")) {
+		i++
+	}
+	return strings.Join(args[:i], " "), args[i:]
+}
+
+// parseCommandLine parses the flags and returns the subcommand, made of all words which aren't flags or flag values.
+// Parsing stops at the first word, so the flags following it are parsed in another round.
+func parseCommandLine(flags *flag.FlagSet, args []string) string {
+	var words []string
+	for len(args) > 0 {
+		word, rest := subcommand(args)
+		if word != "" {
+			words = append(words, word)
+		}
+		flags.Parse(rest)
+		// Every round consumes a word or a flag, stop anyway if one didn't
+		if len(flags.Args()) >= len(args) {
+			words = append(words, flags.Args()...)
+			break
+		}
+		args = flags.Args()
+	}
+	return strings.Join(words, " ")
+}
+
+// mergeConfig applies the config file and PDCA_* environment variables to the config,
+// then re-applies flags set on the command line so they take precedence.
+func mergeConfig(config *cfg.AppConfig, configFile string) error {
+	explicit := map[string]string{}
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = f.Value.String() })
+
+	if configFile == "" {
+		configFile = os.Getenv(cfg.EnvPrefix + "CONFIG")
+	}
+	if configFile != "" {
+		if err := config.LoadFile(configFile); err != nil {
+			return err
+		}
+		glog.V(4).Infof("Loaded config file %s", configFile)
+	}
+	applied, err := config.ApplyEnv(os.LookupEnv)
+	if err != nil {
+		return err
+	}
+	if len(applied) > 0 {
+		glog.V(4).Infof("Applied environment variables %v", applied)
+	}
+
+	for name, value := range explicit {
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("failed to re-apply flag --%s: %v", name, err)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"testing"
+)
+
+func TestParseCommandLine(t *testing.T) {
+	tests := []struct {
+		args    []string
+		command string
+		config  string
+	}{
+		{[]string{"config", "dump", "--config", "c.yaml"}, "config dump", "c.yaml"},
+		{[]string{"--config", "c.yaml", "validate"}, "validate", "c.yaml"},
+		{[]string{"config", "--config", "c.yaml", "dump", "-v", "4"}, "config dump", "c.yaml"},
+		{[]string{"--config", "c.yaml", "--", "validate"}, "validate", "c.yaml"},
+		{[]string{"--config", "c.yaml"}, "", "c.yaml"},
+		// A lone "-" is an unknown command instead of looping forever
+		{[]string{"-"}, "-", ""},
+		{[]string{"--config", "c.yaml", "-"}, "-", "c.yaml"},
+	}
+	for _, test := range tests {
+		flags := flag.NewFlagSet("pd-cert-assistant", flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		config := flags.String("config", "", "")
+		flags.Int("v", 0, "")
+		if command := parseCommandLine(flags, test.args); command != test.command || *config != test.config {
+			t.Errorf("Expected command %q and config %q for %v, got %q and %q", test.command, test.config, test.args, command, *config)
+		}
+	}
+}